@@ -0,0 +1,109 @@
+// Package audit delivers security audit events to pluggable sinks.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xlog"
+)
+
+// Sink receives audit events. Implementations must be safe for concurrent use.
+type Sink interface {
+	Write(ctx context.Context, e *model.AuditEvent) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, e *model.AuditEvent) error
+
+func (f SinkFunc) Write(ctx context.Context, e *model.AuditEvent) error {
+	return f(ctx, e)
+}
+
+type loggerSink struct {
+	logger *xlog.Logger
+}
+
+// NewLogger returns a sink writing every event as a structured log line.
+func NewLogger(logger *xlog.Logger) Sink {
+	return &loggerSink{logger: logger}
+}
+
+func (s *loggerSink) Write(ctx context.Context, e *model.AuditEvent) error {
+	fields := []xlog.Attr{
+		xlog.Str("event", string(e.Type)),
+		xlog.Str("outcome", string(e.Outcome)),
+		xlog.Str("ip", e.IP),
+		xlog.Str("userAgent", e.UserAgent),
+	}
+	if e.Actor != "" {
+		fields = append(fields, xlog.Uid(e.Actor))
+	}
+	if e.ClientID != "" {
+		fields = append(fields, xlog.Cid(e.ClientID))
+	}
+	if e.Detail != "" {
+		fields = append(fields, xlog.Str("detail", e.Detail))
+	}
+	if e.Outcome == model.AuditSuccess {
+		s.logger.Info("audit", fields...)
+	} else {
+		s.logger.Warn("audit", fields...)
+	}
+	return nil
+}
+
+type storageSink struct {
+	db model.Storage
+}
+
+// NewStorage returns a sink persisting events through the storage.
+func NewStorage(db model.Storage) Sink {
+	return &storageSink{db: db}
+}
+
+func (s *storageSink) Write(ctx context.Context, e *model.AuditEvent) error {
+	return s.db.CreateAuditEvent(ctx, e)
+}
+
+type fileSink struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFile returns a sink appending events as JSON lines to the named file.
+func NewFile(name string) (Sink, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *fileSink) Write(ctx context.Context, e *model.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(e)
+}
+
+type multiSink []Sink
+
+// Multi returns a sink duplicating events to all the given sinks.
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) Write(ctx context.Context, e *model.AuditEvent) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
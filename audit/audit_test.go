@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xlog"
+)
+
+type recorder []*model.AuditEvent
+
+func (r *recorder) Write(ctx context.Context, e *model.AuditEvent) error {
+	*r = append(*r, e)
+	return nil
+}
+
+type clientStorage struct {
+	model.Storage
+	client *model.Client
+}
+
+func (s *clientStorage) CreateClient(ctx context.Context, client *model.Client) error {
+	s.client = client
+	return nil
+}
+
+func (s *clientStorage) UpdateClient(ctx context.Context, id string, updater func(c *model.Client) (*model.Client, error)) error {
+	c, err := updater(s.client)
+	if err != nil {
+		return err
+	}
+	s.client = c
+	return nil
+}
+
+func (s *clientStorage) DeleteClient(ctx context.Context, id string) error {
+	s.client = nil
+	return nil
+}
+
+func TestWrap(t *testing.T) {
+	var events recorder
+	db := Wrap(&clientStorage{}, &events, xlog.NewText(xlog.LevelError))
+	ctx := context.Background()
+	db.CreateClient(ctx, &model.Client{ID: "app"})
+	db.UpdateClient(ctx, "app", func(c *model.Client) (*model.Client, error) {
+		c.Name = "App"
+		return c, nil
+	})
+	db.UpdateClient(ctx, "app", func(c *model.Client) (*model.Client, error) {
+		c.Status = model.ClientStatusBanned
+		return c, nil
+	})
+	db.UpdateClient(ctx, "app", func(c *model.Client) (*model.Client, error) {
+		return nil, errors.New("rejected")
+	})
+	db.DeleteClient(ctx, "app")
+
+	want := []string{"created", "updated", "banned", "deleted"}
+	if len(events) != len(want) {
+		t.Fatalf("%d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Type != model.AuditClientChanged || e.ClientID != "app" || e.Detail != want[i] {
+			t.Errorf("event %d = %+v, want %s", i, e, want[i])
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events recorder
+	multi := Multi(sink, &events)
+	for _, typ := range []model.AuditEventType{model.AuditLogin, model.AuditLogout} {
+		if err := multi.Write(context.Background(), model.NewAuditEvent(typ, model.AuditSuccess)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines int
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		var e model.AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != events[lines].ID {
+			t.Errorf("line %d = %+v, want %+v", lines, e, events[lines])
+		}
+	}
+	if lines != 2 {
+		t.Errorf("%d lines written, want 2", lines)
+	}
+}
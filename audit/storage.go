@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"context"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xlog"
+)
+
+// storage emits client change events for client mutations made through the
+// wrapped storage.
+type storage struct {
+	model.Storage
+	sink   Sink
+	logger *xlog.Logger
+}
+
+// Wrap returns a storage which writes a client_changed event to sink after
+// every successful client creation, update and deletion.
+func Wrap(db model.Storage, sink Sink, logger *xlog.Logger) model.Storage {
+	return &storage{Storage: db, sink: sink, logger: logger}
+}
+
+func (s *storage) write(ctx context.Context, clientID, detail string) {
+	e := model.NewAuditEvent(model.AuditClientChanged, model.AuditSuccess)
+	e.ClientID = clientID
+	e.Detail = detail
+	if err := s.sink.Write(ctx, e); err != nil {
+		s.logger.Error("failed to write audit event", xlog.Str("event", string(e.Type)), xlog.Err(err))
+	}
+}
+
+func (s *storage) CreateClient(ctx context.Context, client *model.Client) error {
+	if err := s.Storage.CreateClient(ctx, client); err != nil {
+		return err
+	}
+	s.write(ctx, client.ID, "created")
+	return nil
+}
+
+func (s *storage) UpdateClient(ctx context.Context, id string, updater func(c *model.Client) (*model.Client, error)) error {
+	detail := "updated"
+	err := s.Storage.UpdateClient(ctx, id, func(c *model.Client) (*model.Client, error) {
+		status := c.Status
+		nc, err := updater(c)
+		if err == nil && nc.Status != status {
+			switch nc.Status {
+			case model.ClientStatusBanned:
+				detail = "banned"
+			case model.ClientStatusDeleted:
+				detail = "deleted"
+			default:
+				detail = "restored"
+			}
+		}
+		return nc, err
+	})
+	if err != nil {
+		return err
+	}
+	s.write(ctx, id, detail)
+	return nil
+}
+
+func (s *storage) DeleteClient(ctx context.Context, id string) error {
+	if err := s.Storage.DeleteClient(ctx, id); err != nil {
+		return err
+	}
+	s.write(ctx, id, "deleted")
+	return nil
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -9,32 +10,75 @@ type Cache[T any] interface {
 	Get(key string) (T, error)
 	Set(key string, value T, ttl time.Duration) error
 	Delete(key string) error
+	// Update atomically replaces the value under key with the result of fn,
+	// which receives the current value and whether it was found.
+	Update(key string, ttl time.Duration, fn func(value T, ok bool) T) (T, error)
+}
+
+type memoryItem[T any] struct {
+	value  T
+	expiry time.Time
 }
 
 type memoryCache[T any] struct {
-	cache map[string]T
+	mu    sync.Mutex
+	cache map[string]memoryItem[T]
 }
 
 func NewMemory[T any]() Cache[T] {
 	return &memoryCache[T]{
-		cache: make(map[string]T),
+		cache: make(map[string]memoryItem[T]),
 	}
 }
 
 func (c *memoryCache[T]) Get(key string) (T, error) {
-	value, ok := c.cache[key]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.cache[key]
+	if ok && !item.expiry.IsZero() && time.Now().After(item.expiry) {
+		delete(c.cache, key)
+		ok = false
+	}
 	if !ok {
 		var zero T
 		return zero, fmt.Errorf("key %s not found", key)
 	}
-	return value, nil
+	return item.value, nil
 }
 
+// Set stores the value under key. A ttl of zero or less keeps the value until it is deleted.
 func (c *memoryCache[T]) Set(key string, value T, ttl time.Duration) error {
-	c.cache[key] = value
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item := memoryItem[T]{value: value}
+	if ttl > 0 {
+		item.expiry = time.Now().Add(ttl)
+	}
+	c.cache[key] = item
 	return nil
 }
+func (c *memoryCache[T]) Update(key string, ttl time.Duration, fn func(value T, ok bool) T) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.cache[key]
+	if ok && !item.expiry.IsZero() && time.Now().After(item.expiry) {
+		ok = false
+	}
+	if !ok {
+		var zero T
+		item.value = zero
+	}
+	item = memoryItem[T]{value: fn(item.value, ok)}
+	if ttl > 0 {
+		item.expiry = time.Now().Add(ttl)
+	}
+	c.cache[key] = item
+	return item.value, nil
+}
+
 func (c *memoryCache[T]) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	delete(c.cache, key)
 	return nil
 }
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"sutext.github.io/suid/guid"
+)
+
+type AuditEventType string
+
+const (
+	AuditLogin           AuditEventType = "login"
+	AuditLoginFailed     AuditEventType = "login_failed"
+	AuditLogout          AuditEventType = "logout"
+	AuditRegister        AuditEventType = "register"
+	AuditPasswordChanged AuditEventType = "password_changed"
+	AuditAccountDeleted  AuditEventType = "account_deleted"
+	AuditMFAEnrolled     AuditEventType = "mfa_enrolled"
+	AuditMFADisabled     AuditEventType = "mfa_disabled"
+	AuditPasskeyAdded    AuditEventType = "passkey_added"
+	AuditPasskeyRemoved  AuditEventType = "passkey_removed"
+	AuditConsentApproved AuditEventType = "consent_approved"
+	AuditCodeIssued      AuditEventType = "code_issued"
+	AuditTokenIssued     AuditEventType = "token_issued"
+	AuditTokenRefreshed  AuditEventType = "token_refreshed"
+	AuditTokenRevoked    AuditEventType = "token_revoked"
+	AuditClientChanged   AuditEventType = "client_changed"
+	AuditAdminAction     AuditEventType = "admin_action"
+)
+
+type AuditOutcome string
+
+const (
+	AuditSuccess AuditOutcome = "success"
+	AuditFailure AuditOutcome = "failure"
+	AuditDenied  AuditOutcome = "denied"
+)
+
+// AuditEvent is a single entry of the security audit trail.
+type AuditEvent struct {
+	ID        string         `json:"id" gorm:"primary_key"`
+	Type      AuditEventType `json:"type" gorm:"index"`
+	Time      time.Time      `json:"time" gorm:"index"`
+	Actor     string         `json:"actor,omitempty"`
+	ClientID  string         `json:"client_id,omitempty"`
+	IP        string         `json:"ip,omitempty"`
+	UserAgent string         `json:"user_agent,omitempty"`
+	Outcome   AuditOutcome   `json:"outcome"`
+	Detail    string         `json:"detail,omitempty"`
+}
+
+func NewAuditEvent(typ AuditEventType, outcome AuditOutcome) *AuditEvent {
+	return &AuditEvent{
+		ID:      guid.New().String(),
+		Type:    typ,
+		Time:    time.Now(),
+		Outcome: outcome,
+	}
+}
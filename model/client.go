@@ -1,6 +1,13 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
 	"sutext.github.io/suid/guid"
 )
 
@@ -20,25 +27,174 @@ const (
 	ClientStatusDeleted ClientStatus = 2
 )
 
+// PKCEPolicy controls whether a client must use PKCE in the authorization code flow.
+type PKCEPolicy string
+
+const (
+	// PKCERequired requires a code challenge with any supported method. It is the default.
+	PKCERequired PKCEPolicy = ""
+	// PKCERequiredS256 requires a code challenge using the S256 method.
+	PKCERequiredS256 PKCEPolicy = "S256"
+	// PKCEOptional lets confidential clients omit the code challenge.
+	PKCEOptional PKCEPolicy = "optional"
+)
+
 type Client struct {
-	ID           string       `json:"id"`
-	Name         string       `json:"name"`
-	Type         ClientType   `json:"type"`
-	Status       ClientStatus `json:"status"`
-	Secret       string       `json:"secret"`
-	Scopes       Strings      `json:"scopes"`
-	Public       bool         `json:"public,omitempty"`
-	LogoURL      string       `json:"logo_url,omitempty"`
-	Description  string       `json:"description,omitempty"`
-	RedirectURIs Strings      `json:"redirect_uris,omitempty"`
-	TrustedPeers Strings      `json:"trusted_peers,omitempty"`
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Type   ClientType   `json:"type"`
+	Status ClientStatus `json:"status"`
+	Secret string       `json:"secret"`
+	Scopes Strings      `json:"scopes"`
+	// Deprecated: Public is ignored, set Type to ClientTypePublic instead.
+	Public       bool    `json:"public,omitempty"`
+	LogoURL      string  `json:"logo_url,omitempty"`
+	Description  string  `json:"description,omitempty"`
+	RedirectURIs Strings `json:"redirect_uris,omitempty"`
+	TrustedPeers Strings `json:"trusted_peers,omitempty"`
+	// PostLogoutRedirectURIs are where the client may send the user after logout.
+	PostLogoutRedirectURIs Strings `json:"post_logout_redirect_uris,omitempty"`
+	// BackchannelLogoutURI receives logout tokens when a session of the client's
+	// users ends, FrontchannelLogoutURI is loaded by the browser in an iframe.
+	// The session required flags ask for the sid of the ended session.
+	BackchannelLogoutURI              string `json:"backchannel_logout_uri,omitempty"`
+	BackchannelLogoutSessionRequired  bool   `json:"backchannel_logout_session_required,omitempty"`
+	FrontchannelLogoutURI             string `json:"frontchannel_logout_uri,omitempty"`
+	FrontchannelLogoutSessionRequired bool   `json:"frontchannel_logout_session_required,omitempty"`
+	// RequireMFA only lets users in who authenticated with a second factor.
+	RequireMFA bool `json:"require_mfa,omitempty"`
+	// GrantTypes and ResponseTypes restrict the server wide supported values.
+	// An empty GrantTypes allows every supported grant type, an empty ResponseTypes only "code".
+	GrantTypes     Strings       `json:"grant_types,omitempty"`
+	ResponseTypes  Strings       `json:"response_types,omitempty"`
+	PKCEPolicy     PKCEPolicy    `json:"pkce_policy,omitempty"`
+	AccessTokenTTL time.Duration `json:"access_token_ttl,omitempty"`
+	// AccessTokenFormat overrides the server wide format of the client's access tokens.
+	AccessTokenFormat AccessTokenFormat `json:"access_token_format,omitempty"`
+	RefreshTokenTTL   time.Duration     `json:"refresh_token_ttl,omitempty"`
+	// JWKS is the client's JSON Web Key Set, used to encrypt responses to the client.
+	JWKS string `json:"jwks,omitempty"`
+	// AuthorizationEncryptedResponseAlg and AuthorizationEncryptedResponseEnc enable
+	// the encryption of JWT secured authorization responses when the alg is set.
+	AuthorizationEncryptedResponseAlg string `json:"authorization_encrypted_response_alg,omitempty"`
+	AuthorizationEncryptedResponseEnc string `json:"authorization_encrypted_response_enc,omitempty"`
+	// UserinfoSignedResponseAlg, UserinfoEncryptedResponseAlg and UserinfoEncryptedResponseEnc
+	// turn userinfo responses into signed and/or encrypted JWTs.
+	UserinfoSignedResponseAlg    string `json:"userinfo_signed_response_alg,omitempty"`
+	UserinfoEncryptedResponseAlg string `json:"userinfo_encrypted_response_alg,omitempty"`
+	UserinfoEncryptedResponseEnc string `json:"userinfo_encrypted_response_enc,omitempty"`
+	// AuthorizationDetailsTypes restricts the authorization details types the
+	// client may request. Empty allows every supported type.
+	AuthorizationDetailsTypes Strings `json:"authorization_details_types,omitempty"`
+	// ResourceServer lets the client introspect the tokens of every client.
+	// Other clients only learn about the tokens issued to or targeted at them.
+	ResourceServer bool `json:"resource_server,omitempty"`
+	// TokenExchange describes the token exchanges the client may perform.
+	TokenExchange TokenExchangePolicy `json:"token_exchange,omitzero"`
+	// SubjectType of the subject identifiers issued to the client, public by default.
+	SubjectType SubjectType `json:"subject_type,omitempty"`
+	// SectorIdentifierURI groups the clients sharing pairwise subjects by its host.
+	SectorIdentifierURI string `json:"sector_identifier_uri,omitempty"`
+}
+
+// Sector returns the sector identifier of the client's pairwise subjects: the
+// host of the sector identifier URI, or else the host of the redirect URIs,
+// which must all share a single host.
+func (c *Client) Sector() (string, error) {
+	if c.SectorIdentifierURI != "" {
+		u, err := url.Parse(c.SectorIdentifierURI)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("invalid sector identifier uri %q", c.SectorIdentifierURI)
+		}
+		return u.Host, nil
+	}
+	var host string
+	for _, uri := range c.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("invalid redirect uri %q", uri)
+		}
+		if host != "" && host != u.Host {
+			return "", fmt.Errorf("redirect uris of client %s span several hosts, a sector identifier uri is required", c.ID)
+		}
+		host = u.Host
+	}
+	if host == "" {
+		return "", fmt.Errorf("client %s has no sector", c.ID)
+	}
+	return host, nil
+}
+
+// AllowsAuthorizationDetailsType reports whether the client may request authorization details of the type.
+func (c *Client) AllowsAuthorizationDetailsType(typ string) bool {
+	return len(c.AuthorizationDetailsTypes) == 0 || slices.Contains(c.AuthorizationDetailsTypes, typ)
+}
+
+// TokenExchangePolicy restricts the token exchange grant of a client.
+// The zero policy allows no exchange at all.
+type TokenExchangePolicy struct {
+	// Audiences are the audiences and resources the client may request tokens for.
+	Audiences Strings `json:"audiences,omitempty"`
+	// SubjectClients are the clients whose tokens may be exchanged. Empty accepts any client.
+	SubjectClients Strings `json:"subject_clients,omitempty"`
+	// Impersonation allows tokens issued for the subject without an actor.
+	Impersonation bool `json:"impersonation,omitempty"`
+	// Delegation allows tokens carrying the actor token's subject in the act claim.
+	Delegation bool `json:"delegation,omitempty"`
+}
+
+// AllowsAudience reports whether a token may be issued for the audience.
+func (p TokenExchangePolicy) AllowsAudience(aud string) bool {
+	return slices.Contains(p.Audiences, aud)
 }
 
+// AllowsSubjectClient reports whether tokens issued to the client may be exchanged.
+func (p TokenExchangePolicy) AllowsSubjectClient(clientID string) bool {
+	return len(p.SubjectClients) == 0 || slices.Contains(p.SubjectClients, clientID)
+}
+
+func (p *TokenExchangePolicy) Scan(src any) error {
+	data, ok := src.([]byte)
+	if !ok || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, p)
+}
+
+func (p TokenExchangePolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+func (p *TokenExchangePolicy) GormDataType() string {
+	return "blob"
+}
+
+// NewClient returns a confidential client with a generated id and secret.
 func NewClient() *Client {
 	return &Client{
 		ID:     guid.New().String(),
-		Type:   ClientTypePublic,
+		Type:   ClientTypeConfidential,
 		Status: ClientStatusNormal,
 		Secret: guid.New().String(),
 	}
 }
+
+// Active reports whether the client may obtain authorizations and tokens.
+func (c *Client) Active() bool {
+	return c.Status == ClientStatusNormal
+}
+
+// IsPublic reports whether the client cannot keep a secret, so that it is
+// never authenticated with one. Type is the only source of this.
+func (c *Client) IsPublic() bool {
+	return c.Type == ClientTypePublic
+}
+
+// AllowsGrantType reports whether the client may use the grant type.
+// Public clients can never use grant types which do not involve the end user's consent.
+func (c *Client) AllowsGrantType(gt string) bool {
+	if c.IsPublic() && (gt == "client_credentials" || gt == "password") {
+		return false
+	}
+	return len(c.GrantTypes) == 0 || slices.Contains(c.GrantTypes, gt)
+}
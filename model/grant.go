@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"sutext.github.io/entry/rar"
+	"sutext.github.io/entry/scope"
+	"sutext.github.io/suid"
+)
+
+// Grant records the scopes a user has consented to for a client.
+type Grant struct {
+	UserID   suid.SUID    `json:"user_id" gorm:"primary_key;autoIncrement:false"`
+	ClientID string       `json:"client_id" gorm:"primary_key"`
+	Scopes   scope.Scopes `json:"scopes"`
+	// AuthorizationDetails the user has consented to in addition to the scopes.
+	AuthorizationDetails rar.Details `json:"authorization_details,omitempty"`
+	// Claims the user has consented to release beyond the claims of the scopes.
+	Claims    Strings   `json:"claims,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
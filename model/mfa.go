@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"sutext.github.io/suid"
+)
+
+// TOTP is the time-based one-time password authenticator of a user.
+// https://www.rfc-editor.org/rfc/rfc6238
+type TOTP struct {
+	UserID suid.SUID `json:"user_id" gorm:"primary_key"`
+	// Secret is the base32 encoded shared secret.
+	Secret string `json:"-"`
+	// Confirmed is set once the user proved the authenticator works, only
+	// confirmed authenticators are asked for at login.
+	Confirmed bool `json:"confirmed"`
+	// LastStep is the time step of the last accepted code, codes of it and
+	// earlier steps are never accepted again.
+	LastStep  int64     `json:"last_step"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecoveryCode is a one-time code standing in for the second factor when the
+// authenticator is lost. Only the hash of the code is stored as the ID.
+type RecoveryCode struct {
+	ID        string    `json:"id" gorm:"primary_key"`
+	UserID    suid.SUID `json:"user_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -16,9 +16,19 @@ func AutoMigrate(db *gorm.DB) {
 		&User{},
 		&Client{},
 		&AccessToken{},
+		&Session{},
+		&TOTP{},
+		&RecoveryCode{},
+		&Passkey{},
 		&AuthRequest{},
 		&RefreshToken{},
 		&AuthCode{},
+		&Grant{},
+		&AuditEvent{},
+		&Webhook{},
+		&DeadLetter{},
+		&Resource{},
+		&PairwiseSubject{},
 	)
 }
 
@@ -58,10 +68,68 @@ type PKCE struct {
 	CodeChallengeMethod string
 }
 
-// Claims represents the ID Token claims supported by the server.
+// Claims describes the claims about a user released to a client.
 type Claims struct {
 	UserID   suid.SUID
 	ClientID string
+	// Requested are the names of the claims to release, requested through the
+	// scopes or individually with the claims request parameter.
+	Requested []string
+}
+
+// ClaimRequest is an individual claim request of the claims request parameter.
+// A nil ClaimRequest requests the claim without further constraints.
+type ClaimRequest struct {
+	Essential bool  `json:"essential,omitempty"`
+	Value     any   `json:"value,omitempty"`
+	Values    []any `json:"values,omitempty"`
+}
+
+// Matches reports whether the value satisfies the requested value or values.
+func (r *ClaimRequest) Matches(v any) bool {
+	if r == nil || (r.Value == nil && len(r.Values) == 0) {
+		return true
+	}
+	// claim values are compared in their JSON form, numbers decode as float64
+	got, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	for _, want := range append([]any{r.Value}, r.Values...) {
+		if want == nil {
+			continue
+		}
+		if data, err := json.Marshal(want); err == nil && string(data) == string(got) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimsRequest is the claims request parameter of an authorization request,
+// asking for individual claims in the userinfo response and the ID token.
+// https://openid.net/specs/openid-connect-core-1_0.html#ClaimsParameter
+type ClaimsRequest struct {
+	UserInfo map[string]*ClaimRequest `json:"userinfo,omitempty"`
+	IDToken  map[string]*ClaimRequest `json:"id_token,omitempty"`
+}
+
+func (c ClaimsRequest) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *ClaimsRequest) Scan(src any) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return nil
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, c)
+}
+func (c ClaimsRequest) GormDataType() string {
+	return "blob"
 }
 
 func (p PKCE) Value() (driver.Value, error) {
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"sutext.github.io/suid"
+)
+
+// Passkey is a WebAuthn credential of a user, usable as the only factor of a
+// login or as the second factor after the password.
+type Passkey struct {
+	// ID is the base64url encoded credential id.
+	ID        string    `json:"id" gorm:"primary_key"`
+	UserID    suid.SUID `json:"user_id" gorm:"index"`
+	Name      string    `json:"name"`
+	PublicKey []byte    `json:"-"`
+	// SignCount is the signature counter of the last assertion, a counter not
+	// exceeding it reveals a cloned authenticator.
+	SignCount uint32 `json:"-"`
+	// AAGUID identifies the authenticator model, Attestation is the attestation
+	// format of the registration.
+	AAGUID      string     `json:"aaguid,omitempty"`
+	Attestation string     `json:"attestation"`
+	Transports  Strings    `json:"transports,omitempty"`
+	BackedUp    bool       `json:"backed_up"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
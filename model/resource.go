@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// Resource is a protected resource, e.g. an API, tokens can be requested for
+// with a resource indicator. The ID is the absolute URI of the resource.
+type Resource struct {
+	ID          string    `json:"id" gorm:"primary_key"`
+	Name        string    `json:"name"`
+	Scopes      Strings   `json:"scopes"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AllowsScope reports whether tokens for the resource may carry the scope.
+func (r *Resource) AllowsScope(scope string) bool {
+	return r.Scopes.Contains(scope)
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"sutext.github.io/suid"
+)
+
+// Session is the browser session of a signed in end-user. The cookie holds a
+// random value, only its hash is stored as the ID.
+type Session struct {
+	ID       string    `json:"id" gorm:"primary_key"`
+	UserID   suid.SUID `json:"user_id" gorm:"index"`
+	AuthTime time.Time `json:"auth_time"`
+	// Amr are the methods the user authenticated with.
+	Amr       Strings   `json:"amr,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	LastSeen  time.Time `json:"last_seen"`
+	// ExpiresAt is the absolute end of the session, however active it is.
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	// Clients the user signed in to through the session, they are notified
+	// when the session ends.
+	Clients   Strings   `json:"clients,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -2,8 +2,10 @@ package model
 
 import (
 	"context"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"sutext.github.io/suid"
 	"sutext.github.io/suid/guid"
 )
@@ -50,10 +52,50 @@ type Storage interface {
 	GetUserByPhone(ctx context.Context, phone string) (*User, error)
 	CreateUser(ctx context.Context, user *User) error
 	UpdateUser(ctx context.Context, user *User) error
-
-	GetToken(ctx context.Context, id suid.SUID) (*AccessToken, error)
+	// DeleteUser removes the user together with everything tied to the account:
+	// sessions, tokens, grants, second factors and pairwise subjects.
+	DeleteUser(ctx context.Context, id suid.SUID) error
+
+	GetTOTP(ctx context.Context, userID suid.SUID) (*TOTP, error)
+	SaveTOTP(ctx context.Context, totp *TOTP) error
+	DeleteTOTP(ctx context.Context, userID suid.SUID) error
+	// UseTOTPStep records the time step of an accepted code of the user, it
+	// fails when the step or a later one has been used already.
+	UseTOTPStep(ctx context.Context, userID suid.SUID, step int64) error
+	// SaveRecoveryCodes replaces the recovery codes of the user.
+	SaveRecoveryCodes(ctx context.Context, userID suid.SUID, codes []*RecoveryCode) error
+	// UseRecoveryCode removes the recovery code of the user, it fails when the
+	// user has no such code.
+	UseRecoveryCode(ctx context.Context, userID suid.SUID, id string) error
+
+	GetPasskey(ctx context.Context, id string) (*Passkey, error)
+	ListPasskeys(ctx context.Context, userID suid.SUID) ([]*Passkey, error)
+	CreatePasskey(ctx context.Context, passkey *Passkey) error
+	UpdatePasskey(ctx context.Context, passkey *Passkey) error
+	// DeletePasskey removes a passkey of the user, it fails when the user has
+	// no such passkey.
+	DeletePasskey(ctx context.Context, userID suid.SUID, id string) error
+	DeletePasskeys(ctx context.Context, userID suid.SUID) error
+
+	GetToken(ctx context.Context, id string) (*AccessToken, error)
 	CreateToken(ctx context.Context, token *AccessToken) error
 	DeleteToken(ctx context.Context, token *AccessToken) error
+	// DeleteTokens removes the access tokens of a user issued to a client.
+	DeleteTokens(ctx context.Context, userID suid.SUID, clientID string) error
+	// PurgeTokens removes the access tokens expired before the time.
+	PurgeTokens(ctx context.Context, before time.Time) (int64, error)
+
+	GetSession(ctx context.Context, id string) (*Session, error)
+	CreateSession(ctx context.Context, session *Session) error
+	UpdateSession(ctx context.Context, session *Session) error
+	DeleteSession(ctx context.Context, id string) error
+	// ListUserSessions returns the sessions of the user.
+	ListUserSessions(ctx context.Context, userID suid.SUID) ([]*Session, error)
+	// DeleteUserSessions removes the sessions of the user but the kept one and
+	// every refresh token of the user.
+	DeleteUserSessions(ctx context.Context, userID suid.SUID, keep string) error
+	// PurgeSessions removes the sessions last seen or expiring before the times.
+	PurgeSessions(ctx context.Context, idleBefore, expiresBefore time.Time) (int64, error)
 
 	GetClient(ctx context.Context, id string) (*Client, error)
 	CreateClient(ctx context.Context, client *Client) error
@@ -76,8 +118,40 @@ type Storage interface {
 	CreateRefresh(ctx context.Context, r RefreshToken) error
 	DeleteRefresh(ctx context.Context, id guid.GUID) error
 	UpdateRefresh(ctx context.Context, id guid.GUID, updater func(r RefreshToken) (RefreshToken, error)) error
+	// DeleteSessionRefreshes removes the refresh tokens issued in the browser session.
+	DeleteSessionRefreshes(ctx context.Context, sessionID string) error
+	// DeleteRefreshes removes every refresh token of the user issued to the client
+	// and returns how many there were.
+	DeleteRefreshes(ctx context.Context, userID suid.SUID, clientID string) (int64, error)
 
 	CreateTokenInfo(ctx context.Context) (*TokenInfo, error)
+
+	GetGrant(ctx context.Context, userID suid.SUID, clientID string) (*Grant, error)
+	SaveGrant(ctx context.Context, g *Grant) error
+	DeleteGrant(ctx context.Context, userID suid.SUID, clientID string) error
+	ListGrants(ctx context.Context, userID suid.SUID) ([]*Grant, error)
+
+	CreateAuditEvent(ctx context.Context, e *AuditEvent) error
+	ListAuditEvents(ctx context.Context, since time.Time, limit int) ([]*AuditEvent, error)
+
+	GetWebhook(ctx context.Context, id string) (*Webhook, error)
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	DeleteWebhook(ctx context.Context, id string) error
+	ListWebhooks(ctx context.Context) ([]*Webhook, error)
+
+	GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+	CreateDeadLetter(ctx context.Context, d *DeadLetter) error
+	DeleteDeadLetter(ctx context.Context, id string) error
+	ListDeadLetters(ctx context.Context) ([]*DeadLetter, error)
+
+	GetResource(ctx context.Context, id string) (*Resource, error)
+	CreateResource(ctx context.Context, r *Resource) error
+	DeleteResource(ctx context.Context, id string) error
+	ListResources(ctx context.Context) ([]*Resource, error)
+
+	GetPairwiseSubject(ctx context.Context, id string) (*PairwiseSubject, error)
+	// SavePairwiseSubject records the subject, saving an existing subject again is a no-op.
+	SavePairwiseSubject(ctx context.Context, p *PairwiseSubject) error
 }
 type Driver interface {
 	Open() (db *gorm.DB, err error)
@@ -162,9 +236,112 @@ func (s *storage) UpdateUser(ctx context.Context, user *User) error {
 	return s.db.WithContext(ctx).Save(user).Error
 }
 
-func (s *storage) GetToken(ctx context.Context, id suid.SUID) (*AccessToken, error) {
+func (s *storage) DeleteUser(ctx context.Context, id suid.SUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, v := range []any{
+			&Session{},
+			&RefreshToken{},
+			&AccessToken{},
+			&Grant{},
+			&TOTP{},
+			&RecoveryCode{},
+			&Passkey{},
+			&PairwiseSubject{},
+		} {
+			if err := tx.Delete(v, "user_id = ?", id).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&User{}, id).Error
+	})
+}
+
+// Below is TOTP and RecoveryCode implementations
+func (s *storage) GetTOTP(ctx context.Context, userID suid.SUID) (*TOTP, error) {
+	var totp TOTP
+	err := s.db.WithContext(ctx).First(&totp, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+func (s *storage) SaveTOTP(ctx context.Context, totp *TOTP) error {
+	return s.db.WithContext(ctx).Save(totp).Error
+}
+func (s *storage) DeleteTOTP(ctx context.Context, userID suid.SUID) error {
+	return s.db.WithContext(ctx).Delete(&TOTP{}, "user_id = ?", userID).Error
+}
+func (s *storage) UseTOTPStep(ctx context.Context, userID suid.SUID, step int64) error {
+	result := s.db.WithContext(ctx).Model(&TOTP{}).
+		Where("user_id = ? AND last_step < ?", userID, step).
+		Update("last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+func (s *storage) SaveRecoveryCodes(ctx context.Context, userID suid.SUID, codes []*RecoveryCode) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&RecoveryCode{}, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(codes).Error
+	})
+}
+func (s *storage) UseRecoveryCode(ctx context.Context, userID suid.SUID, id string) error {
+	result := s.db.WithContext(ctx).Delete(&RecoveryCode{}, "id = ? AND user_id = ?", id, userID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Below is Passkey implementations
+func (s *storage) GetPasskey(ctx context.Context, id string) (*Passkey, error) {
+	var passkey Passkey
+	err := s.db.WithContext(ctx).First(&passkey, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+func (s *storage) ListPasskeys(ctx context.Context, userID suid.SUID) ([]*Passkey, error) {
+	var passkeys []*Passkey
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&passkeys).Error
+	return passkeys, err
+}
+func (s *storage) CreatePasskey(ctx context.Context, passkey *Passkey) error {
+	return s.db.WithContext(ctx).Create(passkey).Error
+}
+func (s *storage) UpdatePasskey(ctx context.Context, passkey *Passkey) error {
+	return s.db.WithContext(ctx).Save(passkey).Error
+}
+func (s *storage) DeletePasskey(ctx context.Context, userID suid.SUID, id string) error {
+	result := s.db.WithContext(ctx).Delete(&Passkey{}, "id = ? AND user_id = ?", id, userID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+func (s *storage) DeletePasskeys(ctx context.Context, userID suid.SUID) error {
+	return s.db.WithContext(ctx).Delete(&Passkey{}, "user_id = ?", userID).Error
+}
+
+func (s *storage) GetToken(ctx context.Context, id string) (*AccessToken, error) {
 	var token AccessToken
-	err := s.db.WithContext(ctx).First(&token, id).Error
+	err := s.db.WithContext(ctx).First(&token, "id = ?", id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -179,6 +356,51 @@ func (s *storage) DeleteToken(ctx context.Context, token *AccessToken) error {
 	return s.db.WithContext(ctx).Delete(token).Error
 }
 
+func (s *storage) DeleteTokens(ctx context.Context, userID suid.SUID, clientID string) error {
+	return s.db.WithContext(ctx).Delete(&AccessToken{}, "user_id = ? AND client_id = ?", userID, clientID).Error
+}
+
+func (s *storage) PurgeTokens(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Delete(&AccessToken{}, "expiry_in < ?", before)
+	return result.RowsAffected, result.Error
+}
+
+// Below is Session implementations
+func (s *storage) GetSession(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	err := s.db.WithContext(ctx).First(&session, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+func (s *storage) CreateSession(ctx context.Context, session *Session) error {
+	return s.db.WithContext(ctx).Create(session).Error
+}
+func (s *storage) UpdateSession(ctx context.Context, session *Session) error {
+	return s.db.WithContext(ctx).Save(session).Error
+}
+func (s *storage) DeleteSession(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Delete(&Session{}, "id = ?", id).Error
+}
+func (s *storage) ListUserSessions(ctx context.Context, userID suid.SUID) ([]*Session, error) {
+	var sessions []*Session
+	err := s.db.WithContext(ctx).Find(&sessions, "user_id = ?", userID).Error
+	return sessions, err
+}
+func (s *storage) DeleteUserSessions(ctx context.Context, userID suid.SUID, keep string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Session{}, "user_id = ? AND id <> ?", userID, keep).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&RefreshToken{}, "user_id = ?", userID).Error
+	})
+}
+func (s *storage) PurgeSessions(ctx context.Context, idleBefore, expiresBefore time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Delete(&Session{}, "last_seen < ? OR expires_at < ?", idleBefore, expiresBefore)
+	return result.RowsAffected, result.Error
+}
+
 // Below is Client implementations
 func (s *storage) GetClient(ctx context.Context, id string) (*Client, error) {
 	var client Client
@@ -301,6 +523,15 @@ func (s *storage) DeleteRefresh(ctx context.Context, id guid.GUID) error {
 	return s.db.WithContext(ctx).Delete(RefreshToken{}, "id = ?", id).Error
 }
 
+func (s *storage) DeleteSessionRefreshes(ctx context.Context, sessionID string) error {
+	return s.db.WithContext(ctx).Delete(RefreshToken{}, "session_id = ?", sessionID).Error
+}
+
+func (s *storage) DeleteRefreshes(ctx context.Context, userID suid.SUID, clientID string) (int64, error) {
+	result := s.db.WithContext(ctx).Delete(RefreshToken{}, "user_id = ? AND client_id = ?", userID, clientID)
+	return result.RowsAffected, result.Error
+}
+
 func (s *storage) UpdateRefresh(ctx context.Context, id guid.GUID, updater func(r RefreshToken) (RefreshToken, error)) error {
 	var refresh RefreshToken
 	err := s.db.WithContext(ctx).First(&refresh, "id = ?", id).Error
@@ -317,3 +548,125 @@ func (s *storage) UpdateRefresh(ctx context.Context, id guid.GUID, updater func(
 func (s *storage) CreateTokenInfo(ctx context.Context) (ti *TokenInfo, err error) {
 	return ti, nil
 }
+
+// Below is Grant implementations
+func (s *storage) GetGrant(ctx context.Context, userID suid.SUID, clientID string) (*Grant, error) {
+	var g Grant
+	err := s.db.WithContext(ctx).First(&g, "user_id = ? AND client_id = ?", userID, clientID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+func (s *storage) SaveGrant(ctx context.Context, g *Grant) error {
+	return s.db.WithContext(ctx).Save(g).Error
+}
+func (s *storage) DeleteGrant(ctx context.Context, userID suid.SUID, clientID string) error {
+	return s.db.WithContext(ctx).Delete(&Grant{}, "user_id = ? AND client_id = ?", userID, clientID).Error
+}
+func (s *storage) ListGrants(ctx context.Context, userID suid.SUID) ([]*Grant, error) {
+	var grants []*Grant
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Find(&grants).Error
+	if err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// Below is AuditEvent implementations
+func (s *storage) CreateAuditEvent(ctx context.Context, e *AuditEvent) error {
+	return s.db.WithContext(ctx).Create(e).Error
+}
+func (s *storage) ListAuditEvents(ctx context.Context, since time.Time, limit int) ([]*AuditEvent, error) {
+	var events []*AuditEvent
+	err := s.db.WithContext(ctx).Where("time >= ?", since).Order("time").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Below is Webhook implementations
+func (s *storage) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	var w Webhook
+	err := s.db.WithContext(ctx).First(&w, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+func (s *storage) CreateWebhook(ctx context.Context, w *Webhook) error {
+	return s.db.WithContext(ctx).Create(w).Error
+}
+func (s *storage) DeleteWebhook(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Delete(&Webhook{}, "id = ?", id).Error
+}
+func (s *storage) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	var webhooks []*Webhook
+	err := s.db.WithContext(ctx).Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// Below is DeadLetter implementations
+func (s *storage) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	var d DeadLetter
+	err := s.db.WithContext(ctx).First(&d, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+func (s *storage) CreateDeadLetter(ctx context.Context, d *DeadLetter) error {
+	return s.db.WithContext(ctx).Create(d).Error
+}
+func (s *storage) DeleteDeadLetter(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Delete(&DeadLetter{}, "id = ?", id).Error
+}
+func (s *storage) ListDeadLetters(ctx context.Context) ([]*DeadLetter, error) {
+	var letters []*DeadLetter
+	err := s.db.WithContext(ctx).Order("created_at").Find(&letters).Error
+	if err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// Below is Resource implementations
+func (s *storage) GetResource(ctx context.Context, id string) (*Resource, error) {
+	var r Resource
+	err := s.db.WithContext(ctx).First(&r, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+func (s *storage) CreateResource(ctx context.Context, r *Resource) error {
+	return s.db.WithContext(ctx).Create(r).Error
+}
+func (s *storage) DeleteResource(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Delete(&Resource{}, "id = ?", id).Error
+}
+func (s *storage) ListResources(ctx context.Context) ([]*Resource, error) {
+	var resources []*Resource
+	err := s.db.WithContext(ctx).Find(&resources).Error
+	if err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// Below is PairwiseSubject implementations
+func (s *storage) GetPairwiseSubject(ctx context.Context, id string) (*PairwiseSubject, error) {
+	var p PairwiseSubject
+	err := s.db.WithContext(ctx).First(&p, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+func (s *storage) SavePairwiseSubject(ctx context.Context, p *PairwiseSubject) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(p).Error
+}
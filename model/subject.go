@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"sutext.github.io/suid"
+)
+
+// SubjectType selects the subject identifiers a client sees.
+type SubjectType string
+
+const (
+	// SubjectPublic gives every client the same subject, the user ID. It is the default.
+	SubjectPublic SubjectType = "public"
+	// SubjectPairwise gives every sector a different subject for the same user,
+	// so clients of unrelated sectors cannot correlate their users.
+	SubjectPairwise SubjectType = "pairwise"
+)
+
+// PairwiseSubject maps a pairwise subject identifier back to its user.
+type PairwiseSubject struct {
+	ID        string    `json:"id" gorm:"primary_key"`
+	Sector    string    `json:"sector"`
+	UserID    suid.SUID `json:"user_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
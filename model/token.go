@@ -7,17 +7,32 @@ import (
 	"time"
 
 	"github.com/go-jose/go-jose/v4"
+	"sutext.github.io/entry/rar"
 	"sutext.github.io/entry/scope"
 	"sutext.github.io/suid"
 	"sutext.github.io/suid/guid"
 )
 
+// AccessTokenFormat selects how access tokens are issued.
+type AccessTokenFormat string
+
+const (
+	// AccessTokenJWT issues self-contained signed JWTs. It is the default.
+	AccessTokenJWT AccessTokenFormat = "jwt"
+	// AccessTokenOpaque issues random reference tokens which are resolved
+	// through the storage, so they can be revoked instantly.
+	AccessTokenOpaque AccessTokenFormat = "opaque"
+)
+
+// AccessToken is an opaque access token. Only the hash of the token value is
+// stored as the ID, together with the claims the token stands for.
 type AccessToken struct {
-	ID          string    `gorm:"primary_key"`
-	UserID      suid.SUID `json:"user_id"`
-	ClientID    string    `json:"client_id"`
-	ExpiryIn    time.Time `json:"expiry_in"`
-	AccessToken *string   `json:"access_token"`
+	ID        string    `json:"id" gorm:"primary_key"`
+	UserID    suid.SUID `json:"user_id" gorm:"index"`
+	ClientID  string    `json:"client_id"`
+	Claims    string    `json:"claims"`
+	ExpiryIn  time.Time `json:"expiry_in" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // RefreshToken is an OAuth2 refresh token which allows a client to request new
@@ -28,6 +43,19 @@ type RefreshToken struct {
 	ClientID  string    `json:"client_id"`
 	Nonce     string    `json:"nonce"`
 	Scope     string    `json:"scope"`
+	Resources Strings   `json:"resources,omitempty"`
+	// AuthorizationDetails granted with the refresh token.
+	AuthorizationDetails rar.Details `json:"authorization_details,omitempty"`
+	// Claims requested individually, they are released again on refresh.
+	Claims   ClaimsRequest `json:"claims,omitzero"`
+	AuthTime time.Time     `json:"auth_time"`
+	Acr      string        `json:"acr,omitempty"`
+	Amr      Strings       `json:"amr,omitempty"`
+	// SessionID is the browser session the token is issued in. The token ends
+	// with the session unless offline access is granted.
+	SessionID string `json:"session_id,omitempty" gorm:"index"`
+	// JKT is the thumbprint of the DPoP key the token is bound to.
+	JKT       string    `json:"jkt,omitempty"`
 	ExpiryIn  time.Time `json:"expiry_in"`
 	LastUsed  time.Time `json:"last_used"`
 	CreatedAt time.Time `json:"created_at"`
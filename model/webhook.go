@@ -0,0 +1,48 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Webhook is a subscription to identity lifecycle events delivered to URL.
+type Webhook struct {
+	ID          string    `json:"id" gorm:"primary_key"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	Events      Strings   `json:"events,omitempty"`
+	Active      bool      `json:"active"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Subscribed reports whether the webhook wants the event. An empty filter matches
+// every event, and a filter ending in ".*" matches every event with that prefix.
+func (w *Webhook) Subscribed(event string) bool {
+	if !w.Active {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, f := range w.Events {
+		if f == "*" || f == event {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(f, "*"); ok && strings.HasPrefix(event, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter is a webhook delivery which failed after all retries.
+type DeadLetter struct {
+	ID        string    `json:"id" gorm:"primary_key"`
+	WebhookID string    `json:"webhook_id" gorm:"index"`
+	Event     string    `json:"event"`
+	Payload   string    `json:"payload"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+}
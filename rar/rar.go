@@ -0,0 +1,130 @@
+// Package rar implements the authorization details of Rich Authorization Requests.
+//
+// Authorization details are JSON objects carrying a "type" which selects the
+// schema the rest of the object has to satisfy, e.g.
+//
+//	[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"100"}}]
+//
+// See https://www.rfc-editor.org/rfc/rfc9396
+package rar
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// Detail is a single authorization details object.
+type Detail map[string]any
+
+// Type returns the authorization details type of the object.
+func (d Detail) Type() string {
+	t, _ := d["type"].(string)
+	return t
+}
+
+// Details is the value of the authorization_details parameter.
+type Details []Detail
+
+// Parse decodes the authorization_details parameter. An empty value yields no details.
+func Parse(s string) (Details, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var d Details
+	if err := json.Unmarshal([]byte(s), &d); err != nil {
+		return nil, fmt.Errorf("malformed authorization details: %w", err)
+	}
+	for i, detail := range d {
+		if detail.Type() == "" {
+			return nil, fmt.Errorf("authorization details %d has no type", i)
+		}
+	}
+	return d, nil
+}
+
+// Contains reports whether every object of other is also in d.
+func (d Details) Contains(other Details) bool {
+	for _, o := range other {
+		if !d.contains(o) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d Details) contains(detail Detail) bool {
+	for _, v := range d {
+		if reflect.DeepEqual(v, detail) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge returns d with the objects of other which are not in d yet.
+func (d Details) Merge(other Details) Details {
+	merged := slices.Clone(d)
+	for _, o := range other {
+		if !merged.contains(o) {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+// Types returns the distinct types of the objects.
+func (d Details) Types() []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, detail := range d {
+		if t := detail.Type(); !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func (d Details) String() string {
+	if len(d) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(d)
+	return string(data)
+}
+
+func (d Details) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+func (d *Details) Scan(src any) error {
+	data, ok := src.([]byte)
+	if !ok || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, d)
+}
+
+func (d Details) GormDataType() string {
+	return "blob"
+}
+
+// Registry maps the supported authorization details types to their schemas.
+type Registry map[string]*Schema
+
+// Validate checks that every object has a registered type and satisfies its schema.
+func (r Registry) Validate(d Details) error {
+	for _, detail := range d {
+		schema, ok := r[detail.Type()]
+		if !ok {
+			return fmt.Errorf("unsupported authorization details type %q", detail.Type())
+		}
+		if err := schema.Validate(map[string]any(detail)); err != nil {
+			return fmt.Errorf("%s: %w", detail.Type(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package rar
+
+import "testing"
+
+const paymentSchema = `{
+	"type": "object",
+	"required": ["instructedAmount"],
+	"additionalProperties": false,
+	"properties": {
+		"instructedAmount": {
+			"type": "object",
+			"required": ["currency", "amount"],
+			"properties": {
+				"currency": {"type": "string", "enum": ["EUR", "USD"]},
+				"amount": {"type": "number", "minimum": 0, "maximum": 100}
+			}
+		},
+		"creditorAccount": {"type": "string", "minLength": 1}
+	}
+}`
+
+func TestRegistryValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(paymentSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := Registry{"payment_initiation": schema}
+	for in, valid := range map[string]bool{
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":50}}]`:         true,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":500}}]`:        false,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"GBP","amount":5}}]`:          false,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR"}}]`:                     false,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":5},"x":true}]`: false,
+		`[{"type":"account_information"}]`:                                                          false,
+	} {
+		d, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reg.Validate(d); (err == nil) != valid {
+			t.Errorf("Validate(%s) = %v, want valid %v", in, err, valid)
+		}
+	}
+	if _, err := Parse(`[{"actions":["read"]}]`); err == nil {
+		t.Error("details without type must be rejected")
+	}
+}
+
+func TestDetailsContains(t *testing.T) {
+	granted, _ := Parse(`[{"type":"a","actions":["read"]},{"type":"b"}]`)
+	requested, _ := Parse(`[{"type":"a","actions":["read"]}]`)
+	if !granted.Contains(requested) {
+		t.Error("granted details must contain the requested subset")
+	}
+	other, _ := Parse(`[{"type":"a","actions":["write"]}]`)
+	if granted.Contains(other) {
+		t.Error("granted details must not contain other details")
+	}
+	if merged := granted.Merge(other); len(merged) != 3 {
+		t.Errorf("merged %d details, want 3", len(merged))
+	}
+}
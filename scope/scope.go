@@ -30,14 +30,40 @@ func Parse(scopes string) Scopes {
 func (s Scopes) Contains(scope string) bool {
 	return slices.Contains(s, scope)
 }
+
+// ContainsAll reports whether every scope of other is in s.
+func (s Scopes) ContainsAll(other Scopes) bool {
+	for _, sc := range other {
+		if !s.Contains(sc) {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge returns the union of s and other, keeping the order of s.
+func (s Scopes) Merge(other Scopes) Scopes {
+	merged := slices.Clone(s)
+	for _, sc := range other {
+		if !merged.Contains(sc) {
+			merged = append(merged, sc)
+		}
+	}
+	return merged
+}
 func (s Scopes) String() string {
 	return strings.Join(s, " ")
 }
-func (s Scopes) Value() driver.Value {
-	return s.String()
+func (s Scopes) Value() (driver.Value, error) {
+	return s.String(), nil
 }
 func (s *Scopes) Scan(src any) error {
-	*s = Parse(src.(string))
+	switch v := src.(type) {
+	case string:
+		*s = Parse(v)
+	case []byte:
+		*s = Parse(string(v))
+	}
 	return nil
 }
 func (s Scopes) Validate() (hasOpenID bool, unrecognized, peerIDs []string) {
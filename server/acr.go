@@ -0,0 +1,96 @@
+package server
+
+import (
+	"slices"
+)
+
+// Authentication method references of the login methods.
+// https://www.rfc-editor.org/rfc/rfc8176
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRSoftwareKey = "swk"
+	AMRSMS         = "sms"
+	AMRMultiFactor = "mfa"
+)
+
+// Authentication context class references, ordered from the weakest to the strongest.
+const (
+	// ACRSingleFactor is an authentication with a single factor, e.g. a password.
+	ACRSingleFactor = "urn:entry:acr:sfa"
+	// ACRMultiFactor is an authentication with two or more factors, e.g. a
+	// password and a code, or a passkey verifying the user.
+	ACRMultiFactor = "urn:entry:acr:mfa"
+)
+
+var acrLevels = []string{ACRSingleFactor, ACRMultiFactor}
+
+// acrOf returns the authentication context class reached with the methods.
+// A method recorded as mfa, e.g. a passkey verifying the user, counts as
+// multi-factor on its own.
+func acrOf(amr []string) string {
+	factors := 0
+	for _, m := range amr {
+		switch m {
+		case AMRPassword, AMROTP, AMRSMS, AMRHardwareKey, AMRSoftwareKey:
+			factors++
+		case AMRMultiFactor:
+			return ACRMultiFactor
+		}
+	}
+	switch {
+	case factors >= 2:
+		return ACRMultiFactor
+	case factors == 1:
+		return ACRSingleFactor
+	default:
+		return ""
+	}
+}
+
+// withMethod adds a method to the references, marking multi-factor authentications.
+func withMethod(amr []string, method string) []string {
+	if !slices.Contains(amr, method) {
+		amr = append(slices.Clone(amr), method)
+	}
+	if acrOf(amr) == ACRMultiFactor && !slices.Contains(amr, AMRMultiFactor) {
+		amr = append(amr, AMRMultiFactor)
+	}
+	return amr
+}
+
+// acrSatisfies reports whether the class reaches one of the requested classes.
+// Unknown classes are ignored, a request without known classes is always met.
+func acrSatisfies(acr string, requested []string) bool {
+	level := slices.Index(acrLevels, acr)
+	known := false
+	for _, r := range requested {
+		want := slices.Index(acrLevels, r)
+		if want < 0 {
+			continue
+		}
+		if level >= want {
+			return true
+		}
+		known = true
+	}
+	return !known
+}
+
+// requestedACR returns the classes the authentication has to reach: the
+// acr_values of the request and the values of an essential acr claim request.
+func requestedACR(req *AuthorizeRequest) []string {
+	requested := slices.Clone(req.ACRValues)
+	if c := req.Claims.IDToken["acr"]; c != nil && c.Essential {
+		if v, ok := c.Value.(string); ok {
+			requested = append(requested, v)
+		}
+		for _, v := range c.Values {
+			if v, ok := v.(string); ok {
+				requested = append(requested, v)
+			}
+		}
+	}
+	return requested
+}
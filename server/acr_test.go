@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid"
+)
+
+func TestStepUp(t *testing.T) {
+	s := newTestServer()
+	db := &mfaStorage{totp: &model.TOTP{Confirmed: true}}
+	s.db = db
+	pwd := &authSession{AuthTime: time.Now(), Amr: []string{AMRPassword}, Acr: acrOf([]string{AMRPassword})}
+	amr := withMethod(pwd.Amr, AMROTP)
+	mfa := &authSession{AuthTime: time.Now(), Amr: amr, Acr: acrOf(amr)}
+	if pwd.Acr != ACRSingleFactor || mfa.Acr != ACRMultiFactor {
+		t.Fatalf("acr = %q and %q", pwd.Acr, mfa.Acr)
+	}
+	if len(amr) != 3 || amr[2] != AMRMultiFactor {
+		t.Errorf("amr = %v, want mfa marked", amr)
+	}
+	// a passkey counts as a factor, verifying the user makes it two
+	if acr := acrOf(withMethod(nil, AMRHardwareKey)); acr != ACRSingleFactor {
+		t.Errorf("passkey without user verification: acr = %q", acr)
+	}
+	if acr := acrOf(withMethod([]string{AMRSoftwareKey}, AMRMultiFactor)); acr != ACRMultiFactor {
+		t.Errorf("passkey verifying the user: acr = %q", acr)
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  *AuthorizeRequest
+		sess *authSession
+		err  error
+	}{
+		{"no request", &AuthorizeRequest{}, pwd, nil},
+		{"unknown class", &AuthorizeRequest{ACRValues: []string{"urn:other"}}, pwd, nil},
+		{"acr_values met", &AuthorizeRequest{ACRValues: []string{ACRSingleFactor}}, pwd, nil},
+		{"acr_values step-up", &AuthorizeRequest{ACRValues: []string{ACRMultiFactor}}, pwd, xerr.ErrLoginRequired},
+		{"stronger session", &AuthorizeRequest{ACRValues: []string{ACRSingleFactor}}, mfa, nil},
+		{"essential claim", &AuthorizeRequest{Claims: model.ClaimsRequest{IDToken: map[string]*model.ClaimRequest{
+			"acr": {Essential: true, Values: []any{ACRMultiFactor}},
+		}}}, pwd, xerr.ErrLoginRequired},
+		{"voluntary claim", &AuthorizeRequest{Claims: model.ClaimsRequest{IDToken: map[string]*model.ClaimRequest{
+			"acr": {Value: ACRMultiFactor},
+		}}}, pwd, nil},
+	} {
+		if err := s.checkAuthentication(t.Context(), &model.Client{}, tc.req, tc.sess); err != tc.err {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.err)
+		}
+	}
+	client := &model.Client{RequireMFA: true}
+	if err := s.checkAuthentication(t.Context(), client, &AuthorizeRequest{}, pwd); err != xerr.ErrLoginRequired {
+		t.Errorf("mfa policy with single factor: err = %v", err)
+	}
+	if err := s.checkAuthentication(t.Context(), client, &AuthorizeRequest{}, mfa); err != nil {
+		t.Errorf("mfa policy with second factor: err = %v", err)
+	}
+	// without a second factor the user cannot step up at all
+	db.totp = nil
+	if err := s.checkAuthentication(t.Context(), client, &AuthorizeRequest{}, pwd); err != xerr.ErrUnmetAuthenticationRequirements {
+		t.Errorf("mfa policy without second factor: err = %v", err)
+	}
+	req := &AuthorizeRequest{ACRValues: []string{ACRMultiFactor}}
+	if err := s.checkAuthentication(t.Context(), &model.Client{}, req, pwd); err != xerr.ErrUnmetAuthenticationRequirements {
+		t.Errorf("acr_values without second factor: err = %v", err)
+	}
+}
+
+func TestUnmetAuthenticationRequirements(t *testing.T) {
+	s := newTestServer()
+	s.db = &mfaStorage{sessionStorage: sessionStorage{
+		clientStorage: clientStorage{clients: map[string]*model.Client{"spa": {
+			ID:           "spa",
+			Type:         model.ClientTypePublic,
+			Scopes:       model.Strings{"openid"},
+			RedirectURIs: model.Strings{"https://spa.example.com/cb"},
+			RequireMFA:   true,
+		}}},
+		sessions: map[string]*model.Session{},
+	}}
+	w := httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), suid.New(), []string{AMRPassword}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	query := url.Values{"client_id": {"spa"}, "response_type": {"code"}, "redirect_uri": {"https://spa.example.com/cb"},
+		"scope": {"openid"}, "state": {"xyz"}, "code_challenge": {strings.Repeat("a", 43)}, "code_challenge_method": {"S256"}}
+	w = httptest.NewRecorder()
+	s.handleAuthorize(w, httptest.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil))
+	reqid := strings.TrimPrefix(w.Header().Get("Location"), "/#/approve?reqid=")
+
+	// the user has no second factor, the client is told instead of the user
+	// being sent to a login which cannot meet the policy
+	r := httptest.NewRequest("GET", "/authorize/preview?reqid="+reqid, nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	s.handleAuthorizePreview(w, r)
+	var resp PreviewResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("preview status = %d, err = %v", w.Code, err)
+	}
+	u, err := url.Parse(resp.Redirect)
+	if err != nil || !strings.HasPrefix(resp.Redirect, "https://spa.example.com/cb?") {
+		t.Fatalf("redirect = %q", resp.Redirect)
+	}
+	if q := u.Query(); q.Get("error") != "unmet_authentication_requirements" || q.Get("state") != "xyz" {
+		t.Errorf("redirect query = %v", q)
+	}
+	if _, err := s.reqCache.Get(reqid); err == nil {
+		t.Error("request still cached")
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+	"sutext.github.io/suid/guid"
+)
+
+type resourceRequest struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	Description string   `json:"description"`
+}
+
+type webhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+}
+
+// ensureAdmin checks the bearer token against the configured admin token.
+// The admin API is disabled when no admin token is configured.
+func (s *server) ensureAdmin(r *http.Request) error {
+	if s.adminToken == "" {
+		return fmt.Errorf("admin api is disabled")
+	}
+	token, err := s.getToken(r)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		return fmt.Errorf("invalid admin token")
+	}
+	return nil
+}
+
+func (s *server) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("failed to encode response: " + err.Error())
+	}
+}
+
+func (s *server) handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	if err := s.ensureAdmin(r); err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := s.db.ListWebhooks(ctx)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, h := range hooks {
+			h.Secret = ""
+		}
+		s.writeJSON(w, http.StatusOK, hooks)
+	case http.MethodPost:
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.URL == "" {
+			s.writeError(w, http.StatusBadRequest, "url is empty")
+			return
+		}
+		hook := &model.Webhook{
+			ID:          guid.New().String(),
+			URL:         req.URL,
+			Secret:      guid.New().String(),
+			Events:      req.Events,
+			Active:      true,
+			Description: req.Description,
+			CreatedAt:   time.Now(),
+		}
+		if err := s.db.CreateWebhook(ctx, hook); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.audit(r, model.AuditAdminAction, model.AuditSuccess, "admin", "", "create webhook "+hook.ID)
+		s.writeJSON(w, http.StatusCreated, hook)
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *server) handleAdminWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.ensureAdmin(r); err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	ctx := r.Context()
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		hook, err := s.db.GetWebhook(ctx, id)
+		if err != nil {
+			s.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		hook.Secret = ""
+		s.writeJSON(w, http.StatusOK, hook)
+	case http.MethodDelete:
+		if err := s.db.DeleteWebhook(ctx, id); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.audit(r, model.AuditAdminAction, model.AuditSuccess, "admin", "", "delete webhook "+id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *server) handleAdminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := s.ensureAdmin(r); err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	letters, err := s.db.ListDeadLetters(r.Context())
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, letters)
+}
+
+func (s *server) handleAdminReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := s.ensureAdmin(r); err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	id := r.PathValue("id")
+	if err := s.webhooks.Replay(r.Context(), id); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.audit(r, model.AuditAdminAction, model.AuditSuccess, "admin", "", "replay dead letter "+id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *server) handleAdminResources(w http.ResponseWriter, r *http.Request) {
+	if err := s.ensureAdmin(r); err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		resources, err := s.db.ListResources(ctx)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.writeJSON(w, http.StatusOK, resources)
+	case http.MethodPost:
+		var req resourceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// resource indicators are absolute URIs without fragment
+		if u, err := url.Parse(req.ID); err != nil || !u.IsAbs() || u.Fragment != "" {
+			s.writeError(w, http.StatusBadRequest, "id must be an absolute uri")
+			return
+		}
+		resource := &model.Resource{
+			ID:          req.ID,
+			Name:        req.Name,
+			Scopes:      req.Scopes,
+			Description: req.Description,
+			CreatedAt:   time.Now(),
+		}
+		if err := s.db.CreateResource(ctx, resource); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.audit(r, model.AuditAdminAction, model.AuditSuccess, "admin", "", "create resource "+resource.ID)
+		s.writeJSON(w, http.StatusCreated, resource)
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAdminResource serves a single resource. The id is the escaped resource URI.
+func (s *server) handleAdminResource(w http.ResponseWriter, r *http.Request) {
+	if err := s.ensureAdmin(r); err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	ctx := r.Context()
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		resource, err := s.db.GetResource(ctx, id)
+		if err != nil {
+			s.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		s.writeJSON(w, http.StatusOK, resource)
+	case http.MethodDelete:
+		if err := s.db.DeleteResource(ctx, id); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.audit(r, model.AuditAdminAction, model.AuditSuccess, "admin", "", "delete resource "+id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAdminUserMFA resets the second factors of a user who lost them, TOTP
+// and passkeys alike. The user signs in with the password alone until
+// enrolling again.
+func (s *server) handleAdminUserMFA(w http.ResponseWriter, r *http.Request) {
+	if err := s.ensureAdmin(r); err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if r.Method != http.MethodDelete {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := r.PathValue("id")
+	userID, err := suid.Parse(id)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if err := s.resetMFA(r.Context(), userID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.audit(r, model.AuditMFADisabled, model.AuditSuccess, "admin", "", "reset mfa of user "+id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid"
+)
+
+const (
+	// defaultAssertionLifetime bounds the remaining lifetime of assertions
+	// whose issuer sets no MaxLifetime.
+	defaultAssertionLifetime = time.Hour
+	// defaultJWKSRefresh is how long keys read from a JWKS file are used.
+	defaultJWKSRefresh = 5 * time.Minute
+)
+
+var assertionAlgs = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// TrustedIssuer is an external identity system whose JWTs can be exchanged
+// for tokens of this server with the JWT bearer grant.
+type TrustedIssuer struct {
+	// Issuer must equal the iss claim of the assertions.
+	Issuer string
+	// JWKS holds the keys verifying the assertions.
+	JWKS *jose.JSONWebKeySet
+	// JWKSFile is read when JWKS is nil and read again every JWKSRefresh, five
+	// minutes by default, so rotated keys take effect without a restart.
+	JWKSFile    string
+	JWKSRefresh time.Duration
+	// MaxLifetime bounds how far in the future assertions may expire, one hour
+	// by default. Assertions must carry a jti, which is accepted only once.
+	MaxLifetime time.Duration
+	// Audiences accepted in the aud claim. When empty the issuer URL and the
+	// token endpoint URL of this server are accepted.
+	Audiences []string
+	// Subjects maps the subjects of the assertions to users or clients.
+	Subjects []SubjectMapping
+
+	file *jwksFile
+}
+
+// jwksFile holds the keys last read from a JWKS file.
+type jwksFile struct {
+	mu     sync.Mutex
+	keys   *jose.JSONWebKeySet
+	loaded time.Time
+}
+
+// SubjectMapping maps the subject of an assertion to a user or a client.
+type SubjectMapping struct {
+	// Subject matches the sub claim exactly, or as a prefix when it ends with "*".
+	Subject  string
+	UserID   string
+	ClientID string
+}
+
+func (m SubjectMapping) match(sub string) bool {
+	if prefix, ok := strings.CutSuffix(m.Subject, "*"); ok {
+		return strings.HasPrefix(sub, prefix)
+	}
+	return m.Subject == sub
+}
+
+func (ti *TrustedIssuer) keys() (*jose.JSONWebKeySet, error) {
+	if ti.JWKS != nil {
+		return ti.JWKS, nil
+	}
+	f := ti.file
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	refresh := ti.JWKSRefresh
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+	if f.keys != nil && time.Since(f.loaded) < refresh {
+		return f.keys, nil
+	}
+	jwks, err := readJWKS(ti.JWKSFile)
+	if err != nil {
+		if f.keys != nil {
+			// keep the last good keys while the file is being replaced
+			return f.keys, nil
+		}
+		return nil, err
+	}
+	f.keys, f.loaded = jwks, time.Now()
+	return jwks, nil
+}
+
+func readJWKS(name string) (*jose.JSONWebKeySet, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid jwks file %s: %w", name, err)
+	}
+	return &jwks, nil
+}
+
+func (ti *TrustedIssuer) maxLifetime() time.Duration {
+	if ti.MaxLifetime > 0 {
+		return ti.MaxLifetime
+	}
+	return defaultAssertionLifetime
+}
+
+func (ti *TrustedIssuer) mapping(sub string) (SubjectMapping, bool) {
+	for _, m := range ti.Subjects {
+		if m.match(sub) {
+			return m, true
+		}
+	}
+	return SubjectMapping{}, false
+}
+
+// verifyAssertion checks the signature, issuer, audience and lifetime of an
+// assertion and that it was not presented before.
+// https://www.rfc-editor.org/rfc/rfc7523#section-3
+func (s *server) verifyAssertion(assertion string) (*TrustedIssuer, *jwt.Claims, error) {
+	tok, err := jwt.ParseSigned(assertion, assertionAlgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unverified jwt.Claims
+	if err := tok.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		return nil, nil, err
+	}
+	ti, ok := s.trustedIssuers[unverified.Issuer]
+	if !ok {
+		return nil, nil, fmt.Errorf("untrusted issuer %q", unverified.Issuer)
+	}
+	jwks, err := ti.keys()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := jwks.Keys
+	if kid := tok.Headers[0].KeyID; kid != "" {
+		keys = jwks.Key(kid)
+	}
+	var claims jwt.Claims
+	err = fmt.Errorf("no key of %q verifies the assertion", ti.Issuer)
+	for _, key := range keys {
+		if err = tok.Claims(key.Key, &claims); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.Expiry == nil || claims.Subject == "" || claims.ID == "" {
+		return nil, nil, fmt.Errorf("assertion lacks exp, sub or jti")
+	}
+	audiences := ti.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{s.issuer, s.absURL(s.endpoints.Token)}
+	}
+	if !slices.ContainsFunc(audiences, claims.Audience.Contains) {
+		return nil, nil, fmt.Errorf("assertion audience %v not accepted", claims.Audience)
+	}
+	now := time.Now()
+	if err := claims.Validate(jwt.Expected{Issuer: ti.Issuer, Time: now}); err != nil {
+		return nil, nil, err
+	}
+	// long lived assertions would have to be remembered for long
+	lifetime := claims.Expiry.Time().Sub(now)
+	if lifetime > ti.maxLifetime() {
+		return nil, nil, fmt.Errorf("assertion expires in %s, more than %s", lifetime.Round(time.Second), ti.maxLifetime())
+	}
+	var replayed bool
+	s.assertionCache.Update(ti.Issuer+" "+claims.ID, max(lifetime, 0)+jwt.DefaultLeeway, func(_ bool, seen bool) bool {
+		replayed = seen
+		return true
+	})
+	if replayed {
+		return nil, nil, fmt.Errorf("assertion %s is replayed", claims.ID)
+	}
+	return ti, &claims, nil
+}
+
+// validateJWTBearerGrant issues an access token for the user or client a trusted
+// assertion is mapped to. Client authentication is optional, an authenticated
+// client becomes the audience of the token.
+// https://www.rfc-editor.org/rfc/rfc7523#section-2.1
+func (s *server) validateJWTBearerGrant(r *http.Request) (data map[string]any, err error) {
+	ctx := r.Context()
+	assertion := r.FormValue("assertion")
+	if assertion == "" {
+		return data, xerr.ErrInvalidRequest
+	}
+	var client *model.Client
+	if r.FormValue("client_id") != "" {
+		if client, err = s.authenticateClient(r, JWTBearer); err != nil {
+			return data, err
+		}
+	}
+	ti, assertionClaims, err := s.verifyAssertion(assertion)
+	if err != nil {
+		s.logger.Warn("rejected jwt bearer assertion: " + err.Error())
+		return data, xerr.ErrInvalidGrant
+	}
+	m, ok := ti.mapping(assertionClaims.Subject)
+	if !ok {
+		return data, xerr.ErrInvalidGrant
+	}
+	tgr := &TokenGenerateRequest{GrantType: JWTBearer, Request: r}
+	switch {
+	case m.UserID != "":
+		uid, err := suid.Parse(m.UserID)
+		if err != nil {
+			return data, xerr.ErrInvalidGrant
+		}
+		if _, err := s.db.GetUser(ctx, uid); err != nil {
+			return data, xerr.ErrInvalidGrant
+		}
+		tgr.UserID = uid.String()
+	case m.ClientID != "":
+		mapped, err := s.db.GetClient(ctx, m.ClientID)
+		if err != nil || !mapped.Active() || !mapped.AllowsGrantType(JWTBearer.String()) {
+			return data, xerr.ErrInvalidGrant
+		}
+		tgr.ClientID = mapped.ID
+		if client == nil {
+			client = mapped
+		}
+	default:
+		return data, xerr.ErrInvalidGrant
+	}
+	tgr.Scope = r.FormValue("scope")
+	if client != nil {
+		if !client.Scopes.Contains(tgr.Scope) {
+			return data, xerr.ErrInvalidScope
+		}
+		tgr.ClientID = client.ID
+		tgr.Client = client
+		tgr.Audience = []string{client.ID}
+	} else if tgr.Scope != "" {
+		return data, xerr.ErrInvalidScope
+	}
+	tgr.AccessTokenExp = s.accessTokenTTL(client)
+	accessToken, err := s.generateAccessToken(tgr)
+	if err != nil {
+		return data, err
+	}
+	data = s.getTokenData(tgr, accessToken)
+	s.audit(r, model.AuditTokenIssued, model.AuditSuccess, tgr.Subject(), tgr.ClientID, JWTBearer.String()+": "+ti.Issuer)
+	return data, nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+)
+
+func TestJWTBearerGrant(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(
+		WithIssuerURL("http://localhost:8080"),
+		WithTrustedIssuers(TrustedIssuer{
+			Issuer: "https://ci.example.com",
+			JWKS: &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+				{Key: &key.PublicKey, KeyID: "ci", Algorithm: string(jose.ES256)},
+			}},
+			Subjects: []SubjectMapping{{Subject: "repo:entry:*", ClientID: "deployer"}},
+		}),
+	).(*server)
+	s.db = &clientStorage{clients: map[string]*model.Client{
+		"deployer": {ID: "deployer", Type: model.ClientTypeConfidential},
+	}}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "ci"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant := func(claims jwt.Claims) (map[string]any, error) {
+		assertion, err := jwt.Signed(signer).Claims(claims).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{"assertion": {assertion}}
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return s.validateJWTBearerGrant(r)
+	}
+	claims := jwt.Claims{
+		ID:       "1",
+		Issuer:   "https://ci.example.com",
+		Subject:  "repo:entry:ref:main",
+		Audience: jwt.Audience{"http://localhost:8080/oauth/token"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+	data, err := grant(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := s.parseToken(data["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Subject != "deployer" || issued.ClientID != "deployer" {
+		t.Errorf("unexpected claims: %+v", issued)
+	}
+
+	if _, err := grant(claims); err != xerr.ErrInvalidGrant {
+		t.Errorf("replay: err = %v, want %v", err, xerr.ErrInvalidGrant)
+	}
+
+	wrongAud := claims
+	wrongAud.ID = "2"
+	wrongAud.Audience = jwt.Audience{"https://other.example.com"}
+	if _, err := grant(wrongAud); err != xerr.ErrInvalidGrant {
+		t.Errorf("audience: err = %v, want %v", err, xerr.ErrInvalidGrant)
+	}
+	unmapped := claims
+	unmapped.ID = "3"
+	unmapped.Subject = "repo:other:ref:main"
+	if _, err := grant(unmapped); err != xerr.ErrInvalidGrant {
+		t.Errorf("mapping: err = %v, want %v", err, xerr.ErrInvalidGrant)
+	}
+	longLived := claims
+	longLived.ID = "4"
+	longLived.Expiry = jwt.NewNumericDate(time.Now().Add(2 * time.Hour))
+	if _, err := grant(longLived); err != xerr.ErrInvalidGrant {
+		t.Errorf("lifetime: err = %v, want %v", err, xerr.ErrInvalidGrant)
+	}
+	noID := claims
+	noID.ID = ""
+	if _, err := grant(noID); err != xerr.ErrInvalidGrant {
+		t.Errorf("jti: err = %v, want %v", err, xerr.ErrInvalidGrant)
+	}
+}
+
+func TestJWKSFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "jwks.json")
+	write := func(kid string) {
+		data, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: kid}}})
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a")
+	s := New(WithTrustedIssuers(TrustedIssuer{Issuer: "https://ci.example.com", JWKSFile: name, JWKSRefresh: time.Hour})).(*server)
+	ti := s.trustedIssuers["https://ci.example.com"]
+	kid := func() string {
+		jwks, err := ti.keys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jwks.Keys[0].KeyID
+	}
+	if kid() != "a" {
+		t.Fatal("keys not read")
+	}
+	write("b")
+	if kid() != "a" {
+		t.Error("keys read again before the refresh interval")
+	}
+	ti.file.loaded = time.Now().Add(-2 * time.Hour)
+	if kid() != "b" {
+		t.Error("keys not refreshed")
+	}
+	os.Remove(name)
+	ti.file.loaded = time.Time{}
+	if kid() != "b" {
+		t.Error("last keys dropped while the file is missing")
+	}
+}
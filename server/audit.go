@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/entry/xlog"
+)
+
+// audit emits a security audit event for the request to the configured sinks.
+// Sink failures are logged and never fail the request.
+func (s *server) audit(r *http.Request, typ model.AuditEventType, outcome model.AuditOutcome, actor, clientID, detail string) {
+	e := model.NewAuditEvent(typ, outcome)
+	e.Actor = actor
+	e.ClientID = clientID
+	e.Detail = detail
+	e.UserAgent = r.UserAgent()
+	e.IP, _ = s.parseRealIP(r)
+	if err := s.auditSink.Write(r.Context(), e); err != nil {
+		s.logger.Error("failed to write audit event", xlog.Str("event", string(typ)), xlog.Err(err))
+	}
+}
+
+// auditReason returns the OAuth error code of err, so that audit details
+// carry a fixed reason rather than arbitrary error text.
+func auditReason(err error) string {
+	if _, ok := xerr.Descriptions[err]; ok {
+		return err.Error()
+	}
+	return xerr.ErrServerError.Error()
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sutext.github.io/entry/audit"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+)
+
+func TestAuditDetail(t *testing.T) {
+	var events []*model.AuditEvent
+	s := New(WithAuditSink(audit.SinkFunc(func(ctx context.Context, e *model.AuditEvent) error {
+		events = append(events, e)
+		return nil
+	}))).(*server)
+	s.db = &failingStorage{}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/register", strings.NewReader(`{"email":"alice@example.com","password":"secret"}`))
+	r.Header.Set("User-Agent", "test")
+	s.handleRegister(w, r)
+	if len(events) != 1 {
+		t.Fatalf("%d events", len(events))
+	}
+	e := events[0]
+	if e.Type != model.AuditRegister || e.Outcome != model.AuditFailure || e.Detail != "create user failed" || e.UserAgent != "test" {
+		t.Errorf("event = %+v", e)
+	}
+
+	if got := auditReason(xerr.ErrInvalidGrant); got != xerr.ErrInvalidGrant.Error() {
+		t.Errorf("reason of known error = %s", got)
+	}
+	if got := auditReason(errors.New("dial tcp 10.0.0.5:5432: connection refused")); got != xerr.ErrServerError.Error() {
+		t.Errorf("reason of internal error = %s", got)
+	}
+}
@@ -4,13 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"math"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/rar"
+	"sutext.github.io/entry/scope"
 	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid"
 	"sutext.github.io/suid/guid"
 )
 
@@ -20,45 +27,156 @@ type PreviewResponse struct {
 	ClientID   string   `json:"client_id"`
 	ClientName string   `json:"client_name"`
 	ClientLogo string   `json:"client_logo"`
+	// Details are the authorization details the user is asked to consent to.
+	Details rar.Details `json:"authorization_details,omitempty"`
+	// Claims are the claims requested beyond the scopes the user is asked to consent to.
+	Claims []string `json:"claims,omitempty"`
+	// Redirect is set when consent was not required and the code has already been issued.
+	Redirect string `json:"redirect,omitempty"`
 }
 
 func (s *server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("request_uri") != "" {
+		s.handlePushedAuthorize(w, r)
+		return
+	}
 	req, err := s.validateAuthorizeRequest(r)
 	if err != nil {
 		http.Error(w, "failed to validate authorize request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	if req.ResponseType != ResponseTypeCode {
-		http.Error(w, "unsupported response type", http.StatusBadRequest)
+	client, err := s.db.GetClient(r.Context(), req.ClientID)
+	if err != nil {
+		http.Error(w, "unknown client "+req.ClientID, http.StatusBadRequest)
+		return
+	}
+	if err := s.validateClientSettings(client, req, r); err != nil {
+		http.Error(w, "failed to validate client settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkResources(r.Context(), req.Resources, req.Scope); err != nil {
+		s.redirectError(w, r, req, err)
+		return
+	}
+	if req.AuthorizationDetails, err = s.parseAuthorizationDetails(client, r.FormValue("authorization_details")); err != nil {
+		s.redirectError(w, r, req, err)
+		return
+	}
+	s.startAuthorize(w, r, client, req)
+}
+
+// handlePushedAuthorize continues an authorization request the client pushed
+// before, which has been validated already.
+func (s *server) handlePushedAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, err := s.pushedRequest(r)
+	if err != nil {
+		http.Error(w, "invalid request_uri", http.StatusBadRequest)
+		return
+	}
+	client, err := s.db.GetClient(r.Context(), req.ClientID)
+	if err != nil || !client.Active() {
+		http.Error(w, "unknown client "+req.ClientID, http.StatusBadRequest)
+		return
+	}
+	s.startAuthorize(w, r, client, req)
+}
+
+// startAuthorize answers a validated authorization request, either silently or
+// by handing it to the approval page.
+func (s *server) startAuthorize(w http.ResponseWriter, r *http.Request, client *model.Client, req *AuthorizeRequest) {
+	if req.Prompt.Has(PromptNone) {
+		s.authorizeSilently(w, r, client, req)
 		return
 	}
 	s.reqCache.Set(req.ID, req, time.Minute*10)
-	http.Redirect(w, r, "/#/approve?reqid="+req.ID, http.StatusFound)
+	target := "/#/approve?reqid=" + req.ID
+	if req.LoginHint != "" {
+		target += "&login_hint=" + url.QueryEscape(req.LoginHint)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
 }
+
+// authorizeSilently answers a prompt=none request without showing any UI.
+func (s *server) authorizeSilently(w http.ResponseWriter, r *http.Request, client *model.Client, req *AuthorizeRequest) {
+	sess, err := s.currentSession(r)
+	if err != nil {
+		s.redirectError(w, r, req, xerr.ErrLoginRequired)
+		return
+	}
+	if err := s.checkAuthentication(r.Context(), client, req, sess); err != nil {
+		s.redirectError(w, r, req, err)
+		return
+	}
+	req.UserID = sess.UserID
+	req.AuthTime = sess.AuthTime
+	req.Acr = sess.Acr
+	req.Amr = sess.Amr
+	req.SessionID = sess.ID
+	if s.consentRequired(r.Context(), client, req) {
+		s.redirectError(w, r, req, xerr.ErrConsentRequired)
+		return
+	}
+	data, err := s.authorizeResponse(r, client, req)
+	if err != nil {
+		s.redirectError(w, r, req, err)
+		return
+	}
+	s.redirect(w, r, req, data)
+}
+
+// checkAuthentication verifies that the session satisfies the prompt, max_age
+// and id_token_hint parameters of the request and the MFA policy of the client.
+func (s *server) checkAuthentication(ctx context.Context, client *model.Client, req *AuthorizeRequest, sess *authSession) error {
+	// prompt=login and max_age=0 ask for a login after the request was made,
+	// auth_time has whole seconds only
+	fresh := req.Prompt.Has(PromptLogin) || (req.MaxAge != nil && *req.MaxAge == 0)
+	if fresh && sess.AuthTime.Before(req.CreatedAt.Truncate(time.Second)) {
+		return xerr.ErrLoginRequired
+	}
+	if req.MaxAge != nil && *req.MaxAge > 0 && time.Since(sess.AuthTime) > maxAgeDuration(*req.MaxAge) {
+		return xerr.ErrLoginRequired
+	}
+	if req.HintUserID != 0 && req.HintUserID != sess.UserID {
+		return xerr.ErrLoginRequired
+	}
+	// a session too weak for the requested classes has to step up, which a
+	// user without a second factor cannot; signing in again would not help
+	weak := !acrSatisfies(sess.Acr, requestedACR(req)) ||
+		(client.RequireMFA && !acrSatisfies(sess.Acr, []string{ACRMultiFactor}))
+	if weak && !s.mfaEnrolled(ctx, sess.UserID) {
+		return xerr.ErrUnmetAuthenticationRequirements
+	}
+	if weak {
+		return xerr.ErrLoginRequired
+	}
+	return nil
+}
+
+// maxAgeDuration converts max_age seconds to a duration, clamped to the
+// largest duration instead of overflowing.
+func maxAgeDuration(seconds int64) time.Duration {
+	return time.Duration(min(seconds, math.MaxInt64/int64(time.Second))) * time.Second
+}
+
+// handleAuthorizePreview describes a request cached by handleAuthorize to the
+// approval page. Requests only get there through the validation of
+// handleAuthorize, there is no way to preview a request of the query.
 func (s *server) handleAuthorizePreview(w http.ResponseWriter, r *http.Request) {
-	var err error
-	var req *AuthorizeRequest
 	reqid := r.FormValue("reqid")
-	if reqid != "" {
-		req, err = s.reqCache.Get(reqid)
-		if err != nil {
-			http.Error(w, "reqid not found", http.StatusBadRequest)
-			return
-		}
-	} else {
-		req, err = s.validateAuthorizeRequest(r)
-		if err != nil {
-			http.Error(w, "failed to validate authorize request: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		s.reqCache.Set(req.ID, req, time.Minute*10)
+	if reqid == "" {
+		http.Error(w, "reqid is empty", http.StatusBadRequest)
+		return
+	}
+	req, err := s.reqCache.Get(reqid)
+	if err != nil {
+		http.Error(w, "reqid not found", http.StatusBadRequest)
+		return
 	}
-	userID, err := s.ensureLoggedIn(r)
+	sess, err := s.currentSession(r)
 	if err != nil {
 		http.Error(w, "authorize failed: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
-	req.UserID = userID
 	client, err := s.db.GetClient(r.Context(), req.ClientID)
 	if err != nil {
 		http.Error(
@@ -68,6 +186,27 @@ func (s *server) handleAuthorizePreview(w http.ResponseWriter, r *http.Request)
 		)
 		return
 	}
+	if err := s.checkAuthentication(r.Context(), client, req, sess); err == xerr.ErrUnmetAuthenticationRequirements {
+		// the client learns the request cannot be met, instead of the page
+		// sending the user to a login which cannot meet it either
+		s.reqCache.Delete(req.ID)
+		data, _, _ := s.getErrorData(err)
+		redirect, err := s.deferredRedirect(r.Context(), req, data)
+		if err != nil {
+			http.Error(w, "failed to build redirect: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, PreviewResponse{ReqID: req.ID, ClientID: req.ClientID, Redirect: redirect})
+		return
+	} else if err != nil {
+		http.Error(w, "authorize failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	req.UserID = sess.UserID
+	req.AuthTime = sess.AuthTime
+	req.Acr = sess.Acr
+	req.Amr = sess.Amr
+	req.SessionID = sess.ID
 	if err := s.validateClientSettings(client, req, r); err != nil {
 		http.Error(
 			w,
@@ -82,6 +221,20 @@ func (s *server) handleAuthorizePreview(w http.ResponseWriter, r *http.Request)
 		ClientID:   req.ClientID,
 		ClientName: client.Name,
 		ClientLogo: client.LogoURL,
+		Details:    req.AuthorizationDetails,
+		Claims:     extraClaims(req),
+	}
+	if !s.consentRequired(r.Context(), client, req) {
+		data, err := s.authorizeResponse(r, client, req)
+		if err != nil {
+			http.Error(w, "failed to authorize: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Redirect, err = s.deferredRedirect(r.Context(), req, data)
+		if err != nil {
+			http.Error(w, "failed to build redirect: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 	e := json.NewEncoder(w)
 	e.SetIndent("", "  ")
@@ -105,25 +258,196 @@ func (s *server) handleAuthorizeApprove(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "preview is required", http.StatusBadRequest)
 		return
 	}
-	s.reqCache.Delete(reqid)
-	code := guid.New().String()
-	s.codeCache.Set(code, req, time.Minute*10)
-	u, err := url.Parse(req.RedirectURI)
+	if r.FormValue("grant") == "false" {
+		s.reqCache.Delete(reqid)
+		s.audit(r, model.AuditConsentApproved, model.AuditDenied, req.UserID.String(), req.ClientID, req.Scope)
+		s.redirectError(w, r, req, xerr.ErrAccessDenied)
+		return
+	}
+	client, err := s.db.GetClient(r.Context(), req.ClientID)
 	if err != nil {
-		s.redirectError(w, req, err)
+		http.Error(w, "unknown client "+req.ClientID, http.StatusBadRequest)
 		return
 	}
-	q := u.Query()
-	if req.State != "" {
-		q.Set("state", req.State)
+	s.audit(r, model.AuditConsentApproved, model.AuditSuccess, req.UserID.String(), req.ClientID, req.Scope)
+	if err := s.saveGrant(r.Context(), req); err != nil {
+		http.Error(w, "failed to save grant: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := s.authorizeResponse(r, client, req)
+	if err != nil {
+		s.redirectError(w, r, req, err)
+		return
 	}
-	q.Set("code", code)
-	u.RawQuery = q.Encode()
-	http.Redirect(w, r, u.String(), http.StatusFound)
+	s.redirect(w, r, req, data)
+}
+
+// handleAuthorizeCallback delivers a response prepared by the preview endpoint
+// which has to be sent with the form_post response mode.
+func (s *server) handleAuthorizeCallback(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	pending, err := s.respCache.Get(id)
+	if err != nil {
+		http.Error(w, "authorization response not found", http.StatusBadRequest)
+		return
+	}
+	s.respCache.Delete(id)
+	s.redirect(w, r, pending.Request, pending.Data)
+}
+
+// consentRequired reports whether the user has to see the consent screen.
+// Official clients never ask for consent, other clients only when prompt=consent
+// is requested or the scopes, authorization details or claims beyond the scopes
+// are not covered by a previous grant.
+func (s *server) consentRequired(ctx context.Context, client *model.Client, req *AuthorizeRequest) bool {
+	if req.Prompt.Has(PromptConsent) {
+		return true
+	}
+	if client.Type == model.ClientTypeOfficial {
+		return false
+	}
+	grant, err := s.db.GetGrant(ctx, req.UserID, req.ClientID)
+	if err != nil {
+		return true
+	}
+	return !grant.Scopes.ContainsAll(scope.Parse(req.Scope)) ||
+		!grant.AuthorizationDetails.Contains(req.AuthorizationDetails) ||
+		slices.ContainsFunc(extraClaims(req), func(name string) bool {
+			return !slices.Contains(grant.Claims, name)
+		})
+}
+
+// saveGrant merges the scopes, authorization details and claims of the approved request into the stored grant.
+func (s *server) saveGrant(ctx context.Context, req *AuthorizeRequest) error {
+	grant, err := s.db.GetGrant(ctx, req.UserID, req.ClientID)
+	if err != nil {
+		grant = &model.Grant{
+			UserID:    req.UserID,
+			ClientID:  req.ClientID,
+			CreatedAt: time.Now(),
+		}
+	}
+	grant.Scopes = grant.Scopes.Merge(scope.Parse(req.Scope))
+	grant.AuthorizationDetails = grant.AuthorizationDetails.Merge(req.AuthorizationDetails)
+	for _, name := range extraClaims(req) {
+		if !slices.Contains(grant.Claims, name) {
+			grant.Claims = append(grant.Claims, name)
+		}
+	}
+	grant.UpdatedAt = time.Now()
+	return s.db.SaveGrant(ctx, grant)
+}
+
+// pendingResponse is an authorization response waiting to be picked up by the browser.
+type pendingResponse struct {
+	Request *AuthorizeRequest
+	Data    map[string]any
+}
+
+// authorizeResponse finishes the approved request and returns the parameters
+// of the authorization response. Depending on the response type it contains an
+// authorization code, an access token, an ID token or a combination of them.
+func (s *server) authorizeResponse(r *http.Request, client *model.Client, req *AuthorizeRequest) (map[string]any, error) {
+	s.reqCache.Delete(req.ID)
+	if err := s.joinSession(r.Context(), req.SessionID, client.ID); err != nil {
+		return nil, err
+	}
+	data := make(map[string]any)
+	var code, accessToken string
+	if req.ResponseType.Has("code") {
+		code = guid.New().String()
+		s.codeCache.Set(code, req, time.Minute*10)
+		data["code"] = code
+		s.audit(r, model.AuditCodeIssued, model.AuditSuccess, req.UserID.String(), req.ClientID, "")
+	}
+	if req.ResponseType.Has("token") {
+		tgr := &TokenGenerateRequest{
+			GrantType:            Implicit,
+			ClientID:             client.ID,
+			Client:               client,
+			UserID:               req.UserID.String(),
+			Scope:                req.Scope,
+			Audience:             tokenAudience(req.Resources, client.ID),
+			AuthTime:             req.AuthTime,
+			Acr:                  req.Acr,
+			Amr:                  req.Amr,
+			AuthorizationDetails: req.AuthorizationDetails,
+			Claims:               req.Claims,
+			AccessTokenExp:       s.accessTokenTTL(client),
+			Request:              r,
+		}
+		token, err := s.generateAccessToken(tgr)
+		if err != nil {
+			return nil, err
+		}
+		accessToken = token
+		maps.Copy(data, s.getTokenData(tgr, token))
+		if len(req.AuthorizationDetails) > 0 {
+			// front channel parameters are strings
+			data["authorization_details"] = req.AuthorizationDetails.String()
+		}
+		s.audit(r, model.AuditTokenIssued, model.AuditSuccess, req.UserID.String(), req.ClientID, req.ResponseType.String())
+	}
+	if req.ResponseType.Has("id_token") {
+		// without any access token the claims of the scopes go into the ID token
+		var scopes string
+		if req.ResponseType == ResponseTypeIDToken {
+			scopes = req.Scope
+		}
+		idToken, err := s.createIDToken(r.Context(), idTokenRequest{
+			UserID:      req.UserID,
+			Client:      client,
+			Scope:       scopes,
+			Claims:      req.Claims.IDToken,
+			Nonce:       req.Nonce,
+			AuthTime:    req.AuthTime,
+			Acr:         req.Acr,
+			Amr:         req.Amr,
+			SessionID:   req.SessionID,
+			Code:        code,
+			AccessToken: accessToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		data["id_token"] = idToken
+	}
+	return data, nil
+}
+
+// deferredRedirect returns the URI the browser has to visit to receive the
+// authorization response. Responses using form_post are held back and served
+// by the callback endpoint, because they cannot be encoded in a URI.
+func (s *server) deferredRedirect(ctx context.Context, req *AuthorizeRequest, data map[string]any) (string, error) {
+	if req.ResponseMode.Base() != ResponseModeFormPost {
+		req, data, err := s.responseParams(ctx, req, data)
+		if err != nil {
+			return "", err
+		}
+		return s.getRedirectURI(req, data)
+	}
+	id := guid.New().String()
+	s.respCache.Set(id, &pendingResponse{Request: req, Data: data}, time.Minute)
+	return s.endpoints.Callback + "?id=" + id, nil
 }
 
 func (s *server) validateClientSettings(client *model.Client, req *AuthorizeRequest, r *http.Request) error {
-	if !client.Public {
+	if !client.Active() {
+		return xerr.ErrInvalidClient
+	}
+	if !allowsResponseType(client, req.ResponseType) {
+		return xerr.ErrUnauthorizedClient
+	}
+	if !req.ResponseType.Has("code") {
+		// PKCE protects the code exchange only
+	} else if req.CodeChallenge == "" {
+		if client.PKCEPolicy != model.PKCEOptional || client.IsPublic() {
+			return xerr.ErrCodeChallengeRquired
+		}
+	} else if client.PKCEPolicy == model.PKCERequiredS256 && req.CodeChallengeMethod != CodeChallengeS256 {
+		return xerr.ErrUnsupportedCodeChallengeMethod
+	}
+	if !client.IsPublic() {
 		if !s.checkTrustedPeer(client.TrustedPeers, r.RemoteAddr) {
 			return xerr.ErrUnauthorizedClient
 		}
@@ -136,6 +460,19 @@ func (s *server) validateClientSettings(client *model.Client, req *AuthorizeRequ
 	}
 	return nil
 }
+
+// allowsResponseType reports whether the client may use the normalized response type.
+// Every client may use "code", implicit and hybrid response types must be listed explicitly,
+// in any order of their values.
+func allowsResponseType(client *model.Client, rt ResponseType) bool {
+	if len(client.ResponseTypes) == 0 {
+		return rt == ResponseTypeCode
+	}
+	return slices.ContainsFunc(client.ResponseTypes, func(v string) bool {
+		return ParseResponseType(v) == rt
+	})
+}
+
 func (s *server) checkTrustedPeer(trustedPeers []string, remoteAddr string) bool {
 	for _, tp := range trustedPeers {
 		if tp == remoteAddr {
@@ -152,17 +489,33 @@ func (s *server) validateAuthorizeRequest(r *http.Request) (*AuthorizeRequest, e
 		return nil, xerr.ErrInvalidRequest
 	}
 
-	resType := ResponseType(r.FormValue("response_type"))
+	resType := ParseResponseType(r.FormValue("response_type"))
 	if resType.String() == "" {
 		return nil, xerr.ErrUnsupportedResponseType
 	} else if allowed := s.checkResponseType(resType); !allowed {
 		return nil, xerr.ErrUnauthorizedClient
 	}
+	resMode := ResponseMode(r.FormValue("response_mode"))
+	switch resMode {
+	case "":
+		resMode = resType.DefaultResponseMode()
+	case ResponseModeJWT:
+		resMode = ResponseModeQueryJWT
+		if resType.Implicit() {
+			resMode = ResponseModeFragmentJWT
+		}
+	case ResponseModeFragment, ResponseModeFormPost, ResponseModeFragmentJWT, ResponseModeFormPostJWT:
+	case ResponseModeQuery, ResponseModeQueryJWT:
+		// tokens must never be sent in the query
+		if resType.Implicit() {
+			return nil, xerr.ErrInvalidRequest
+		}
+	default:
+		return nil, xerr.ErrInvalidRequest
+	}
 
+	// whether the code challenge is required depends on the client, see validateClientSettings
 	cc := r.FormValue("code_challenge")
-	if cc == "" {
-		return nil, xerr.ErrCodeChallengeRquired
-	}
 	if cc != "" && (len(cc) < 43 || len(cc) > 128) {
 		return nil, xerr.ErrInvalidCodeChallengeLen
 	}
@@ -176,211 +529,151 @@ func (s *server) validateAuthorizeRequest(r *http.Request) (*AuthorizeRequest, e
 		return nil, xerr.ErrUnsupportedCodeChallengeMethod
 	}
 
+	prompt := ParsePrompt(r.FormValue("prompt"))
+	if prompt.Has(PromptNone) && len(prompt) > 1 {
+		return nil, xerr.ErrInvalidRequest
+	}
+	var maxAge *int64
+	if v := r.FormValue("max_age"); v != "" {
+		age, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || age < 0 {
+			return nil, xerr.ErrInvalidRequest
+		}
+		maxAge = &age
+	}
+	var hintUserID suid.SUID
+	if hint := r.FormValue("id_token_hint"); hint != "" {
+		uid, _, err := s.parseIDTokenHint(r.Context(), hint)
+		if err != nil {
+			return nil, xerr.ErrInvalidRequest
+		}
+		hintUserID = uid
+	}
+
+	// https://openid.net/specs/openid-connect-core-1_0.html#ImplicitAuthRequest
+	nonce := r.FormValue("nonce")
+	if nonce == "" && resType.Implicit() && wantsIDToken(r.FormValue("scope")) {
+		return nil, xerr.ErrInvalidRequest
+	}
+
+	claims, err := parseClaimsRequest(r.FormValue("claims"))
+	if err != nil {
+		return nil, err
+	}
+
 	req := &AuthorizeRequest{
 		ID:                  guid.New().String(),
 		RedirectURI:         redirectURI,
 		ResponseType:        resType,
+		ResponseMode:        resMode,
 		ClientID:            clientID,
 		State:               r.FormValue("state"),
+		Nonce:               nonce,
 		Scope:               r.FormValue("scope"),
+		Resources:           r.Form["resource"],
+		Claims:              claims,
+		ACRValues:           strings.Fields(r.FormValue("acr_values")),
+		Prompt:              prompt,
+		MaxAge:              maxAge,
+		LoginHint:           r.FormValue("login_hint"),
+		HintUserID:          hintUserID,
+		CreatedAt:           time.Now(),
 		CodeChallenge:       cc,
 		CodeChallengeMethod: ccm,
 	}
 	return req, nil
 }
+
+// parseIDTokenHint returns the user and the client of an ID token issued by the
+// server. Expired tokens are accepted, the hint only identifies the user.
+func (s *server) parseIDTokenHint(ctx context.Context, hint string) (uid suid.SUID, clientID string, err error) {
+	claims, err := s.parseIDToken(hint)
+	if err != nil {
+		return uid, "", err
+	}
+	uid, err = s.resolveSubject(ctx, claims.Subject)
+	return uid, claims.Audience[0], err
+}
 func (s *server) getRedirectURI(req *AuthorizeRequest, data map[string]any) (string, error) {
 	u, err := url.Parse(req.RedirectURI)
 	if err != nil {
 		return "", err
 	}
-	q := u.Query()
+	params := make(url.Values)
 	if req.State != "" {
-		q.Set("state", req.State)
+		params.Set("state", req.State)
 	}
 	for k, v := range data {
-		q.Set(k, fmt.Sprint(v))
-	}
-	switch req.ResponseType {
-	case ResponseTypeCode:
-		u.RawQuery = q.Encode()
-	case ResponseTypeToken:
-		u.RawQuery = ""
-		fragment, err := url.QueryUnescape(q.Encode())
-		if err != nil {
-			return "", err
+		params.Set(k, fmt.Sprint(v))
+	}
+	switch req.ResponseMode {
+	case ResponseModeFragment:
+		u.Fragment = ""
+		return u.String() + "#" + params.Encode(), nil
+	default:
+		q := u.Query()
+		for k := range params {
+			q.Set(k, params.Get(k))
 		}
-		u.Fragment = fragment
+		u.RawQuery = q.Encode()
+		return u.String(), nil
 	}
-
-	return u.String(), nil
 }
-func (s *server) getAuthorizeToken(ctx context.Context, req *AuthorizeRequest) (*model.TokenInfo, error) {
-	// check the client allows the grant type
-	// if fn := s.ClientAuthorizedHandler; fn != nil {
-	// 	gt := ResponseTypeCode
-	// 	if req.ResponseType == ResponseTypeToken {
-	// 		gt = ResponseTypeToken
-	// 	}
-
-	// 	allowed, err := fn(req.ClientID, gt)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	} else if !allowed {
-	// 		return nil, err.ErrUnauthorizedClient
-	// 	}
-	// }
-
-	tgr := &TokenGenerateRequest{
-		// ClientID:       req.ClientID,
-		// UserID:         req.UserID,
-		// RedirectURI:    req.RedirectURI,
-		// Scope:          req.Scope,
-		// AccessTokenExp: req.AccessTokenExp,
-		// Request:        req.Request,
-	}
-
-	// check the client allows the authorized scope
-	// if fn := s.ClientScopeHandler; fn != nil {
-	// 	allowed, err := fn(tgr)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	} else if !allowed {
-	// 		return nil, err.ErrInvalidScope
-	// 	}
-	// }
-
-	tgr.CodeChallenge = req.CodeChallenge
-	tgr.CodeChallengeMethod = req.CodeChallengeMethod
-
-	return s.generateAuthToken(ctx, req.ResponseType, tgr)
+func (s *server) redirectError(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, err error) {
+	data, _, _ := s.getErrorData(err)
+	s.redirect(w, r, req, data)
 }
-func (s *server) generateAuthToken(ctx context.Context, rt ResponseType, tgr *TokenGenerateRequest) (ti *model.TokenInfo, err error) {
-	cli, err := s.db.GetClient(ctx, tgr.ClientID)
+
+// responseParams returns the request and parameters actually delivered to the client.
+// Every response identifies the issuer to defend clients against mix-up attacks. For the JWT response modes the parameters, including the state, are wrapped into
+// a single response JWT which is delivered using the base mode of the request.
+func (s *server) responseParams(ctx context.Context, req *AuthorizeRequest, data map[string]any) (*AuthorizeRequest, map[string]any, error) {
+	if !req.ResponseMode.JWT() {
+		// https://www.rfc-editor.org/rfc/rfc9207
+		data = maps.Clone(data)
+		data["iss"] = s.issuer
+		return req, data, nil
+	}
+	// the client decides whether the response is encrypted, without it the
+	// response must not go out at all
+	client, err := s.db.GetClient(ctx, req.ClientID)
 	if err != nil {
-		return ti, err
-	} else if tgr.RedirectURI != "" {
-		if !cli.RedirectURIs.Contains(tgr.RedirectURI) {
-			return ti, xerr.ErrInvalidRedirectURI
-		}
+		return nil, nil, err
 	}
-	return s.db.CreateTokenInfo(ctx)
-	// ti := s.db.CreateTokenInfo(ctx)
-	// if m.extractExtension != nil {
-	// 	m.extractExtension(tgr, ti)
-	// }
-	// ti.SetClientID(tgr.ClientID)
-	// ti.SetUserID(tgr.UserID)
-	// ti.SetRedirectURI(tgr.RedirectURI)
-	// ti.SetScope(tgr.Scope)
-
-	// createAt := time.Now()
-	// td := &oauth2.GenerateBasic{
-	// 	Client:    cli,
-	// 	UserID:    tgr.UserID,
-	// 	CreateAt:  createAt,
-	// 	TokenInfo: ti,
-	// 	Request:   tgr.Request,
-	// }
-	// switch rt {
-	// case oauth2.Code:
-	// 	codeExp := m.codeExp
-	// 	if codeExp == 0 {
-	// 		codeExp = DefaultCodeExp
-	// 	}
-	// 	ti.SetCodeCreateAt(createAt)
-	// 	ti.SetCodeExpiresIn(codeExp)
-	// 	if exp := tgr.AccessTokenExp; exp > 0 {
-	// 		ti.SetAccessExpiresIn(exp)
-	// 	}
-	// 	if tgr.CodeChallenge != "" {
-	// 		ti.SetCodeChallenge(tgr.CodeChallenge)
-	// 		ti.SetCodeChallengeMethod(tgr.CodeChallengeMethod)
-	// 	}
-
-	// 	tv, err := m.authorizeGenerate.Token(ctx, td)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	ti.SetCode(tv)
-	// case oauth2.Token:
-	// 	// set access token expires
-	// 	icfg := m.grantConfig(oauth2.Implicit)
-	// 	aexp := icfg.AccessTokenExp
-	// 	if exp := tgr.AccessTokenExp; exp > 0 {
-	// 		aexp = exp
-	// 	}
-	// 	ti.SetAccessCreateAt(createAt)
-	// 	ti.SetAccessExpiresIn(aexp)
-
-	// 	if icfg.IsGenerateRefresh {
-	// 		ti.SetRefreshCreateAt(createAt)
-	// 		ti.SetRefreshExpiresIn(icfg.RefreshTokenExp)
-	// 	}
-
-	// 	tv, rv, err := m.accessGenerate.Token(ctx, td, icfg.IsGenerateRefresh)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	ti.SetAccess(tv)
-
-	// 	if rv != "" {
-	// 		ti.SetRefresh(rv)
-	// 	}
-	// }
-
-	// err = m.tokenStore.Create(ctx, ti)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// return ti, nil
-}
-
-// GetAuthorizeData get authorization response data
-func (s *server) getAuthorizeData(rt ResponseType, ti *model.TokenInfo) map[string]interface{} {
-	if rt == ResponseTypeCode {
-		return map[string]interface{}{
-			"code": ti.Code,
-		}
+	token, err := s.createResponseJWT(client, req, data)
+	if err != nil {
+		return nil, nil, err
 	}
-	return s.getTokenData(ti)
+	wrapped := *req
+	wrapped.ResponseMode = req.ResponseMode.Base()
+	wrapped.State = ""
+	return &wrapped, map[string]any{"response": token}, nil
 }
-func (s *server) getTokenData(ti *model.TokenInfo) map[string]interface{} {
-	data := map[string]interface{}{
-		"access_token": ti.Access,
-		"token_type":   "access_token", //s.Config.TokenType,
-		"expires_in":   int64(ti.AccessExpiresIn / time.Second),
-	}
 
-	if scope := ti.Scope; scope != "" {
-		data["scope"] = scope
+// redirect delivers the authorization response to the client using the response mode of the request.
+func (s *server) redirect(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, data map[string]any) {
+	req, data, err := s.responseParams(r.Context(), req, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-
-	if refresh := ti.Refresh; refresh != "" {
-		data["refresh_token"] = refresh
+	if req.ResponseMode == ResponseModeFormPost {
+		params := make(map[string]string, len(data)+1)
+		if req.State != "" {
+			params["state"] = req.State
+		}
+		for k, v := range data {
+			params[k] = fmt.Sprint(v)
+		}
+		if err := s.web.RenderFormPost(w, req.RedirectURI, params); err != nil {
+			s.logger.Error("failed to render form post: " + err.Error())
+		}
+		return
 	}
-
-	// if fn := s.ExtensionFieldsHandler; fn != nil {
-	// 	ext := fn(ti)
-	// 	for k, v := range ext {
-	// 		if _, ok := data[k]; ok {
-	// 			continue
-	// 		}
-	// 		data[k] = v
-	// 	}
-	// }
-	return data
-}
-
-func (s *server) redirectError(w http.ResponseWriter, req *AuthorizeRequest, err error) {
-	data, _, _ := s.getErrorData(err)
-	s.redirect(w, req, data)
-}
-
-func (s *server) redirect(w http.ResponseWriter, req *AuthorizeRequest, data map[string]any) {
 	uri, err := s.getRedirectURI(req, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	w.Header().Set("Location", uri)
-	w.WriteHeader(302)
+	http.Redirect(w, r, uri, http.StatusFound)
 }
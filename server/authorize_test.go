@@ -0,0 +1,341 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/scope"
+	"sutext.github.io/suid"
+	"sutext.github.io/suid/guid"
+)
+
+func TestParseResponseType(t *testing.T) {
+	for in, want := range map[string]ResponseType{
+		"code":                ResponseTypeCode,
+		"token id_token":      ResponseTypeIDTokenToken,
+		"id_token code":       ResponseTypeCodeIDToken,
+		"token code id_token": ResponseTypeCodeIDTokenToken,
+		"code code":           "",
+		"device_code":         "",
+	} {
+		if got := ParseResponseType(in); got != want {
+			t.Errorf("ParseResponseType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestAllowsResponseType(t *testing.T) {
+	client := &model.Client{ResponseTypes: model.Strings{"id_token token", "code id_token"}}
+	for rt, want := range map[string]bool{
+		"token id_token": true,
+		"id_token code":  true,
+		"code":           false,
+		"id_token":       false,
+	} {
+		if got := allowsResponseType(client, ParseResponseType(rt)); got != want {
+			t.Errorf("allowsResponseType(%q) = %v, want %v", rt, got, want)
+		}
+	}
+	if !allowsResponseType(&model.Client{}, ResponseTypeCode) {
+		t.Error("code is not allowed by default")
+	}
+}
+
+func TestGetRedirectURI(t *testing.T) {
+	s := &server{}
+	req := &AuthorizeRequest{
+		RedirectURI:  "https://client.example.org/cb?app=1",
+		State:        "af0ifjsldkj",
+		ResponseMode: ResponseModeFragment,
+	}
+	uri, err := s.getRedirectURI(req, map[string]any{"access_token": "a&b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(uri)
+	if u.Query().Get("app") != "1" || u.Query().Get("access_token") != "" {
+		t.Errorf("query must be kept and carry no token: %s", uri)
+	}
+	frag, _ := url.ParseQuery(u.EscapedFragment())
+	if frag.Get("access_token") != "a&b" || frag.Get("state") != "af0ifjsldkj" {
+		t.Errorf("unexpected fragment: %s", uri)
+	}
+	req.ResponseMode = ResponseModeQuery
+	uri, _ = s.getRedirectURI(req, map[string]any{"code": "xyz"})
+	u, _ = url.Parse(uri)
+	if u.Query().Get("code") != "xyz" || u.Query().Get("app") != "1" {
+		t.Errorf("unexpected query: %s", uri)
+	}
+}
+
+func TestNormalizeIssuer(t *testing.T) {
+	for in, want := range map[string]string{
+		"HTTPS://Auth.Example.com/":        "https://auth.example.com",
+		"https://auth.example.com/tenant/": "https://auth.example.com/tenant",
+		"https://auth.example.com?x=1#f":   "https://auth.example.com",
+	} {
+		u, _ := url.Parse(in)
+		if got := normalizeIssuer(u); got != want {
+			t.Errorf("normalizeIssuer(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// authorizeFlow serves a public client and a user with a browser session.
+func authorizeFlow(t *testing.T) (*server, *grantStorage, *model.Client, suid.SUID, *http.Cookie) {
+	t.Helper()
+	s := newTestServer()
+	client := &model.Client{
+		ID:           "spa",
+		Type:         model.ClientTypePublic,
+		Scopes:       model.Strings{"openid", "profile"},
+		RedirectURIs: model.Strings{"https://spa.example.com/cb"},
+	}
+	db := &grantStorage{
+		sessionStorage: sessionStorage{
+			clientStorage: clientStorage{clients: map[string]*model.Client{"spa": client}},
+			sessions:      map[string]*model.Session{},
+		},
+		refreshes: map[guid.GUID]model.RefreshToken{},
+	}
+	s.db = db
+	userID := suid.New()
+	w := httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), userID, []string{AMRPassword}); err != nil {
+		t.Fatal(err)
+	}
+	return s, db, client, userID, w.Result().Cookies()[0]
+}
+
+// authorize sends an authorization request of the spa client and returns the
+// parameters of the redirect to the client.
+func authorize(t *testing.T, s *server, cookie *http.Cookie, params url.Values) url.Values {
+	t.Helper()
+	query := url.Values{
+		"client_id":             {"spa"},
+		"response_type":         {"code"},
+		"redirect_uri":          {"https://spa.example.com/cb"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {strings.Repeat("a", 43)},
+		"code_challenge_method": {"S256"},
+	}
+	for k, v := range params {
+		query[k] = v
+	}
+	r := httptest.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.handleAuthorize(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("authorize status = %d: %s", w.Code, w.Body)
+	}
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u.String(), "https://spa.example.com/cb?") {
+		t.Fatalf("redirected to %s", u)
+	}
+	if q := u.Query(); q.Get("state") != "xyz" || q.Get("iss") != "http://localhost:8080" {
+		t.Errorf("redirect lacks state or iss: %s", u)
+	}
+	return u.Query()
+}
+
+func TestAuthorizePromptNone(t *testing.T) {
+	s, db, client, userID, cookie := authorizeFlow(t)
+	none := url.Values{"prompt": {"none"}}
+	if q := authorize(t, s, nil, none); q.Get("error") != "login_required" {
+		t.Errorf("without session: %v", q)
+	}
+	if q := authorize(t, s, cookie, none); q.Get("error") != "consent_required" {
+		t.Errorf("without grant: %v", q)
+	}
+	db.SaveGrant(t.Context(), &model.Grant{UserID: userID, ClientID: "spa", Scopes: scope.Scopes{"openid"}})
+	if q := authorize(t, s, cookie, none); q.Get("code") == "" || q.Get("error") != "" {
+		t.Errorf("with grant: %v", q)
+	}
+	if q := authorize(t, s, cookie, url.Values{"prompt": {"none"}, "scope": {"openid profile"}}); q.Get("error") != "consent_required" {
+		t.Errorf("with scope beyond the grant: %v", q)
+	}
+	client.Type, client.TrustedPeers = model.ClientTypeOfficial, model.Strings{"192.0.2.1:1234"}
+	if q := authorize(t, s, cookie, url.Values{"prompt": {"none"}, "scope": {"openid profile"}}); q.Get("code") == "" {
+		t.Errorf("official client asked for consent: %v", q)
+	}
+}
+
+func TestAuthorizeMaxAge(t *testing.T) {
+	s, db, _, userID, cookie := authorizeFlow(t)
+	db.SaveGrant(t.Context(), &model.Grant{UserID: userID, ClientID: "spa", Scopes: scope.Scopes{"openid"}})
+	for _, sess := range db.sessions {
+		sess.AuthTime = time.Now().Add(-10 * time.Minute)
+	}
+	if q := authorize(t, s, cookie, url.Values{"prompt": {"none"}, "max_age": {"3600"}}); q.Get("code") == "" {
+		t.Errorf("recent authentication: %v", q)
+	}
+	if q := authorize(t, s, cookie, url.Values{"prompt": {"none"}, "max_age": {"60"}}); q.Get("error") != "login_required" {
+		t.Errorf("authentication older than max_age: %v", q)
+	}
+	if q := authorize(t, s, cookie, url.Values{"prompt": {"none"}, "max_age": {"0"}}); q.Get("error") != "login_required" {
+		t.Errorf("max_age=0: %v", q)
+	}
+	if q := authorize(t, s, cookie, url.Values{"prompt": {"none"}, "max_age": {"9223372036854775807"}}); q.Get("code") == "" {
+		t.Errorf("huge max_age: %v", q)
+	}
+
+	// max_age=0 is met by signing in again on the approval page
+	query := url.Values{"client_id": {"spa"}, "response_type": {"code"}, "redirect_uri": {"https://spa.example.com/cb"},
+		"scope": {"openid"}, "max_age": {"0"}, "code_challenge": {strings.Repeat("a", 43)}, "code_challenge_method": {"S256"}}
+	w := httptest.NewRecorder()
+	s.handleAuthorize(w, httptest.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil))
+	reqid := strings.TrimPrefix(w.Header().Get("Location"), "/#/approve?reqid=")
+	preview := func(cookie *http.Cookie) int {
+		r := httptest.NewRequest("GET", "/authorize/preview?reqid="+reqid, nil)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		s.handleAuthorizePreview(w, r)
+		return w.Code
+	}
+	if code := preview(cookie); code != http.StatusUnauthorized {
+		t.Errorf("preview before login status = %d", code)
+	}
+	w = httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), userID, []string{AMRPassword}); err != nil {
+		t.Fatal(err)
+	}
+	if code := preview(w.Result().Cookies()[0]); code != http.StatusOK {
+		t.Errorf("preview after login status = %d", code)
+	}
+}
+
+func TestAuthorizePreviewQuery(t *testing.T) {
+	s, db, _, userID, cookie := authorizeFlow(t)
+	db.SaveGrant(t.Context(), &model.Grant{UserID: userID, ClientID: "spa", Scopes: scope.Scopes{"openid"}})
+	// a request of the query would skip the validation of handleAuthorize
+	query := url.Values{"client_id": {"spa"}, "response_type": {"code"}, "redirect_uri": {"https://spa.example.com/cb"},
+		"scope": {"openid"}, "resource": {"https://unknown.example.com"}, "code_challenge": {strings.Repeat("a", 43)}, "code_challenge_method": {"S256"}}
+	r := httptest.NewRequest("GET", "/oauth/authorize/preview?"+query.Encode(), nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	if s.handleAuthorizePreview(w, r); w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "redirect") {
+		t.Errorf("preview of a query status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAuthorizeIDTokenHint(t *testing.T) {
+	s, db, client, userID, cookie := authorizeFlow(t)
+	db.SaveGrant(t.Context(), &model.Grant{UserID: userID, ClientID: "spa", Scopes: scope.Scopes{"openid"}})
+	hint := func(userID suid.SUID) url.Values {
+		idToken, err := s.createIDToken(t.Context(), idTokenRequest{UserID: userID, Client: client})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return url.Values{"prompt": {"none"}, "id_token_hint": {idToken}}
+	}
+	if q := authorize(t, s, cookie, hint(userID)); q.Get("code") == "" {
+		t.Errorf("hint of the session user: %v", q)
+	}
+	if q := authorize(t, s, cookie, hint(suid.New())); q.Get("error") != "login_required" {
+		t.Errorf("hint of another user: %v", q)
+	}
+
+	query := url.Values{"client_id": {"spa"}, "response_type": {"code"}, "redirect_uri": {"https://spa.example.com/cb"}, "id_token_hint": {"forged"}}
+	w := httptest.NewRecorder()
+	s.handleAuthorize(w, httptest.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid hint status = %d", w.Code)
+	}
+}
+
+func TestPushedAuthorize(t *testing.T) {
+	s, db, _, userID, cookie := authorizeFlow(t)
+	db.SaveGrant(t.Context(), &model.Grant{UserID: userID, ClientID: "spa", Scopes: scope.Scopes{"openid"}})
+	push := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/oauth/par", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handlePAR(w, r)
+		return w
+	}
+	form := url.Values{
+		"client_id":             {"spa"},
+		"response_type":         {"code"},
+		"redirect_uri":          {"https://spa.example.com/cb"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"prompt":                {"none"},
+		"code_challenge":        {strings.Repeat("a", 43)},
+		"code_challenge_method": {"S256"},
+	}
+	w := push(form)
+	var resp struct {
+		RequestURI string `json:"request_uri"`
+		ExpiresIn  int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("push status = %d: %v", w.Code, err)
+	}
+	if !strings.HasPrefix(resp.RequestURI, requestURIPrefix) || resp.ExpiresIn != 60 {
+		t.Errorf("push response = %+v", resp)
+	}
+	pushed := func(clientID string) *httptest.ResponseRecorder {
+		query := url.Values{"client_id": {clientID}, "request_uri": {resp.RequestURI}}
+		r := httptest.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		s.handleAuthorize(w, r)
+		return w
+	}
+	w = pushed("spa")
+	u, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || u.Query().Get("code") == "" || u.Query().Get("state") != "xyz" {
+		t.Errorf("pushed request status = %d, location = %s", w.Code, u)
+	}
+	if w := pushed("spa"); w.Code != http.StatusBadRequest {
+		t.Errorf("reused request_uri status = %d", w.Code)
+	}
+
+	w = push(form)
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w := pushed("other"); w.Code != http.StatusBadRequest {
+		t.Errorf("request_uri of another client status = %d", w.Code)
+	}
+	form.Set("redirect_uri", "https://evil.example.com/cb")
+	if w := push(form); w.Code != http.StatusBadRequest {
+		t.Errorf("unregistered redirect uri status = %d", w.Code)
+	}
+}
+
+func TestAuthorizeClaimsConsent(t *testing.T) {
+	s, db, _, userID, cookie := authorizeFlow(t)
+	db.SaveGrant(t.Context(), &model.Grant{UserID: userID, ClientID: "spa", Scopes: scope.Scopes{"openid", "profile"}})
+	claims := func(param string) url.Values {
+		return url.Values{"prompt": {"none"}, "scope": {"openid profile"}, "claims": {param}}
+	}
+	if q := authorize(t, s, cookie, claims(`{"userinfo":{"nickname":null},"id_token":{"acr":{"essential":true}}}`)); q.Get("code") == "" {
+		t.Errorf("claims of the scopes: %v", q)
+	}
+	extra := claims(`{"userinfo":{"email":null,"phone_number":null}}`)
+	if q := authorize(t, s, cookie, extra); q.Get("error") != "consent_required" {
+		t.Errorf("claims beyond the scopes: %v", q)
+	}
+	req := &AuthorizeRequest{UserID: userID, ClientID: "spa", Scope: "openid", Claims: model.ClaimsRequest{UserInfo: map[string]*model.ClaimRequest{"phone_number": nil, "email": nil}}}
+	if err := s.saveGrant(t.Context(), req); err != nil {
+		t.Fatal(err)
+	}
+	if g := db.grants[userID.String()+"spa"]; g == nil || len(g.Claims) != 2 {
+		t.Fatalf("grant = %+v", g)
+	}
+	if q := authorize(t, s, cookie, extra); q.Get("code") == "" {
+		t.Errorf("consented claims: %v", q)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/webhook"
+	"sutext.github.io/entry/xlog"
+	"sutext.github.io/suid/guid"
+)
+
+// backchannelLogoutEvent is the event of logout tokens.
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenTTL bounds the lifetime of logout tokens.
+const logoutTokenTTL = 2 * time.Minute
+
+// logoutTokenClaims are the claims of a back-channel logout token.
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken
+type logoutTokenClaims struct {
+	jwt.Claims
+	Sid    string                    `json:"sid,omitempty"`
+	Events map[string]map[string]any `json:"events"`
+}
+
+// BackchannelOptions tune the delivery of back-channel logout tokens.
+type BackchannelOptions struct {
+	// MaxAttempts is the number of deliveries tried before giving up.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles on every attempt.
+	Backoff time.Duration
+	// Workers is the number of concurrent deliveries.
+	Workers int
+	// QueueSize bounds the number of pending deliveries.
+	QueueSize int
+	Client    *http.Client
+}
+
+// newLogoutQueue returns the queue posting logout tokens to the back-channel
+// logout URIs of clients.
+func newLogoutQueue(opts BackchannelOptions, logger *xlog.Logger) *webhook.Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.Client == nil {
+		// logout tokens must not follow redirects
+		opts.Client = &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return webhook.NewQueue("back-channel logout", webhook.Options{
+		MaxAttempts: opts.MaxAttempts,
+		Backoff:     opts.Backoff,
+		Workers:     opts.Workers,
+		QueueSize:   opts.QueueSize,
+		Client:      opts.Client,
+		Logger:      logger,
+	})
+}
+
+// logoutDelivery posts the logout token to the back-channel logout URI of the client.
+func logoutDelivery(client *model.Client, token string) webhook.Delivery {
+	uri := client.BackchannelLogoutURI
+	return webhook.Delivery{
+		Target: client.ID,
+		Request: func() (*http.Request, error) {
+			form := url.Values{"logout_token": {token}}
+			req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(form.Encode()))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req, nil
+		},
+	}
+}
+
+// logoutToken returns the logout token telling the client the session ended.
+func (s *server) logoutToken(ctx context.Context, client *model.Client, session *model.Session) (string, error) {
+	sub, err := s.subjectFor(ctx, client, session.UserID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return jwt.Signed(s.logoutTokenSigner).Claims(logoutTokenClaims{
+		Claims: jwt.Claims{
+			ID:       guid.New().String(),
+			Issuer:   s.issuer,
+			Subject:  sub,
+			Audience: []string{client.ID},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(logoutTokenTTL)),
+		},
+		Sid:    session.ID,
+		Events: map[string]map[string]any{backchannelLogoutEvent: {}},
+	}).Serialize()
+}
+
+// frontchannelLogoutURI returns the URI the browser loads to sign the user out
+// of the client, carrying the issuer and session when the client asks for them.
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html#OPLogout
+func (s *server) frontchannelLogoutURI(client *model.Client, session *model.Session) (string, error) {
+	u, err := url.Parse(client.FrontchannelLogoutURI)
+	if err != nil {
+		return "", err
+	}
+	if client.FrontchannelLogoutSessionRequired {
+		q := u.Query()
+		q.Set("iss", s.issuer)
+		q.Set("sid", session.ID)
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// notifyUserLogout tells the clients of the sessions of a user, but of the
+// kept one, that they ended along with the account or its password. Nobody is
+// there to load the front-channel logout URIs.
+func (s *server) notifyUserLogout(ctx context.Context, sessions []*model.Session, keep string) {
+	for _, session := range sessions {
+		if session.ID != keep {
+			s.notifyLogout(ctx, session)
+		}
+	}
+}
+
+// notifyLogout tells the clients of the ended session about the logout. Logout
+// tokens are queued for the back-channel logout URIs, the front-channel logout
+// URIs are returned for the browser to load.
+func (s *server) notifyLogout(ctx context.Context, session *model.Session) []string {
+	var frontchannel []string
+	for _, clientID := range session.Clients {
+		client, err := s.db.GetClient(ctx, clientID)
+		if err != nil {
+			s.logger.Warn("failed to notify client of logout", xlog.Str("client", clientID), xlog.Err(err))
+			continue
+		}
+		if client.BackchannelLogoutURI != "" {
+			token, err := s.logoutToken(ctx, client, session)
+			if err != nil {
+				s.logger.Error("failed to create logout token", xlog.Str("client", clientID), xlog.Err(err))
+			} else {
+				s.backchannel.Enqueue(logoutDelivery(client, token))
+			}
+		}
+		if client.FrontchannelLogoutURI != "" {
+			uri, err := s.frontchannelLogoutURI(client, session)
+			if err != nil {
+				s.logger.Warn("invalid front-channel logout uri", xlog.Str("client", clientID), xlog.Err(err))
+				continue
+			}
+			frontchannel = append(frontchannel, uri)
+		}
+	}
+	return frontchannel
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"golang.org/x/crypto/bcrypt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+func TestLogoutNotifications(t *testing.T) {
+	tokens := make(chan string, 1)
+	var attempts atomic.Int32
+	rp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first delivery fails and is retried
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		tokens <- r.PostFormValue("logout_token")
+	}))
+	defer rp.Close()
+
+	s := newTestServer(WithBackchannelOptions(BackchannelOptions{Backoff: time.Millisecond}))
+	defer s.Shoutdown(context.Background())
+	client := &model.Client{
+		ID:                                "app",
+		RedirectURIs:                      model.Strings{"https://app.example.com/callback"},
+		BackchannelLogoutURI:              rp.URL + "/logout",
+		FrontchannelLogoutURI:             "https://app.example.com/frontchannel",
+		FrontchannelLogoutSessionRequired: true,
+	}
+	s.db = &sessionStorage{
+		clientStorage: clientStorage{clients: map[string]*model.Client{client.ID: client}},
+		sessions:      map[string]*model.Session{},
+	}
+	w := httptest.NewRecorder()
+	sess, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), suid.New(), []string{AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.joinSession(context.Background(), sess.ID, client.ID); err != nil {
+		t.Fatal(err)
+	}
+	idToken, err := s.createIDToken(context.Background(), idTokenRequest{UserID: sess.UserID, Client: client, SessionID: sess.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok, err := jwt.ParseSigned(idToken, []jose.SignatureAlgorithm{jose.EdDSA}); err != nil {
+		t.Fatal(err)
+	} else {
+		var claims idTokenClaims
+		if err := tok.Claims(s.secret, &claims); err != nil || claims.Sid != sess.ID {
+			t.Errorf("id token sid = %q, %v", claims.Sid, err)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	w = httptest.NewRecorder()
+	s.handleLogout(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout status = %d", w.Code)
+	}
+	var resp logoutResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := "https://app.example.com/frontchannel?" + url.Values{"iss": {s.issuer}, "sid": {sess.ID}}.Encode()
+	if len(resp.FrontchannelLogoutURIs) != 1 || resp.FrontchannelLogoutURIs[0] != want {
+		t.Errorf("front-channel logout uris = %v, want %s", resp.FrontchannelLogoutURIs, want)
+	}
+
+	var token string
+	select {
+	case token = <-tokens:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no logout token delivered")
+	}
+	tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := tok.Headers[0].ExtraHeaders[jose.HeaderType]; typ != "logout+jwt" {
+		t.Errorf("typ = %v", typ)
+	}
+	var claims logoutTokenClaims
+	if err := tok.Claims(s.secret, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if err := claims.Validate(jwt.Expected{Issuer: s.issuer, AnyAudience: jwt.Audience{"app"}, Time: time.Now()}); err != nil {
+		t.Error(err)
+	}
+	if _, ok := claims.Events[backchannelLogoutEvent]; !ok || claims.Sid != sess.ID || claims.Subject != sess.UserID.String() {
+		t.Errorf("logout token claims = %+v", claims)
+	}
+}
+
+func TestPasswordChangeLogout(t *testing.T) {
+	sids := make(chan string, 2)
+	rp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, err := jwt.ParseSigned(r.PostFormValue("logout_token"), []jose.SignatureAlgorithm{jose.EdDSA})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var claims logoutTokenClaims
+		tok.UnsafeClaimsWithoutVerification(&claims)
+		sids <- claims.Sid
+	}))
+	defer rp.Close()
+
+	s := newTestServer()
+	defer s.Shoutdown(context.Background())
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{ID: suid.New(), Hash: string(hash)}
+	client := &model.Client{ID: "app", BackchannelLogoutURI: rp.URL + "/logout"}
+	db := &mfaStorage{sessionStorage: sessionStorage{sessions: map[string]*model.Session{}}, user: user}
+	db.clients = map[string]*model.Client{client.ID: client}
+	s.db = db
+	var sessions []*authSession
+	var cookie *http.Cookie
+	for range 2 {
+		w := httptest.NewRecorder()
+		sess, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), user.ID, []string{AMRPassword})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.joinSession(context.Background(), sess.ID, client.ID); err != nil {
+			t.Fatal(err)
+		}
+		sessions, cookie = append(sessions, sess), w.Result().Cookies()[0]
+	}
+	r := httptest.NewRequest("POST", "/password", strings.NewReader(`{"old_password":"secret","new_password":"changed"}`))
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	s.handlePassword(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("change status = %d", w.Code)
+	}
+	select {
+	case sid := <-sids:
+		if sid != sessions[0].ID {
+			t.Errorf("logout token of session %s, want the other session %s", sid, sessions[0].ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no logout token delivered")
+	}
+	select {
+	case sid := <-sids:
+		t.Errorf("logout token of the kept session %s", sid)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
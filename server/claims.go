@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/scope"
+	"sutext.github.io/entry/xerr"
+)
+
+// ClaimSource adds claims about users from other systems, e.g. an HR database.
+// Sources are asked in the order they are registered, the standard claims of
+// the user and claims of earlier sources are never replaced.
+type ClaimSource interface {
+	// Claims returns the claims the source knows about the user. Only the claims
+	// named in claims.Requested are released to the client.
+	Claims(ctx context.Context, user *model.User, client *model.Client, claims *model.Claims) (map[string]any, error)
+}
+
+// parseClaimsRequest parses the claims request parameter.
+// https://openid.net/specs/openid-connect-core-1_0.html#ClaimsParameter
+func parseClaimsRequest(param string) (model.ClaimsRequest, error) {
+	var req model.ClaimsRequest
+	if param == "" {
+		return req, nil
+	}
+	if err := json.Unmarshal([]byte(param), &req); err != nil {
+		return req, xerr.ErrInvalidRequest
+	}
+	return req, nil
+}
+
+// authenticationClaims describe the authentication rather than the user, they
+// are no personal data the user has to consent to.
+var authenticationClaims = []string{"sub", "acr", "amr", "auth_time"}
+
+// extraClaims returns the individually requested claims about the user which
+// the scopes of the request do not release, the user has to consent to them.
+func extraClaims(req *AuthorizeRequest) []string {
+	var covered []string
+	for _, sc := range scope.Parse(req.Scope) {
+		covered = append(covered, scopeClaims[sc]...)
+	}
+	var names []string
+	for _, requested := range []map[string]*model.ClaimRequest{req.Claims.UserInfo, req.Claims.IDToken} {
+		for name := range requested {
+			if !slices.Contains(covered, name) && !slices.Contains(authenticationClaims, name) && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// userClaims returns the claims about the user released to the client: the
+// claims of the scopes and the individually requested claims, which the user has
+// consented to, see extraClaims. Essential and voluntary claims are released
+// alike, but claims not matching a requested value are left out.
+func (s *server) userClaims(ctx context.Context, user *model.User, client *model.Client, scopes scope.Scopes, requested map[string]*model.ClaimRequest) (map[string]any, error) {
+	var names []string
+	for _, sc := range scopes {
+		names = append(names, scopeClaims[sc]...)
+	}
+	for name := range maps.Keys(requested) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	all := standardClaims(user)
+	if len(s.claimSources) > 0 && len(names) > 0 {
+		claims := &model.Claims{UserID: user.ID, Requested: names}
+		if client != nil {
+			claims.ClientID = client.ID
+		}
+		for _, src := range s.claimSources {
+			extra, err := src.Claims(ctx, user, client, claims)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range extra {
+				if _, ok := all[k]; !ok {
+					all[k] = v
+				}
+			}
+		}
+	}
+	released := make(map[string]any)
+	for _, name := range names {
+		v, ok := all[name]
+		if ok && requested[name].Matches(v) {
+			released[name] = v
+		}
+	}
+	return released, nil
+}
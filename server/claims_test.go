@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/scope"
+)
+
+type hrSource struct{}
+
+func (hrSource) Claims(ctx context.Context, user *model.User, client *model.Client, claims *model.Claims) (map[string]any, error) {
+	return map[string]any{"department": "sales", "email": "spoofed@example.com"}, nil
+}
+
+func TestUserClaims(t *testing.T) {
+	s := newTestServer(WithClaimSource(hrSource{}))
+	email, nickname := "user@example.com", "user"
+	user := model.NewUser()
+	user.Email = &email
+	user.Nickname = &nickname
+	req, err := parseClaimsRequest(`{"userinfo":{"department":{"essential":true},"nickname":{"value":"other"},"email":null}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.userClaims(context.Background(), user, nil, scope.Parse("openid profile"), req.UserInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["department"] != "sales" {
+		t.Errorf("department = %v, want the claim of the source", claims["department"])
+	}
+	if claims["email"] != email {
+		t.Errorf("email = %v, sources must not replace standard claims", claims["email"])
+	}
+	if _, ok := claims["nickname"]; ok {
+		t.Errorf("nickname released although it does not match the requested value")
+	}
+	if _, ok := claims["updated_at"]; !ok {
+		t.Errorf("profile claims missing: %v", claims)
+	}
+	if _, err := parseClaimsRequest(`{"userinfo":[]}`); err == nil {
+		t.Error("malformed claims request accepted")
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+)
+
+func TestClientType(t *testing.T) {
+	if model.NewClient().IsPublic() {
+		t.Error("new client with a secret is public")
+	}
+	s := New().(*server)
+	public := &model.Client{ID: "spa", Type: model.ClientTypePublic, Scopes: model.Strings{"openid"},
+		RedirectURIs: model.Strings{"https://spa.example.com/cb"}, PKCEPolicy: model.PKCEOptional}
+	// the deprecated flag does not turn a confidential client into a public one
+	confidential := &model.Client{ID: "web", Type: model.ClientTypeConfidential, Secret: "secret", Public: true,
+		Scopes: model.Strings{"openid"}, RedirectURIs: model.Strings{"https://web.example.com/cb"},
+		TrustedPeers: model.Strings{"192.0.2.1:1234"}, PKCEPolicy: model.PKCEOptional}
+	s.db = &clientStorage{clients: map[string]*model.Client{"spa": public, "web": confidential}}
+
+	for _, tc := range []struct {
+		form  string
+		grant GrantType
+		want  error
+	}{
+		{"client_id=spa", AuthorizationCode, nil},
+		{"client_id=spa&client_secret=x", ClientCredentials, xerr.ErrUnauthorizedClient},
+		{"client_id=spa", PasswordCredentials, xerr.ErrUnauthorizedClient},
+		{"client_id=web", AuthorizationCode, xerr.ErrUnauthorizedClient},
+		{"client_id=web&client_secret=wrong", AuthorizationCode, xerr.ErrUnauthorizedClient},
+		{"client_id=web&client_secret=secret", AuthorizationCode, nil},
+		{"client_id=web&client_secret=secret", ClientCredentials, nil},
+	} {
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(tc.form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if _, err := s.authenticateClient(r, tc.grant); err != tc.want {
+			t.Errorf("%s with %s: err = %v, want %v", tc.grant, tc.form, err, tc.want)
+		}
+	}
+
+	for client, want := range map[*model.Client]error{public: xerr.ErrCodeChallengeRquired, confidential: nil} {
+		req := &AuthorizeRequest{ClientID: client.ID, ResponseType: ResponseTypeCode, Scope: "openid", RedirectURI: client.RedirectURIs[0]}
+		r := httptest.NewRequest("GET", "/oauth/authorize?"+url.Values{"client_id": {client.ID}}.Encode(), nil)
+		if err := s.validateClientSettings(client, req, r); err != want {
+			t.Errorf("%s client without code challenge: err = %v, want %v", client.Type, err, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/rar"
+	"sutext.github.io/entry/xerr"
+)
+
+// parseAuthorizationDetails decodes the authorization_details parameter of a
+// request and checks the objects against the registered types and the client.
+// https://www.rfc-editor.org/rfc/rfc9396#section-5
+func (s *server) parseAuthorizationDetails(client *model.Client, value string) (rar.Details, error) {
+	details, err := rar.Parse(value)
+	if err != nil {
+		return nil, xerr.ErrInvalidAuthorizationDetails
+	}
+	if err := s.detailsTypes.Validate(details); err != nil {
+		s.logger.Warn("rejected authorization details: " + err.Error())
+		return nil, xerr.ErrInvalidAuthorizationDetails
+	}
+	for _, typ := range details.Types() {
+		if !client.AllowsAuthorizationDetailsType(typ) {
+			return nil, xerr.ErrInvalidAuthorizationDetails
+		}
+	}
+	return details, nil
+}
+
+// narrowAuthorizationDetails returns the authorization details requested at the
+// token endpoint, which must be a subset of the granted ones. No request keeps
+// all granted details.
+func (s *server) narrowAuthorizationDetails(granted rar.Details, value string) (rar.Details, error) {
+	requested, err := rar.Parse(value)
+	if err != nil {
+		return nil, xerr.ErrInvalidAuthorizationDetails
+	}
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	if !granted.Contains(requested) {
+		return nil, xerr.ErrInvalidAuthorizationDetails
+	}
+	return requested, nil
+}
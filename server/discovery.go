@@ -4,25 +4,48 @@ import (
 	"encoding/json"
 	"net/http"
 	"sort"
+
+	"github.com/go-jose/go-jose/v4"
+	"sutext.github.io/entry/model"
 )
 
 type discovery struct {
-	Issuer             string `json:"issuer"`
-	JwksURI            string `json:"jwks_uri"`
-	AuthEndpoint       string `json:"authorization_endpoint"`
-	TokenEndpoint      string `json:"token_endpoint"`
-	UserInfoEndpoint   string `json:"userinfo_endpoint"`
-	DeviceEndpoint     string `json:"device_authorization_endpoint"`
-	IntrospectEndpoint string `json:"introspection_endpoint"`
-	// RevocationEndpoint string   `json:"revocation_endpoint"`
-	GrantTypes        []string `json:"grant_types_supported"`
-	ResponseTypes     []string `json:"response_types_supported"`
-	Subjects          []string `json:"subject_types_supported"`
-	IDTokenAlgs       []string `json:"id_token_signing_alg_values_supported"`
-	CodeChallengeAlgs []string `json:"code_challenge_methods_supported"`
-	Scopes            []string `json:"scopes_supported"`
-	AuthMethods       []string `json:"token_endpoint_auth_methods_supported"`
-	Claims            []string `json:"claims_supported"`
+	Issuer             string   `json:"issuer"`
+	JwksURI            string   `json:"jwks_uri"`
+	AuthEndpoint       string   `json:"authorization_endpoint"`
+	TokenEndpoint      string   `json:"token_endpoint"`
+	UserInfoEndpoint   string   `json:"userinfo_endpoint"`
+	DeviceEndpoint     string   `json:"device_authorization_endpoint"`
+	IntrospectEndpoint string   `json:"introspection_endpoint"`
+	EndSessionEndpoint string   `json:"end_session_endpoint"`
+	PAREndpoint        string   `json:"pushed_authorization_request_endpoint"`
+	RevocationEndpoint string   `json:"revocation_endpoint"`
+	GrantTypes         []string `json:"grant_types_supported"`
+	ResponseTypes      []string `json:"response_types_supported"`
+	ResponseModes      []string `json:"response_modes_supported"`
+	IssParameter       bool     `json:"authorization_response_iss_parameter_supported"`
+	DetailsTypes       []string `json:"authorization_details_types_supported,omitempty"`
+	// JWT Secured Authorization Response Mode
+	AuthorizationSigningAlgs    []string `json:"authorization_signing_alg_values_supported"`
+	AuthorizationEncryptionAlgs []string `json:"authorization_encryption_alg_values_supported"`
+	AuthorizationEncryptionEncs []string `json:"authorization_encryption_enc_values_supported"`
+	UserinfoSigningAlgs         []string `json:"userinfo_signing_alg_values_supported"`
+	UserinfoEncryptionAlgs      []string `json:"userinfo_encryption_alg_values_supported"`
+	UserinfoEncryptionEncs      []string `json:"userinfo_encryption_enc_values_supported"`
+	DPoPSigningAlgs             []string `json:"dpop_signing_alg_values_supported"`
+	ACRValues                   []string `json:"acr_values_supported"`
+	Subjects                    []string `json:"subject_types_supported"`
+	IDTokenAlgs                 []string `json:"id_token_signing_alg_values_supported"`
+	CodeChallengeAlgs           []string `json:"code_challenge_methods_supported"`
+	Scopes                      []string `json:"scopes_supported"`
+	AuthMethods                 []string `json:"token_endpoint_auth_methods_supported"`
+	Claims                      []string `json:"claims_supported"`
+	ClaimsParameter             bool     `json:"claims_parameter_supported"`
+	// OpenID Connect Back-Channel and Front-Channel Logout
+	BackchannelLogout         bool `json:"backchannel_logout_supported"`
+	BackchannelLogoutSession  bool `json:"backchannel_logout_session_supported"`
+	FrontchannelLogout        bool `json:"frontchannel_logout_supported"`
+	FrontchannelLogoutSession bool `json:"frontchannel_logout_session_supported"`
 }
 
 func (s *server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
@@ -37,20 +60,38 @@ func (s *server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
 }
 func (s *server) constructDiscovery() discovery {
 	d := discovery{
-		Issuer:             s.issuerURL.String(),
+		Issuer:             s.issuer,
 		AuthEndpoint:       s.endpoints.Authorize,
 		TokenEndpoint:      s.endpoints.Token,
 		JwksURI:            s.endpoints.JWKS,
 		UserInfoEndpoint:   s.endpoints.UserInfo,
 		DeviceEndpoint:     s.endpoints.Device,
 		IntrospectEndpoint: s.endpoints.Introspect,
-		Subjects:           []string{"public"},
-		IDTokenAlgs:        []string{"RS256"},
-		CodeChallengeAlgs:  []string{"plain", "S256"},
-		Scopes:             []string{"openid", "email", "profile"},
-		AuthMethods:        []string{"client_secret_basic", "client_secret_post"},
+		EndSessionEndpoint: s.endpoints.EndSession,
+		PAREndpoint:        s.endpoints.PAR,
+		RevocationEndpoint: s.endpoints.Revoke,
+		Subjects:           []string{string(model.SubjectPublic), string(model.SubjectPairwise)},
+		ResponseModes: []string{
+			string(ResponseModeQuery),
+			string(ResponseModeFragment),
+			string(ResponseModeFormPost),
+			string(ResponseModeQueryJWT),
+			string(ResponseModeFragmentJWT),
+			string(ResponseModeFormPostJWT),
+			string(ResponseModeJWT),
+		},
+		IssParameter:             true,
+		ACRValues:                acrLevels,
+		ClaimsParameter:          true,
+		AuthorizationSigningAlgs: []string{string(jose.EdDSA)},
+		IDTokenAlgs:              []string{string(jose.EdDSA)},
+		UserinfoSigningAlgs:      []string{string(jose.EdDSA)},
+		CodeChallengeAlgs:        []string{"plain", "S256"},
+		Scopes:                   []string{"openid", "email", "phone", "profile"},
+		AuthMethods:              []string{"client_secret_basic", "client_secret_post"},
 		Claims: []string{
-			"iss", "sub", "aud", "iat", "exp", "email", "phone",
+			"iss", "sub", "aud", "iat", "exp", "nonce", "auth_time", "acr", "amr", "sid", "email", "phone_number",
+			"preferred_username", "nickname", "picture", "gender", "birthdate", "updated_at",
 		},
 	}
 
@@ -63,5 +104,44 @@ func (s *server) constructDiscovery() discovery {
 		d.GrantTypes = append(d.GrantTypes, grantType)
 	}
 	sort.Strings(d.GrantTypes)
+
+	for typ := range s.detailsTypes {
+		d.DetailsTypes = append(d.DetailsTypes, typ)
+	}
+	sort.Strings(d.DetailsTypes)
+
+	for _, alg := range responseEncryptionAlgs {
+		d.AuthorizationEncryptionAlgs = append(d.AuthorizationEncryptionAlgs, string(alg))
+	}
+	for _, enc := range responseEncryptionEncs {
+		d.AuthorizationEncryptionEncs = append(d.AuthorizationEncryptionEncs, string(enc))
+	}
+	d.BackchannelLogout, d.BackchannelLogoutSession = true, true
+	d.FrontchannelLogout, d.FrontchannelLogoutSession = true, true
+	d.UserinfoEncryptionAlgs = d.AuthorizationEncryptionAlgs
+	d.UserinfoEncryptionEncs = d.AuthorizationEncryptionEncs
+	for _, alg := range dpopSigningAlgs {
+		d.DPoPSigningAlgs = append(d.DPoPSigningAlgs, string(alg))
+	}
 	return d
 }
+
+// handleJWKS publishes the public keys verifying the tokens signed by this server.
+func (s *server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{
+			Key:       s.secret,
+			KeyID:     s.keyID,
+			Algorithm: string(jose.EdDSA),
+			Use:       "sig",
+		}},
+	}
+	data, err := json.MarshalIndent(jwks, "", "  ")
+	if err != nil {
+		http.Error(w, "failed to marshal jwks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(data)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// dpopProofTTL bounds how far the iat of a DPoP proof may be off the server time.
+const dpopProofTTL = 5 * time.Minute
+
+var dpopSigningAlgs = []jose.SignatureAlgorithm{jose.EdDSA, jose.ES256, jose.ES384, jose.RS256, jose.PS256}
+
+// dpopClaims are the claims of a DPoP proof.
+type dpopClaims struct {
+	ID              string           `json:"jti"`
+	Method          string           `json:"htm"`
+	URI             string           `json:"htu"`
+	IssuedAt        *jwt.NumericDate `json:"iat"`
+	AccessTokenHash string           `json:"ath,omitempty"`
+}
+
+// confirmation is the cnf claim binding an access token to the key of a DPoP proof.
+type confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+type dpopKey struct{}
+
+// withDPoP records the thumbprint of the DPoP key the tokens issued for the
+// request are bound to.
+func withDPoP(r *http.Request, jkt string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), dpopKey{}, jkt))
+}
+
+// dpopThumbprint returns the thumbprint recorded by withDPoP.
+func dpopThumbprint(ctx context.Context) string {
+	jkt, _ := ctx.Value(dpopKey{}).(string)
+	return jkt
+}
+
+// verifyDPoPProof checks the DPoP proof of the request and returns the JWK
+// thumbprint of its key. The access token is empty at the token endpoint and
+// must be covered by the ath claim everywhere else.
+// https://www.rfc-editor.org/rfc/rfc9449#section-4.3
+func (s *server) verifyDPoPProof(r *http.Request, accessToken string) (string, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return "", fmt.Errorf("exactly one dpop proof is required")
+	}
+	tok, err := jwt.ParseSigned(proofs[0], dpopSigningAlgs)
+	if err != nil {
+		return "", err
+	}
+	header := tok.Headers[0]
+	if header.ExtraHeaders[jose.HeaderType] != "dpop+jwt" {
+		return "", fmt.Errorf("dpop proof is not typed dpop+jwt")
+	}
+	key := header.JSONWebKey
+	if key == nil || !key.IsPublic() {
+		return "", fmt.Errorf("dpop proof has no public jwk")
+	}
+	var claims dpopClaims
+	if err = tok.Claims(key.Key, &claims); err != nil {
+		return "", err
+	}
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return "", fmt.Errorf("dpop proof lacks jti or iat")
+	}
+	if claims.Method != r.Method {
+		return "", fmt.Errorf("dpop proof htm %q does not match %s", claims.Method, r.Method)
+	}
+	htu, err := url.Parse(claims.URI)
+	if err != nil {
+		return "", err
+	}
+	htu.RawQuery, htu.Fragment = "", ""
+	if htu.String() != s.absURL(r.URL.Path) {
+		return "", fmt.Errorf("dpop proof htu %q does not match the request", claims.URI)
+	}
+	if skew := time.Since(claims.IssuedAt.Time()); skew > dpopProofTTL || skew < -dpopProofTTL {
+		return "", fmt.Errorf("dpop proof is expired")
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.AccessTokenHash != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", fmt.Errorf("dpop proof ath does not match the access token")
+		}
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+	// a proof is accepted once per key
+	replay := jkt + ":" + claims.ID
+	if _, err := s.dpopCache.Get(replay); err == nil {
+		return "", fmt.Errorf("dpop proof is replayed")
+	}
+	if err := s.dpopCache.Set(replay, true, 2*dpopProofTTL); err != nil {
+		return "", err
+	}
+	return jkt, nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/scope"
+	"sutext.github.io/entry/xerr"
+)
+
+// Token type identifiers
+// https://www.rfc-editor.org/rfc/rfc8693#section-3
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeIDToken     = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// actorClaim identifies the party acting on behalf of the subject. Nested
+// actors record the prior links of a delegation chain.
+type actorClaim struct {
+	Subject string      `json:"sub"`
+	Actor   *actorClaim `json:"act,omitempty"`
+}
+
+// validateTokenExchangeGrant exchanges a token issued by this server for a token
+// targeted at another audience, as allowed by the client's token exchange policy.
+// https://www.rfc-editor.org/rfc/rfc8693
+func (s *server) validateTokenExchangeGrant(r *http.Request) (data map[string]any, err error) {
+	client, err := s.authenticateClient(r, TokenExchange)
+	if err != nil {
+		return data, err
+	}
+	policy := client.TokenExchange
+	subject, err := s.parseExchangeToken(r.Context(), r.FormValue("subject_token"), r.FormValue("subject_token_type"))
+	if err != nil {
+		return data, xerr.ErrInvalidRequest
+	}
+	if !policy.AllowsSubjectClient(subject.ClientID) && !slices.ContainsFunc(subject.Audience, policy.AllowsSubjectClient) {
+		return data, xerr.ErrInvalidGrant
+	}
+	actor := subject.Actor
+	if actorToken := r.FormValue("actor_token"); actorToken != "" {
+		if !policy.Delegation {
+			return data, xerr.ErrUnauthorizedClient
+		}
+		act, err := s.parseExchangeToken(r.Context(), actorToken, r.FormValue("actor_token_type"))
+		if err != nil {
+			return data, xerr.ErrInvalidRequest
+		}
+		actor = &actorClaim{Subject: act.Subject, Actor: subject.Actor}
+	} else if r.FormValue("actor_token_type") != "" {
+		return data, xerr.ErrInvalidRequest
+	} else if !policy.Impersonation {
+		return data, xerr.ErrUnauthorizedClient
+	}
+	issuedType := r.FormValue("requested_token_type")
+	switch issuedType {
+	case "":
+		issuedType = TokenTypeAccessToken
+	case TokenTypeAccessToken, TokenTypeJWT:
+	default:
+		return data, xerr.ErrInvalidRequest
+	}
+	audiences := append(slices.Clone(r.Form["audience"]), r.Form["resource"]...)
+	if len(audiences) == 0 {
+		return data, xerr.ErrInvalidTarget
+	}
+	for _, aud := range audiences {
+		if !policy.AllowsAudience(aud) {
+			return data, xerr.ErrInvalidTarget
+		}
+	}
+	// the issued token can only narrow the scope of the subject token; a
+	// subject token without a scope, e.g. an ID token, grants none
+	scp := r.FormValue("scope")
+	if scp == "" {
+		scp = subject.Scope
+	} else if subject.Scope == "" || !scope.Parse(subject.Scope).ContainsAll(scope.Parse(scp)) {
+		return data, xerr.ErrInvalidScope
+	}
+	if !client.Scopes.Contains(scp) {
+		return data, xerr.ErrInvalidScope
+	}
+	// the issued token never outlives the subject token
+	ttl := s.accessTokenTTL(client)
+	if subject.Expiry != nil {
+		ttl = min(ttl, time.Until(subject.Expiry.Time()))
+	}
+	// pairwise subjects are derived again for the client
+	userID := subject.Subject
+	if uid, err := s.resolveSubject(r.Context(), subject.Subject); err == nil {
+		userID = uid.String()
+	}
+	tgr := &TokenGenerateRequest{
+		GrantType:      TokenExchange,
+		ClientID:       client.ID,
+		Client:         client,
+		UserID:         userID,
+		Scope:          scp,
+		Audience:       audiences,
+		AccessTokenExp: ttl,
+		Request:        r,
+		actor:          actor,
+	}
+	if subject.AuthTime != 0 {
+		tgr.AuthTime = time.Unix(subject.AuthTime, 0)
+		tgr.Acr = subject.Acr
+		tgr.Amr = subject.Amr
+	}
+	accessToken, err := s.generateAccessToken(tgr)
+	if err != nil {
+		return data, err
+	}
+	data = s.getTokenData(tgr, accessToken)
+	data["issued_token_type"] = issuedType
+	s.audit(r, model.AuditTokenIssued, model.AuditSuccess, subject.Subject, client.ID, TokenExchange.String())
+	return data, nil
+}
+
+// parseExchangeToken validates a subject or actor token of a token exchange.
+// Only tokens issued by this server are accepted, and only of the declared type:
+// an access token never passes for an ID token or the other way around.
+func (s *server) parseExchangeToken(ctx context.Context, token, tokenType string) (*accessTokenClaims, error) {
+	if token == "" {
+		return nil, xerr.ErrInvalidRequest
+	}
+	switch tokenType {
+	case TokenTypeAccessToken:
+		// access tokens may be opaque
+		claims, _, err := s.validateAccessToken(ctx, token)
+		return claims, err
+	case TokenTypeIDToken:
+		return s.parseExchangeIDToken(token)
+	case TokenTypeJWT:
+		// either kind of JWT, each checked as what it is
+		if strings.Count(token, ".") != 2 {
+			return nil, xerr.ErrInvalidRequest
+		}
+		if claims, _, err := s.validateAccessToken(ctx, token); err == nil {
+			return claims, nil
+		}
+		return s.parseExchangeIDToken(token)
+	default:
+		return nil, xerr.ErrInvalidRequest
+	}
+}
+
+// parseExchangeIDToken validates an unexpired ID token and returns the claims
+// it shares with access tokens. The client it was issued to is its audience.
+func (s *server) parseExchangeIDToken(token string) (*accessTokenClaims, error) {
+	claims, err := s.parseIDToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err = claims.Validate(jwt.Expected{Issuer: s.issuer, Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return &accessTokenClaims{
+		Claims:   claims.Claims,
+		AuthTime: claims.AuthTime,
+		Acr:      claims.Acr,
+		Amr:      claims.Amr,
+	}, nil
+}
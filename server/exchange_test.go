@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+)
+
+func TestTokenExchange(t *testing.T) {
+	s := newTestServer()
+	client := &model.Client{
+		ID:           "api",
+		Type:         model.ClientTypeConfidential,
+		Secret:       "secret",
+		Scopes:       model.Strings{"read", "write"},
+		TrustedPeers: model.Strings{"192.0.2.1:1234"},
+		TokenExchange: model.TokenExchangePolicy{
+			Audiences:  model.Strings{"https://backend.example.com"},
+			Delegation: true,
+		},
+	}
+	s.db = &clientStorage{clients: map[string]*model.Client{"api": client}}
+	subject, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "spa", UserID: "user", Scope: "read write", Audience: []string{"spa"}, AccessTokenExp: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "api", AccessTokenExp: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := func(form url.Values) (map[string]any, error) {
+		form.Set("client_id", "api")
+		form.Set("client_secret", "secret")
+		form.Set("subject_token", subject)
+		form.Set("subject_token_type", TokenTypeAccessToken)
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return s.validateTokenExchangeGrant(r)
+	}
+
+	if _, err := exchange(url.Values{"audience": {"https://backend.example.com"}}); err != xerr.ErrUnauthorizedClient {
+		t.Errorf("impersonation: err = %v, want %v", err, xerr.ErrUnauthorizedClient)
+	}
+	if _, err := exchange(url.Values{
+		"audience":         {"https://other.example.com"},
+		"actor_token":      {actor},
+		"actor_token_type": {TokenTypeAccessToken},
+	}); err != xerr.ErrInvalidTarget {
+		t.Errorf("audience: err = %v, want %v", err, xerr.ErrInvalidTarget)
+	}
+	data, err := exchange(url.Values{
+		"resource":         {"https://backend.example.com"},
+		"actor_token":      {actor},
+		"actor_token_type": {TokenTypeAccessToken},
+		"scope":            {"read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.parseToken(data["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user" || claims.Actor == nil || claims.Actor.Subject != "api" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if claims.Scope != "read" || !claims.Audience.Contains("https://backend.example.com") {
+		t.Errorf("unexpected scope or audience: %+v", claims)
+	}
+	if data["issued_token_type"] != TokenTypeAccessToken {
+		t.Errorf("issued_token_type = %v", data["issued_token_type"])
+	}
+}
+
+func TestTokenExchangeTokenType(t *testing.T) {
+	s := newTestServer()
+	client := &model.Client{
+		ID:           "api",
+		Type:         model.ClientTypeConfidential,
+		Secret:       "secret",
+		Scopes:       model.Strings{"read"},
+		TrustedPeers: model.Strings{"192.0.2.1:1234"},
+		TokenExchange: model.TokenExchangePolicy{
+			Audiences:     model.Strings{"https://backend.example.com"},
+			Impersonation: true,
+		},
+	}
+	s.db = &clientStorage{clients: map[string]*model.Client{"api": client}}
+	accessToken, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "spa", UserID: "user", Scope: "read", AccessTokenExp: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, err := s.createIDToken(t.Context(), idTokenRequest{UserID: 42, Client: &model.Client{ID: "spa"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := func(token, tokenType string, scope ...string) error {
+		form := url.Values{
+			"client_id":          {"api"},
+			"client_secret":      {"secret"},
+			"subject_token":      {token},
+			"subject_token_type": {tokenType},
+			"audience":           {"https://backend.example.com"},
+			"scope":              scope,
+		}
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, err := s.validateTokenExchangeGrant(r)
+		return err
+	}
+	for _, tc := range []struct {
+		token, tokenType string
+		ok               bool
+	}{
+		{accessToken, TokenTypeAccessToken, true},
+		{accessToken, TokenTypeIDToken, false},
+		{accessToken, TokenTypeJWT, true},
+		{idToken, TokenTypeIDToken, true},
+		{idToken, TokenTypeAccessToken, false},
+		{idToken, TokenTypeJWT, true},
+	} {
+		if err := exchange(tc.token, tc.tokenType); (err == nil) != tc.ok {
+			t.Errorf("%s token declared as %s: err = %v", map[string]string{accessToken: "access", idToken: "id"}[tc.token], tc.tokenType, err)
+		}
+	}
+	// an ID token has no scope to narrow
+	if err := exchange(idToken, TokenTypeIDToken, "read"); err != xerr.ErrInvalidScope {
+		t.Errorf("scope from an ID token: err = %v, want %v", err, xerr.ErrInvalidScope)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/rar"
+)
+
+type grantView struct {
+	ClientID   string      `json:"client_id"`
+	ClientName string      `json:"client_name"`
+	ClientLogo string      `json:"client_logo,omitempty"`
+	Scopes     []string    `json:"scopes"`
+	Details    rar.Details `json:"authorization_details,omitempty"`
+	CreatedAt  int64       `json:"created_at"`
+	UpdatedAt  int64       `json:"updated_at"`
+}
+
+// handleGrants lists the clients the logged in user has consented to.
+func (s *server) handleGrants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	userID, err := s.ensureLoggedIn(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	grants, err := s.db.ListGrants(ctx, userID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	views := make([]grantView, 0, len(grants))
+	for _, g := range grants {
+		v := grantView{
+			ClientID:  g.ClientID,
+			Scopes:    g.Scopes,
+			Details:   g.AuthorizationDetails,
+			CreatedAt: g.CreatedAt.Unix(),
+			UpdatedAt: g.UpdatedAt.Unix(),
+		}
+		if client, err := s.db.GetClient(ctx, g.ClientID); err == nil {
+			v.ClientName = client.Name
+			v.ClientLogo = client.LogoURL
+		}
+		views = append(views, v)
+	}
+	s.writeJSON(w, http.StatusOK, views)
+}
+
+// handleRevokeGrant removes the consent of the logged in user for a client
+// together with every refresh token issued under it.
+func (s *server) handleRevokeGrant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	userID, err := s.ensureLoggedIn(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	clientID := r.PathValue("client_id")
+	if err := s.db.DeleteGrant(ctx, userID, clientID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// opaque access tokens die with the grant, JWTs run until they expire
+	if err := s.db.DeleteTokens(ctx, userID, clientID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	n, err := s.db.DeleteRefreshes(ctx, userID, clientID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if n > 0 {
+		s.audit(r, model.AuditTokenRevoked, model.AuditSuccess, userID.String(), clientID, "grant revoked")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
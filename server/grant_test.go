@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+	"sutext.github.io/suid/guid"
+)
+
+func TestRevokeGrant(t *testing.T) {
+	s := newTestServer()
+	client := &model.Client{
+		ID:           "web",
+		Type:         model.ClientTypeConfidential,
+		Secret:       "secret",
+		RedirectURIs: model.Strings{"https://web.example.com/cb"},
+		TrustedPeers: model.Strings{"192.0.2.1:1234"},
+	}
+	db := &grantStorage{
+		sessionStorage: sessionStorage{
+			clientStorage: clientStorage{clients: map[string]*model.Client{"web": client}},
+			sessions:      map[string]*model.Session{},
+		},
+		refreshes: map[guid.GUID]model.RefreshToken{},
+	}
+	s.db = db
+	userID := suid.New()
+	w := httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), userID, []string{AMRPassword}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	token := func(form url.Values) (int, map[string]any) {
+		form.Set("client_id", "web")
+		form.Set("client_secret", "secret")
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handleToken(w, r)
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	var refreshTokens []string
+	for _, code := range []string{"code1", "code2"} {
+		s.codeCache.Set(code, &AuthorizeRequest{
+			ClientID:    "web",
+			UserID:      userID,
+			Scope:       "read offline_access",
+			RedirectURI: "https://web.example.com/cb",
+			AuthTime:    time.Now(),
+		}, time.Minute)
+		status, resp := token(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://web.example.com/cb"}})
+		if status != http.StatusOK {
+			t.Fatalf("code exchange: %d %v", status, resp)
+		}
+		refreshTokens = append(refreshTokens, resp["refresh_token"].(string))
+	}
+	if len(db.refreshes) != 2 {
+		t.Fatalf("%d refresh tokens after two exchanges", len(db.refreshes))
+	}
+
+	r := httptest.NewRequest("DELETE", "/grants/web", nil)
+	r.SetPathValue("client_id", "web")
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	s.handleRevokeGrant(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d: %s", w.Code, w.Body)
+	}
+	for _, rt := range refreshTokens {
+		if status, resp := token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt}}); status == http.StatusOK {
+			t.Errorf("revoked refresh token %s still works: %v", rt, resp)
+		}
+	}
+}
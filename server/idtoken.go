@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/scope"
+	"sutext.github.io/suid"
+)
+
+// idTokenClaims are the claims of an OpenID Connect ID token.
+type idTokenClaims struct {
+	jwt.Claims
+	Nonce    string   `json:"nonce,omitempty"`
+	AuthTime int64    `json:"auth_time,omitempty"`
+	Acr      string   `json:"acr,omitempty"`
+	Amr      []string `json:"amr,omitempty"`
+	AtHash   string   `json:"at_hash,omitempty"`
+	// Sid identifies the browser session for logout notifications.
+	Sid   string `json:"sid,omitempty"`
+	CHash string `json:"c_hash,omitempty"`
+}
+
+// idTokenRequest holds what goes into an ID token.
+type idTokenRequest struct {
+	UserID suid.SUID
+	Client *model.Client
+	// Scope releases the claims of its scopes in the ID token, it is only set
+	// when no access token is issued to get them from the userinfo endpoint.
+	Scope string
+	// Claims are the claims requested individually for the ID token.
+	Claims   map[string]*model.ClaimRequest
+	Nonce    string
+	AuthTime time.Time
+	Acr      string
+	Amr      []string
+	// SessionID is the browser session the user authenticated in.
+	SessionID string
+	// Code and AccessToken are the values issued alongside the ID token
+	// from the authorization endpoint, they are bound through c_hash and at_hash.
+	Code        string
+	AccessToken string
+}
+
+func (s *server) createIDToken(ctx context.Context, req idTokenRequest) (string, error) {
+	sub, err := s.subjectFor(ctx, req.Client, req.UserID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := idTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:   s.issuer,
+			Subject:  sub,
+			Audience: []string{req.Client.ID},
+			Expiry:   jwt.NewNumericDate(now.Add(s.accessTokenTTL(req.Client))),
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		Nonce: req.Nonce,
+		Sid:   req.SessionID,
+	}
+	if !req.AuthTime.IsZero() {
+		claims.AuthTime = req.AuthTime.Unix()
+		claims.Acr = req.Acr
+		claims.Amr = req.Amr
+	}
+	if req.Code != "" {
+		claims.CHash = halfHash(req.Code)
+	}
+	if req.AccessToken != "" {
+		claims.AtHash = halfHash(req.AccessToken)
+	}
+	builder := jwt.Signed(s.signer)
+	if req.Scope != "" || len(req.Claims) > 0 {
+		user, err := s.db.GetUser(ctx, req.UserID)
+		if err != nil {
+			return "", err
+		}
+		released, err := s.userClaims(ctx, user, req.Client, scope.Parse(req.Scope), req.Claims)
+		if err != nil {
+			return "", err
+		}
+		// later claims take precedence
+		builder = builder.Claims(released)
+	}
+	return builder.Claims(claims).Serialize()
+}
+
+// parseIDToken verifies that the token is an ID token issued by this server and
+// returns its claims without checking their time. Access and logout tokens are
+// signed with the same key and told apart by their type.
+func (s *server) parseIDToken(token string) (*idTokenClaims, error) {
+	tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		return nil, err
+	}
+	if tok.Headers[0].ExtraHeaders[jose.HeaderType] != "JWT" {
+		return nil, fmt.Errorf("not an id token")
+	}
+	var claims idTokenClaims
+	if err = tok.Claims(s.secret, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != s.issuer || len(claims.Audience) != 1 {
+		return nil, fmt.Errorf("id token of another issuer or audience")
+	}
+	return &claims, nil
+}
+
+// halfHash computes c_hash and at_hash values: the base64url encoded left half of
+// the hash of the value. Ed25519 signatures use SHA-512 as their hash function.
+func halfHash(value string) string {
+	sum := sha512.Sum512([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// wantsIDToken reports whether the space separated scope asks for an ID token.
+func wantsIDToken(scopes string) bool {
+	return scope.Parse(scopes).Contains(scope.OpenID)
+}
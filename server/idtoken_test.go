@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/scope"
+	"sutext.github.io/suid"
+)
+
+func TestIDTokenNonce(t *testing.T) {
+	s := newTestServer()
+	s.db = &clientStorage{}
+	uid := suid.New()
+	authTime := time.Now().Add(-time.Minute)
+	token, err := s.createIDToken(context.Background(), idTokenRequest{
+		UserID:   uid,
+		Client:   &model.Client{ID: "client"},
+		Nonce:    "n-0S6_WzA2Mj",
+		AuthTime: authTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Headers[0].KeyID != s.keyID {
+		t.Errorf("kid = %q, want %q", tok.Headers[0].KeyID, s.keyID)
+	}
+	var claims idTokenClaims
+	if err := tok.Claims(s.secret, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("nonce = %q", claims.Nonce)
+	}
+	if claims.AuthTime != authTime.Unix() {
+		t.Errorf("auth_time = %d, want %d", claims.AuthTime, authTime.Unix())
+	}
+	hinted, clientID, err := s.parseIDTokenHint(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hinted != uid || clientID != "client" {
+		t.Errorf("id_token_hint = %s %s, want %s client", hinted, clientID, uid)
+	}
+}
+
+func TestCodeFlowNonce(t *testing.T) {
+	s, db, _, userID, cookie := authorizeFlow(t)
+	db.SaveGrant(t.Context(), &model.Grant{UserID: userID, ClientID: "spa", Scopes: scope.Scopes{"openid"}})
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	q := authorize(t, s, cookie, url.Values{
+		"prompt":         {"none"},
+		"nonce":          {"n-0S6_WzA2Mj"},
+		"code_challenge": {base64.RawURLEncoding.EncodeToString(sum[:])},
+	})
+	token := func(form url.Values) string {
+		t.Helper()
+		form.Set("client_id", "spa")
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handleToken(w, r)
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusOK {
+			t.Fatalf("token status = %d: %v", w.Code, resp)
+		}
+		tok, err := jwt.ParseSigned(resp["id_token"].(string), []jose.SignatureAlgorithm{jose.EdDSA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var claims idTokenClaims
+		if err := tok.Claims(s.secret, &claims); err != nil {
+			t.Fatal(err)
+		}
+		if claims.Nonce != "n-0S6_WzA2Mj" {
+			t.Errorf("%s id token nonce = %q", form.Get("grant_type"), claims.Nonce)
+		}
+		refresh, _ := resp["refresh_token"].(string)
+		return refresh
+	}
+	refresh := token(url.Values{"grant_type": {"authorization_code"}, "code": {q.Get("code")},
+		"redirect_uri": {"https://spa.example.com/cb"}, "code_verifier": {verifier}})
+	token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}})
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/entry/xlog"
+	"sutext.github.io/suid"
+)
+
+// tokenHash returns the storage ID of an opaque access token.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// generateOpaqueToken stores the claims of the access token under the hash of
+// a random token value, which is returned to the client.
+func (s *server) generateOpaqueToken(tgr *TokenGenerateRequest, claims accessTokenClaims, custom map[string]any) (string, error) {
+	fields, err := claimsMap(claims)
+	if err != nil {
+		return "", err
+	}
+	merged := make(map[string]any, len(custom)+len(fields))
+	maps.Copy(merged, custom)
+	maps.Copy(merged, fields)
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(value)
+	// client tokens have no user
+	userID, _ := suid.Parse(tgr.UserID)
+	err = s.db.CreateToken(tgr.context(), &model.AccessToken{
+		ID:        tokenHash(token),
+		UserID:    userID,
+		ClientID:  tgr.ClientID,
+		Claims:    string(data),
+		ExpiryIn:  claims.Expiry.Time(),
+		CreatedAt: claims.IssuedAt.Time(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func claimsMap(claims accessTokenClaims) (map[string]any, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// validateAccessToken resolves an access token issued by this server, whether a
+// JWT or an opaque token, and returns its claims including the custom ones.
+func (s *server) validateAccessToken(ctx context.Context, token string) (*accessTokenClaims, map[string]any, error) {
+	var claims accessTokenClaims
+	var fields map[string]any
+	if strings.Count(token, ".") == 2 {
+		tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+		if err != nil {
+			return nil, nil, err
+		}
+		// ID tokens are signed with the same key
+		if tok.Headers[0].ExtraHeaders[jose.HeaderType] != "at+jwt" {
+			return nil, nil, fmt.Errorf("not an access token")
+		}
+		if err = tok.Claims(s.secret, &claims, &fields); err != nil {
+			return nil, nil, err
+		}
+		if err = claims.Validate(jwt.Expected{Issuer: s.issuer, Time: time.Now()}); err != nil {
+			return nil, nil, err
+		}
+		return &claims, fields, nil
+	}
+	at, err := s.db.GetToken(ctx, tokenHash(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if at.ExpiryIn.Before(time.Now()) {
+		return nil, nil, fmt.Errorf("access token expired")
+	}
+	if err = json.Unmarshal([]byte(at.Claims), &claims); err != nil {
+		return nil, nil, err
+	}
+	if err = json.Unmarshal([]byte(at.Claims), &fields); err != nil {
+		return nil, nil, err
+	}
+	return &claims, fields, nil
+}
+
+// handleIntrospect serves token introspection to confidential clients such as
+// resource servers. Tokens the caller may not learn about are inactive.
+// https://www.rfc-editor.org/rfc/rfc7662
+func (s *server) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.tokenError(w, xerr.ErrInvalidRequest)
+		return
+	}
+	// introspection is no grant, any client presenting its secret may use it
+	client, err := s.verifyClient(r, true)
+	if err != nil {
+		s.tokenError(w, err)
+		return
+	}
+	if client.IsPublic() {
+		s.tokenError(w, xerr.ErrInvalidClient)
+		return
+	}
+	token := r.FormValue("token")
+	if token == "" {
+		s.tokenError(w, xerr.ErrInvalidRequest)
+		return
+	}
+	// invalid, expired and unknown tokens are all merely inactive
+	claims, fields, err := s.validateAccessToken(r.Context(), token)
+	if err != nil || !canIntrospect(client, claims) {
+		s.token(w, map[string]any{"active": false}, nil)
+		return
+	}
+	fields["active"] = true
+	fields["token_type"] = "Bearer"
+	if claims.Confirmation != nil {
+		fields["token_type"] = "DPoP"
+	}
+	s.token(w, fields, nil)
+}
+
+// canIntrospect reports whether the client may learn about the token: its own
+// tokens, the tokens targeted at it, or every token for resource servers.
+func canIntrospect(client *model.Client, claims *accessTokenClaims) bool {
+	return client.ResourceServer || claims.ClientID == client.ID || claims.Audience.Contains(client.ID)
+}
+
+// purge removes expired opaque access tokens and timed out sessions until the
+// server shuts down.
+func (s *server) purge() {
+	ticker := time.NewTicker(s.tokenPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.purgeSessions(now)
+			n, err := s.db.PurgeTokens(context.Background(), now)
+			if err != nil {
+				s.logger.Error("failed to purge access tokens", xlog.Err(err))
+				continue
+			}
+			if n > 0 {
+				s.logger.Info("purged expired access tokens", xlog.I64("count", n))
+			}
+		}
+	}
+}
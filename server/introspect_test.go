@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/model"
+)
+
+func TestIntrospectOpaqueToken(t *testing.T) {
+	s := New(
+		WithIssuerURL("http://localhost:8080"),
+		WithAccessTokenFormat(model.AccessTokenOpaque),
+		WithAccessTokenClaimsHandler(func(ctx context.Context, tgr *TokenGenerateRequest) (map[string]any, error) {
+			return map[string]any{"tenant": "acme"}, nil
+		}),
+	).(*server)
+	rs := &model.Client{
+		ID:             "rs",
+		Type:           model.ClientTypeConfidential,
+		Secret:         "secret",
+		TrustedPeers:   model.Strings{"192.0.2.1:1234"},
+		ResourceServer: true,
+	}
+	other := &model.Client{
+		ID:           "other",
+		Type:         model.ClientTypeConfidential,
+		Secret:       "secret",
+		TrustedPeers: model.Strings{"192.0.2.1:1234"},
+	}
+	spa := &model.Client{ID: "spa", Type: model.ClientTypePublic}
+	db := &tokenStorage{
+		clientStorage: clientStorage{clients: map[string]*model.Client{"rs": rs, "other": other, "spa": spa}},
+		tokens:        map[string]*model.AccessToken{},
+	}
+	s.db = db
+	token, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "spa", UserID: "user", Scope: "read", AccessTokenExp: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(token, ".") {
+		t.Fatalf("token %q is not opaque", token)
+	}
+	if _, ok := db.tokens[token]; ok {
+		t.Fatal("the token value must not be stored")
+	}
+	introspect := func(token string, clientID ...string) map[string]any {
+		form := url.Values{"client_id": {"rs"}, "client_secret": {"secret"}, "token": {token}}
+		if len(clientID) > 0 {
+			form.Set("client_id", clientID[0])
+		}
+		r := httptest.NewRequest("POST", "/oauth/token/introspect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handleIntrospect(w, r)
+		var resp map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := introspect(token)
+	if resp["active"] != true || resp["sub"] != "user" || resp["client_id"] != "spa" || resp["scope"] != "read" || resp["tenant"] != "acme" {
+		t.Errorf("unexpected introspection response: %v", resp)
+	}
+	if resp := introspect("unknown"); resp["active"] != false || len(resp) != 1 {
+		t.Errorf("unknown token: %v", resp)
+	}
+	form := url.Values{"client_id": {"rs"}, "client_secret": {"wrong"}, "token": {token}}
+	r := httptest.NewRequest("POST", "/oauth/token/introspect", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.handleIntrospect(w, r)
+	if w.Code != http.StatusBadRequest && w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret status = %d", w.Code)
+	}
+	// clients other than resource servers only learn about their own tokens
+	if resp := introspect(token, "other"); resp["active"] != false || len(resp) != 1 {
+		t.Errorf("token of another client: %v", resp)
+	}
+	db.tokens[tokenHash(token)].ExpiryIn = time.Now().Add(-time.Second)
+	if resp := introspect(token); resp["active"] != false {
+		t.Errorf("expired token: %v", resp)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := newTestServer(WithAccessTokenFormat(model.AccessTokenOpaque))
+	db := &tokenStorage{
+		clientStorage: clientStorage{clients: map[string]*model.Client{
+			"spa":   {ID: "spa", Type: model.ClientTypePublic},
+			"other": {ID: "other", Type: model.ClientTypePublic},
+		}},
+		tokens: map[string]*model.AccessToken{},
+	}
+	s.db = db
+	token, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "spa", UserID: "user", AccessTokenExp: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoke := func(clientID, token string) int {
+		form := url.Values{"client_id": {clientID}, "token": {token}, "token_type_hint": {"access_token"}}
+		r := httptest.NewRequest("POST", "/oauth/revoke", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handleRevoke(w, r)
+		return w.Code
+	}
+	if code := revoke("other", token); code != http.StatusOK || len(db.tokens) != 1 {
+		t.Errorf("token of another client: status = %d, %d tokens", code, len(db.tokens))
+	}
+	if code := revoke("spa", token); code != http.StatusOK || len(db.tokens) != 0 {
+		t.Errorf("own token: status = %d, %d tokens", code, len(db.tokens))
+	}
+	if code := revoke("spa", "unknown"); code != http.StatusOK {
+		t.Errorf("unknown token status = %d", code)
+	}
+	if code := revoke("spa", "a.b.c"); code != http.StatusBadRequest {
+		t.Errorf("JWT status = %d", code)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"sutext.github.io/entry/model"
+)
+
+// jarmTTL is the lifetime of a JWT secured authorization response.
+const jarmTTL = 10 * time.Minute
+
+// responseEncryptionAlgs and responseEncryptionEncs are supported to encrypt
+// authorization and userinfo responses to clients.
+var (
+	responseEncryptionAlgs = []jose.KeyAlgorithm{jose.RSA_OAEP, jose.RSA_OAEP_256, jose.ECDH_ES, jose.ECDH_ES_A128KW, jose.ECDH_ES_A256KW}
+	responseEncryptionEncs = []jose.ContentEncryption{jose.A128CBC_HS256, jose.A256CBC_HS512, jose.A128GCM, jose.A256GCM}
+)
+
+// createResponseJWT signs the authorization response and encrypts it when the
+// client has registered an encryption algorithm.
+// https://openid.net/specs/oauth-v2-jarm.html
+func (s *server) createResponseJWT(client *model.Client, req *AuthorizeRequest, data map[string]any) (string, error) {
+	claims := maps.Clone(data)
+	claims["iss"] = s.issuer
+	claims["aud"] = req.ClientID
+	claims["exp"] = time.Now().Add(jarmTTL).Unix()
+	if req.State != "" {
+		claims["state"] = req.State
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	jws, err := s.signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+	if client.AuthorizationEncryptedResponseAlg == "" {
+		return token, nil
+	}
+	enc, err := responseEncrypter(client, client.AuthorizationEncryptedResponseAlg, client.AuthorizationEncryptedResponseEnc, true)
+	if err != nil {
+		return "", err
+	}
+	jwe, err := enc.Encrypt([]byte(token))
+	if err != nil {
+		return "", err
+	}
+	return jwe.CompactSerialize()
+}
+
+// responseEncrypter builds the encrypter for the registered algorithms using the
+// first encryption key of the client's key set. Nested responses encrypt a signed JWT.
+func responseEncrypter(client *model.Client, keyAlg, contentEnc string, nested bool) (jose.Encrypter, error) {
+	alg := jose.KeyAlgorithm(keyAlg)
+	enc := jose.ContentEncryption(contentEnc)
+	if enc == "" {
+		enc = jose.A128CBC_HS256
+	}
+	if !slices.Contains(responseEncryptionAlgs, alg) || !slices.Contains(responseEncryptionEncs, enc) {
+		return nil, fmt.Errorf("unsupported response encryption %s/%s", alg, enc)
+	}
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal([]byte(client.JWKS), &jwks); err != nil {
+		return nil, fmt.Errorf("invalid client jwks: %w", err)
+	}
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "enc" {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != string(alg) {
+			continue
+		}
+		opts := &jose.EncrypterOptions{}
+		if nested {
+			opts = opts.WithContentType("JWT")
+		}
+		return jose.NewEncrypter(enc, jose.Recipient{Algorithm: alg, Key: key.Key, KeyID: key.KeyID}, opts)
+	}
+	return nil, fmt.Errorf("client %s has no encryption key for %s", client.ID, alg)
+}
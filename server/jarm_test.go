@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+)
+
+type jarmClaims struct {
+	jwt.Claims
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+func TestResponseJWT(t *testing.T) {
+	s := newTestServer()
+	req := &AuthorizeRequest{ClientID: "client", State: "xyz", ResponseMode: ResponseModeQueryJWT}
+	token, err := s.createResponseJWT(&model.Client{ID: "client"}, req, map[string]any{"code": "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims jarmClaims
+	if err := tok.Claims(s.secret, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Code != "abc" || claims.State != "xyz" || claims.Issuer != "http://localhost:8080" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if !claims.Audience.Contains("client") {
+		t.Errorf("aud = %v", claims.Audience)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, Use: "enc"}}})
+	client := &model.Client{
+		ID:                                "client",
+		JWKS:                              string(jwks),
+		AuthorizationEncryptedResponseAlg: string(jose.RSA_OAEP_256),
+	}
+	token, err = s.createResponseJWT(client, req, map[string]any{"code": "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwe, err := jose.ParseEncryptedCompact(token,
+		[]jose.KeyAlgorithm{jose.RSA_OAEP_256},
+		[]jose.ContentEncryption{jose.A128CBC_HS256},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := jwe.Decrypt(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.ParseSigned(string(plain), []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims = jarmClaims{}
+	if err := signed.Claims(s.secret, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Code != "abc" {
+		t.Errorf("code = %q", claims.Code)
+	}
+
+	// without the client it is unknown whether to encrypt
+	s.db = &clientStorage{}
+	if _, _, err := s.responseParams(t.Context(), req, map[string]any{"code": "abc"}); err == nil {
+		t.Error("response of an unknown client delivered")
+	}
+}
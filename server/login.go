@@ -4,12 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/go-jose/go-jose/v4"
-	"github.com/go-jose/go-jose/v4/jwt"
 	"golang.org/x/crypto/bcrypt"
 	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xlog"
 	"sutext.github.io/suid"
 )
 
@@ -17,9 +17,14 @@ type loginRequest struct {
 	Email    string
 	Password string
 }
+
+// loginResponse carries the signed in user, or the token to complete the login
+// with one of the second factors of MFAMethods.
 type loginResponse struct {
-	User  *model.UserView `json:"user"`
-	Token string          `json:"token"`
+	User        *model.UserView `json:"user,omitempty"`
+	MFARequired bool            `json:"mfa_required,omitempty"`
+	MFAToken    string          `json:"mfa_token,omitempty"`
+	MFAMethods  []string        `json:"mfa_methods,omitempty"`
 }
 
 func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -36,21 +41,44 @@ func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	user, err := s.db.GetUserByEmail(ctx, req.Email)
 	if err != nil {
+		user = nil
+	}
+	account := lockoutAccount(user, req.Email)
+	if retry, ok := s.throttle(r, account, ""); !ok {
+		s.audit(r, model.AuditLoginFailed, model.AuditDenied, req.Email, "", "throttled")
+		s.writeThrottled(w, retry)
+		return
+	}
+	if user == nil {
+		s.limiter.fail(account, time.Now())
+		s.audit(r, model.AuditLoginFailed, model.AuditFailure, req.Email, "", "unknown user")
 		s.writeError(w, http.StatusUnauthorized, "invalid email or password")
 		return
 	}
 	if err = bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(req.Password)); err != nil {
+		s.limiter.fail(account, time.Now())
+		s.audit(r, model.AuditLoginFailed, model.AuditFailure, user.ID.String(), "", "invalid password")
 		s.writeError(w, http.StatusUnauthorized, "invalid email or password")
 		return
 	}
-	token, err := s.createUserToken(user.ID)
-	if err != nil {
+	if methods := s.mfaMethods(ctx, user.ID); len(methods) > 0 {
+		// the throttle is reset once the second factor is verified
+		token, err := s.challengeMFA(user.ID, account, []string{AMRPassword})
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.writeJSON(w, http.StatusOK, loginResponse{MFARequired: true, MFAToken: token, MFAMethods: methods})
+		return
+	}
+	s.limiter.succeed(account)
+	s.audit(r, model.AuditLogin, model.AuditSuccess, user.ID.String(), "", "")
+	if _, err := s.startSession(w, r, user.ID, []string{AMRPassword}); err != nil {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	resp := loginResponse{
-		User:  user.ToView(),
-		Token: token,
+		User: user.ToView(),
 	}
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(resp); err != nil {
@@ -89,17 +117,17 @@ func (s *server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 	user.Hash = string(hash)
 	if err = s.db.CreateUser(r.Context(), user); err != nil {
+		s.audit(r, model.AuditRegister, model.AuditFailure, req.Email, "", "create user failed")
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	token, err := s.createUserToken(user.ID)
-	if err != nil {
+	s.audit(r, model.AuditRegister, model.AuditSuccess, user.ID.String(), "", "")
+	if _, err := s.startSession(w, r, user.ID, []string{AMRPassword}); err != nil {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	resp := loginResponse{
-		User:  user.ToView(),
-		Token: token,
+		User: user.ToView(),
 	}
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(resp); err != nil {
@@ -109,17 +137,30 @@ func (s *server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+type logoutResponse struct {
+	FrontchannelLogoutURIs []string `json:"frontchannel_logout_uris"`
+}
+
+// handleLogout ends the browser session. Refresh tokens issued in the session
+// stop working unless they were granted offline access. The front-channel
+// logout URIs of the clients, if any, are returned for the page to load.
 func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-}
-func (s *server) handleProfile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	frontchannel, err := s.logout(w, r, "")
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(frontchannel) == 0 {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	s.writeJSON(w, http.StatusOK, logoutResponse{FrontchannelLogoutURIs: frontchannel})
+}
+func (s *server) handleProfile(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID, err := s.ensureLoggedIn(r)
 	if err != nil {
@@ -131,42 +172,135 @@ func (s *server) handleProfile(w http.ResponseWriter, r *http.Request) {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var view model.UserView
+		if err := json.NewDecoder(r.Body).Decode(&view); err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		user.Update(&view)
+		if err := s.db.UpdateUser(ctx, user); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	case http.MethodDelete:
+		// the stored sessions go with the account, the browser forgets its own
+		sessions, err := s.db.ListUserSessions(ctx, userID)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// logout tokens carry the pairwise subjects deleted with the account
+		s.notifyUserLogout(ctx, sessions, "")
+		if err := s.db.DeleteUser(ctx, userID); err != nil {
+			s.audit(r, model.AuditAccountDeleted, model.AuditFailure, userID.String(), "", "delete user failed")
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.audit(r, model.AuditAccountDeleted, model.AuditSuccess, userID.String(), "", "")
+		if _, err := s.endSession(w, r); err != nil {
+			s.logger.Error("failed to end session of deleted user", xlog.Err(err))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(user.ToView()); err != nil {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	w.WriteHeader(http.StatusOK)
 }
-func (s *server) createUserToken(userID suid.SUID) (string, error) {
-	return jwt.Signed(s.signer).Claims(jwt.Claims{
-		Subject:  userID.String(),
-		Expiry:   jwt.NewNumericDate(time.Now().Add(s.accessTokenDuration)),
-		IssuedAt: jwt.NewNumericDate(time.Now()),
-	}).Serialize()
+
+type passwordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (s *server) handlePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	sess, err := s.currentSession(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var req passwordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.NewPassword == "" {
+		s.writeError(w, http.StatusBadRequest, "new password is empty")
+		return
+	}
+	user, err := s.db.GetUser(ctx, sess.UserID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// a stolen session must not be a way around the login lockout
+	account := accountName(user)
+	if retry, ok := s.throttle(r, account, ""); !ok {
+		s.audit(r, model.AuditPasswordChanged, model.AuditDenied, user.ID.String(), "", "throttled")
+		s.writeThrottled(w, retry)
+		return
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(req.OldPassword)); err != nil {
+		s.limiter.fail(account, time.Now())
+		s.audit(r, model.AuditPasswordChanged, model.AuditFailure, user.ID.String(), "", "invalid password")
+		s.writeError(w, http.StatusUnauthorized, "invalid password")
+		return
+	}
+	s.limiter.succeed(account)
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	user.Hash = string(hash)
+	user.UpdatedAt = time.Now()
+	if err = s.db.UpdateUser(ctx, user); err != nil {
+		s.audit(r, model.AuditPasswordChanged, model.AuditFailure, user.ID.String(), "", "update user failed")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.audit(r, model.AuditPasswordChanged, model.AuditSuccess, user.ID.String(), "", "")
+	// whoever knew the old password is signed out everywhere else, at the
+	// clients too
+	sessions, err := s.db.ListUserSessions(ctx, user.ID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err = s.db.DeleteUserSessions(ctx, user.ID, sess.ID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.notifyUserLogout(ctx, sessions, sess.ID)
+	w.WriteHeader(http.StatusNoContent)
 }
+
 func (s *server) getToken(r *http.Request) (string, error) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
 		return "", fmt.Errorf("missing authorization token")
 	}
-	token = token[len("Bearer "):]
 	return token, nil
 }
 func (s *server) ensureLoggedIn(r *http.Request) (uid suid.SUID, err error) {
-	token, err := s.getToken(r)
-	if err != nil {
-		return uid, err
-	}
-	tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+	sess, err := s.currentSession(r)
 	if err != nil {
 		return uid, err
 	}
-	var claims jwt.Claims
-	if err = tok.Claims(s.secret, &claims); err != nil {
-		return uid, err
-	}
-	return suid.Parse(claims.Subject)
+	return sess.UserID, nil
 }
 func (s *server) writeError(w http.ResponseWriter, code int, msg string) {
 	http.Error(w, msg, code)
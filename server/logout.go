@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+// endSessionParams are the parameters of a logout request carried through the
+// confirmation page.
+var endSessionParams = []string{"id_token_hint", "client_id", "post_logout_redirect_uri", "state", "ui_locales"}
+
+// endSessionRequest is a logout request of a relying party.
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RPLogout
+type endSessionRequest struct {
+	// HintUserID is the user of the id_token_hint, zero without hint.
+	HintUserID            suid.SUID
+	Client                *model.Client
+	PostLogoutRedirectURI string
+	State                 string
+	// UILocales are the preferred languages of the pages. The templates are not
+	// localized, the parameter is accepted without effect.
+	UILocales []string
+}
+
+func (s *server) validateEndSessionRequest(r *http.Request) (*endSessionRequest, error) {
+	ctx := r.Context()
+	req := &endSessionRequest{
+		PostLogoutRedirectURI: r.FormValue("post_logout_redirect_uri"),
+		State:                 r.FormValue("state"),
+		UILocales:             strings.Fields(r.FormValue("ui_locales")),
+	}
+	clientID := r.FormValue("client_id")
+	if hint := r.FormValue("id_token_hint"); hint != "" {
+		uid, aud, err := s.parseIDTokenHint(ctx, hint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id_token_hint")
+		}
+		if clientID != "" && clientID != aud {
+			return nil, fmt.Errorf("client_id does not match the id_token_hint")
+		}
+		req.HintUserID, clientID = uid, aud
+	}
+	if clientID != "" {
+		client, err := s.db.GetClient(ctx, clientID)
+		if err != nil {
+			return nil, fmt.Errorf("unknown client %s", clientID)
+		}
+		req.Client = client
+	}
+	if req.PostLogoutRedirectURI != "" {
+		if req.Client == nil {
+			return nil, fmt.Errorf("post_logout_redirect_uri requires id_token_hint or client_id")
+		}
+		if !req.Client.PostLogoutRedirectURIs.Contains(req.PostLogoutRedirectURI) {
+			return nil, fmt.Errorf("post_logout_redirect_uri is not registered for client %s", req.Client.ID)
+		}
+	}
+	return req, nil
+}
+
+// handleEndSession signs the user out on behalf of a relying party. The user
+// confirms the logout unless the id_token_hint shows the relying party signs
+// out the very user of the session. The page telling the user they signed out
+// loads the front-channel logouts of the clients, then continues to the post
+// logout redirect URI.
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html
+func (s *server) handleEndSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req, err := s.validateEndSessionRequest(r)
+	if err != nil {
+		if err := s.web.RenderError(r, w, http.StatusBadRequest, err.Error()); err != nil {
+			s.logger.Error("failed to render error: " + err.Error())
+		}
+		return
+	}
+	var clientID, clientName string
+	if req.Client != nil {
+		clientID, clientName = req.Client.ID, req.Client.Name
+	}
+	var frontchannel []string
+	if sess, err := s.currentSession(r); err == nil {
+		// the confirmation page posts back to this endpoint, SameSite cookies
+		// keep other sites from posting a confirmed logout
+		confirmed := r.Method == http.MethodPost && r.PostFormValue("confirm") == "yes"
+		if !confirmed && req.HintUserID != sess.UserID {
+			params := make(map[string]string)
+			for _, name := range endSessionParams {
+				if v := r.FormValue(name); v != "" {
+					params[name] = v
+				}
+			}
+			if err := s.web.RenderLogoutConfirm(r, w, clientName, s.absPath(s.endpoints.EndSession), params); err != nil {
+				s.logger.Error("failed to render logout: " + err.Error())
+			}
+			return
+		}
+		if frontchannel, err = s.logout(w, r, clientID); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	// without a session the user is signed out already
+	var redirectURI string
+	if req.PostLogoutRedirectURI != "" {
+		u, err := url.Parse(req.PostLogoutRedirectURI)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.State != "" {
+			q := u.Query()
+			q.Set("state", req.State)
+			u.RawQuery = q.Encode()
+		}
+		redirectURI = u.String()
+	}
+	if redirectURI != "" && len(frontchannel) == 0 {
+		http.Redirect(w, r, redirectURI, http.StatusFound)
+		return
+	}
+	if err := s.web.RenderLoggedOut(r, w, clientName, frontchannel, redirectURI); err != nil {
+		s.logger.Error("failed to render logout: " + err.Error())
+	}
+}
+
+// logout ends the browser session of the request, revoking the refresh tokens
+// issued in it when the server is configured to, and notifies the clients of
+// the session. It returns the front-channel logout URIs the browser has to
+// load. The client is the relying party asking for the logout, if any.
+func (s *server) logout(w http.ResponseWriter, r *http.Request, clientID string) ([]string, error) {
+	session, err := s.endSession(w, r)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if s.revokeRefreshOnLogout {
+		if err := s.db.DeleteSessionRefreshes(r.Context(), session.ID); err != nil {
+			return nil, err
+		}
+	}
+	s.audit(r, model.AuditLogout, model.AuditSuccess, session.UserID.String(), clientID, "")
+	return s.notifyLogout(r.Context(), session), nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+func TestEndSession(t *testing.T) {
+	s := newTestServer()
+	client := &model.Client{
+		ID:                     "app",
+		Name:                   "App",
+		RedirectURIs:           model.Strings{"https://app.example.com/callback"},
+		PostLogoutRedirectURIs: model.Strings{"https://app.example.com/bye"},
+	}
+	db := &sessionStorage{
+		clientStorage: clientStorage{clients: map[string]*model.Client{client.ID: client}},
+		sessions:      map[string]*model.Session{},
+	}
+	s.db = db
+	userID := suid.New()
+	signIn := func() (*authSession, *http.Cookie) {
+		w := httptest.NewRecorder()
+		sess, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), userID, []string{AMRPassword})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess, w.Result().Cookies()[0]
+	}
+	endSession := func(method string, params url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		var r *http.Request
+		if method == "POST" {
+			r = httptest.NewRequest("POST", "/oauth/logout", strings.NewReader(params.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest("GET", "/oauth/logout?"+params.Encode(), nil)
+		}
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.handleEndSession(w, r)
+		return w
+	}
+
+	if w := endSession("GET", url.Values{"client_id": {"app"}, "post_logout_redirect_uri": {"https://evil.example.com"}}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unregistered redirect uri: %d", w.Code)
+	}
+	if w := endSession("GET", url.Values{"post_logout_redirect_uri": {"https://app.example.com/bye"}}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("redirect uri without client: %d", w.Code)
+	}
+
+	// without id_token_hint the user confirms the logout
+	sess, cookie := signIn()
+	params := url.Values{"client_id": {"app"}, "post_logout_redirect_uri": {"https://app.example.com/bye"}, "state": {"xyz"}}
+	w := endSession("GET", params, cookie)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="confirm"`) {
+		t.Fatalf("confirmation page: %d %s", w.Code, w.Body.String())
+	}
+	if !s.sessionAlive(context.Background(), sess.ID) {
+		t.Fatal("session ended before confirmation")
+	}
+	params.Set("confirm", "yes")
+	w = endSession("POST", params, cookie)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://app.example.com/bye?state=xyz" {
+		t.Fatalf("confirmed logout: %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if s.sessionAlive(context.Background(), sess.ID) {
+		t.Error("session alive after logout")
+	}
+
+	// an id_token_hint of the session user logs out right away
+	sess, cookie = signIn()
+	idToken, err := s.createIDToken(context.Background(), idTokenRequest{UserID: userID, Client: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = endSession("GET", url.Values{"id_token_hint": {idToken}}, cookie)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Signed Out") {
+		t.Errorf("hinted logout: %d %s", w.Code, w.Body.String())
+	}
+	if s.sessionAlive(context.Background(), sess.ID) {
+		t.Error("session alive after hinted logout")
+	}
+	if w := endSession("GET", url.Values{"id_token_hint": {idToken}, "client_id": {"other"}}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("client_id not matching the hint: %d", w.Code)
+	}
+}
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid"
+)
+
+// mfaLoginTTL bounds the time between the password and the second factor of a login.
+const mfaLoginTTL = 5 * time.Minute
+
+// recoveryCodeCount is the number of recovery codes handed out at once.
+const recoveryCodeCount = 10
+
+// stepUpMaxAge is how recent a multi-factor login stands in for a code when
+// the user has passkeys only.
+const stepUpMaxAge = 5 * time.Minute
+
+// pendingLogin is a login waiting for the second factor.
+type pendingLogin struct {
+	UserID suid.SUID
+	// Account is the throttled account name, Amr the methods used so far.
+	Account string
+	Amr     []string
+}
+
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Second factors of the mfa_methods of a login response.
+const (
+	mfaMethodTOTP    = "totp"
+	mfaMethodPasskey = "passkey"
+)
+
+// totpEnrolled reports whether the user has a confirmed TOTP authenticator.
+func (s *server) totpEnrolled(ctx context.Context, userID suid.SUID) bool {
+	totp, err := s.db.GetTOTP(ctx, userID)
+	return err == nil && totp.Confirmed
+}
+
+// mfaMethods returns the second factors the user has enrolled. A registered
+// passkey is a second factor of password logins too.
+func (s *server) mfaMethods(ctx context.Context, userID suid.SUID) []string {
+	var methods []string
+	if s.totpEnrolled(ctx, userID) {
+		methods = append(methods, mfaMethodTOTP)
+	}
+	if passkeys, err := s.db.ListPasskeys(ctx, userID); err == nil && len(passkeys) > 0 {
+		methods = append(methods, mfaMethodPasskey)
+	}
+	return methods
+}
+
+// mfaEnrolled reports whether the user has a second factor.
+func (s *server) mfaEnrolled(ctx context.Context, userID suid.SUID) bool {
+	return len(s.mfaMethods(ctx, userID)) > 0
+}
+
+// accountName returns the name of the user shown by authenticators.
+func accountName(user *model.User) string {
+	switch {
+	case user.Email != nil:
+		return *user.Email
+	case user.Username != nil:
+		return *user.Username
+	default:
+		return user.ID.String()
+	}
+}
+
+// lockoutAccount returns the account the login lockout of the user is kept
+// under, so that failures on every endpoint count against one budget however
+// the user is named. Unknown users are throttled by the name as typed,
+// ignoring case.
+func lockoutAccount(user *model.User, typed string) string {
+	if user == nil {
+		return strings.ToLower(strings.TrimSpace(typed))
+	}
+	return accountName(user)
+}
+
+// challengeMFA holds back the login of a user with a second factor and
+// returns the token to complete it with.
+func (s *server) challengeMFA(userID suid.SUID, account string, amr []string) (string, error) {
+	token := rand.Text()
+	return token, s.mfaCache.Set(token, &pendingLogin{UserID: userID, Account: account, Amr: amr}, mfaLoginTTL)
+}
+
+// verifySecondFactor checks a TOTP code or a recovery code of the user. Either
+// is used up by a successful check.
+func (s *server) verifySecondFactor(ctx context.Context, userID suid.SUID, code string) bool {
+	code = strings.TrimSpace(code)
+	totp, err := s.db.GetTOTP(ctx, userID)
+	if err != nil || !totp.Confirmed {
+		return false
+	}
+	// concurrent requests with the same code race for the step, one wins
+	if step, ok := matchTOTP(totp.Secret, code, totp.LastStep, time.Now()); ok {
+		return s.db.UseTOTPStep(ctx, userID, step) == nil
+	}
+	return s.db.UseRecoveryCode(ctx, userID, tokenHash(normalizeRecoveryCode(code))) == nil
+}
+
+// newRecoveryCodes returns fresh recovery codes of the user and the records
+// of their hashes.
+func newRecoveryCodes(userID suid.SUID) ([]string, []*model.RecoveryCode) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]*model.RecoveryCode, recoveryCodeCount)
+	now := time.Now()
+	for i := range codes {
+		raw := strings.ToLower(rand.Text()[:10])
+		codes[i] = raw[:5] + "-" + raw[5:]
+		records[i] = &model.RecoveryCode{ID: tokenHash(raw), UserID: userID, CreatedAt: now}
+	}
+	return codes, records
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces of a typed recovery code.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// handleLoginMFA completes a login held back by challengeMFA with a TOTP or
+// recovery code.
+func (s *server) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	var req mfaLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pending, err := s.mfaCache.Get(req.MFAToken)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, "login expired, sign in again")
+		return
+	}
+	if retry, ok := s.throttle(r, pending.Account, ""); !ok {
+		s.audit(r, model.AuditLoginFailed, model.AuditDenied, pending.Account, "", "throttled")
+		s.writeThrottled(w, retry)
+		return
+	}
+	if !s.verifySecondFactor(ctx, pending.UserID, req.Code) {
+		s.limiter.fail(pending.Account, time.Now())
+		s.audit(r, model.AuditLoginFailed, model.AuditFailure, pending.UserID.String(), "", "invalid second factor")
+		s.writeError(w, http.StatusUnauthorized, "invalid code")
+		return
+	}
+	s.mfaCache.Delete(req.MFAToken)
+	s.limiter.succeed(pending.Account)
+	user, err := s.db.GetUser(ctx, pending.UserID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.audit(r, model.AuditLogin, model.AuditSuccess, user.ID.String(), "", AMROTP)
+	if _, err := s.startSession(w, r, user.ID, withMethod(pending.Amr, AMROTP)); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, loginResponse{User: user.ToView()})
+}
+
+// handleTOTPEnroll starts the enrollment of a TOTP authenticator. The secret
+// replaces an unconfirmed one; a confirmed authenticator has to be disabled first.
+func (s *server) handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	userID, err := s.ensureLoggedIn(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if s.totpEnrolled(ctx, userID) {
+		s.writeError(w, http.StatusConflict, "totp is enrolled already")
+		return
+	}
+	user, err := s.db.GetUser(ctx, userID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	totp := &model.TOTP{UserID: userID, Secret: newTOTPSecret(), CreatedAt: time.Now()}
+	if err := s.db.SaveTOTP(ctx, totp); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, totpEnrollment{
+		Secret: totp.Secret,
+		URI:    totpURI(s.issuerURL.Host, accountName(user), totp.Secret),
+	})
+}
+
+// handleTOTPConfirm confirms the enrollment with a code of the authenticator
+// and hands out the recovery codes. The session counts as multi-factor since.
+func (s *server) handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	sess, err := s.currentSession(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	totp, err := s.db.GetTOTP(ctx, sess.UserID)
+	if err != nil || totp.Confirmed {
+		s.writeError(w, http.StatusConflict, "no totp enrollment to confirm")
+		return
+	}
+	step, ok := matchTOTP(totp.Secret, strings.TrimSpace(req.Code), totp.LastStep, time.Now())
+	if !ok {
+		s.writeError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+	totp.Confirmed, totp.LastStep = true, step
+	codes, records := newRecoveryCodes(sess.UserID)
+	if err := s.db.SaveRecoveryCodes(ctx, sess.UserID, records); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.db.SaveTOTP(ctx, totp); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if session, err := s.db.GetSession(ctx, sess.ID); err == nil {
+		session.Amr = withMethod(session.Amr, AMROTP)
+		if err := s.db.UpdateSession(ctx, session); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	s.audit(r, model.AuditMFAEnrolled, model.AuditSuccess, sess.UserID.String(), "", "totp")
+	s.writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handleRecoveryCodes replaces the recovery codes of the user, who proves the
+// second factor with a current code.
+func (s *server) handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	userID, ok := s.ensureSecondFactor(w, r)
+	if !ok {
+		return
+	}
+	codes, records := newRecoveryCodes(userID)
+	if err := s.db.SaveRecoveryCodes(ctx, userID, records); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handleDisableMFA removes the TOTP authenticator and recovery codes of the
+// user, who proves the second factor with a current code. Passkeys are
+// removed one by one.
+func (s *server) handleDisableMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, ok := s.ensureSecondFactor(w, r)
+	if !ok {
+		return
+	}
+	if err := s.resetTOTP(r.Context(), userID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.audit(r, model.AuditMFADisabled, model.AuditSuccess, userID.String(), "", "totp")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ensureSecondFactor checks the session of the request and the code in the
+// body, answering the request when either fails.
+func (s *server) ensureSecondFactor(w http.ResponseWriter, r *http.Request) (suid.SUID, bool) {
+	userID, err := s.ensureLoggedIn(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return userID, false
+	}
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return userID, false
+	}
+	return userID, s.checkSecondFactor(w, r, userID, req.Code)
+}
+
+// checkSecondFactor checks a TOTP or recovery code of the signed in user,
+// answering the request when it fails.
+func (s *server) checkSecondFactor(w http.ResponseWriter, r *http.Request, userID suid.SUID, code string) bool {
+	user, err := s.db.GetUser(r.Context(), userID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	// guessing codes in a signed in session counts against the login lockout
+	account := accountName(user)
+	if retry, ok := s.throttle(r, account, ""); !ok {
+		s.writeThrottled(w, retry)
+		return false
+	}
+	if !s.verifySecondFactor(r.Context(), userID, code) {
+		s.limiter.fail(account, time.Now())
+		s.writeError(w, http.StatusForbidden, xerr.Descriptions[xerr.ErrMFARequired])
+		return false
+	}
+	s.limiter.succeed(account)
+	return true
+}
+
+// ensurePasskeyStepUp checks the second factor before the passkeys of the user
+// change. Users with a TOTP authenticator give a code, users with passkeys
+// only have to have signed in with multiple factors lately. The first passkey
+// of a user without a second factor needs a recent sign-in, so a stolen
+// session cookie cannot add a factor of its own.
+func (s *server) ensurePasskeyStepUp(w http.ResponseWriter, r *http.Request, sess *authSession, code string) bool {
+	ctx := r.Context()
+	recent := time.Since(sess.AuthTime) <= stepUpMaxAge
+	switch {
+	case s.totpEnrolled(ctx, sess.UserID):
+		return s.checkSecondFactor(w, r, sess.UserID, code)
+	case !s.mfaEnrolled(ctx, sess.UserID):
+		if !recent {
+			s.writeError(w, http.StatusForbidden, xerr.Descriptions[xerr.ErrLoginRequired])
+			return false
+		}
+		return true
+	case sess.Acr == ACRMultiFactor && recent:
+		return true
+	default:
+		s.writeError(w, http.StatusForbidden, xerr.Descriptions[xerr.ErrMFARequired])
+		return false
+	}
+}
+
+// resetTOTP removes the TOTP authenticator and recovery codes of the user.
+func (s *server) resetTOTP(ctx context.Context, userID suid.SUID) error {
+	if err := s.db.DeleteTOTP(ctx, userID); err != nil {
+		return err
+	}
+	return s.db.SaveRecoveryCodes(ctx, userID, nil)
+}
+
+// resetMFA removes every second factor of the user, passkeys included.
+func (s *server) resetMFA(ctx context.Context, userID suid.SUID) error {
+	if err := s.resetTOTP(ctx, userID); err != nil {
+		return err
+	}
+	return s.db.DeletePasskeys(ctx, userID)
+}
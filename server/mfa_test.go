@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid"
+)
+
+func TestTOTP(t *testing.T) {
+	// https://www.rfc-editor.org/rfc/rfc6238#appendix-B, truncated to six digits
+	key := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		if code := totpCode(key, totpStep(time.Unix(unix, 0))); code != want {
+			t.Errorf("code at %d = %s, want %s", unix, code, want)
+		}
+	}
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+	step, ok := matchTOTP(secret, "081804", 0, now.Add(totpPeriod*time.Second))
+	if !ok || step != totpStep(now) {
+		t.Errorf("previous step not accepted: %d, %v", step, ok)
+	}
+	if _, ok := matchTOTP(secret, "081804", step, now); ok {
+		t.Error("used code accepted again")
+	}
+	uri := totpURI("example.com", "alice@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/example.com:alice@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("uri = %s", uri)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	s := newTestServer()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "alice@example.com"
+	user := &model.User{ID: suid.New(), Email: &email, Hash: string(hash)}
+	secret := newTOTPSecret()
+	codes, records := newRecoveryCodes(user.ID)
+	db := &mfaStorage{
+		sessionStorage: sessionStorage{sessions: map[string]*model.Session{}},
+		user:           user,
+		totp:           &model.TOTP{UserID: user.ID, Secret: secret, Confirmed: true},
+		codes:          map[string]bool{},
+	}
+	for _, rc := range records {
+		db.codes[rc.ID] = true
+	}
+	s.db = db
+
+	login := func() string {
+		w := httptest.NewRecorder()
+		s.handleLogin(w, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"alice@example.com","password":"secret"}`)))
+		var resp loginResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.MFARequired || resp.MFAToken == "" || resp.User != nil || len(w.Result().Cookies()) != 0 {
+			t.Fatalf("login without second factor: %+v", resp)
+		}
+		return resp.MFAToken
+	}
+	verify := func(token, code string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(mfaLoginRequest{MFAToken: token, Code: code})
+		w := httptest.NewRecorder()
+		s.handleLoginMFA(w, httptest.NewRequest("POST", "/login/mfa", strings.NewReader(string(body))))
+		return w
+	}
+
+	token := login()
+	if w := verify(token, "000000"); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid code status = %d", w.Code)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	code := totpCode(key, totpStep(time.Now()))
+	w := verify(token, code)
+	if w.Code != http.StatusOK {
+		t.Fatalf("valid code status = %d: %s", w.Code, w.Body)
+	}
+	r := httptest.NewRequest("GET", "/profile", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	sess, err := s.currentSession(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Acr != ACRMultiFactor {
+		t.Errorf("session acr = %q, amr = %v", sess.Acr, sess.Amr)
+	}
+	if w := verify(token, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("used login token status = %d", w.Code)
+	}
+	if w := verify(login(), code); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code status = %d", w.Code)
+	}
+
+	recovery := strings.ToUpper(codes[0])
+	if w := verify(login(), recovery); w.Code != http.StatusOK {
+		t.Errorf("recovery code status = %d", w.Code)
+	}
+	if w := verify(login(), recovery); w.Code != http.StatusUnauthorized {
+		t.Errorf("used recovery code status = %d", w.Code)
+	}
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	s := newTestServer()
+	email := "alice@example.com"
+	user := &model.User{ID: suid.New(), Email: &email}
+	secret := newTOTPSecret()
+	s.db = &mfaStorage{
+		sessionStorage: sessionStorage{sessions: map[string]*model.Session{}},
+		user:           user,
+		totp:           &model.TOTP{UserID: user.ID, Secret: secret, Confirmed: true},
+	}
+	w := httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), user.ID, []string{AMRPassword}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	regenerate := func(code string) int {
+		r := httptest.NewRequest("POST", "/profile/mfa/recovery-codes", strings.NewReader(`{"code":"`+code+`"}`))
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		s.handleRecoveryCodes(w, r)
+		return w.Code
+	}
+	for i := range 5 {
+		if code := regenerate("000000"); code != http.StatusForbidden {
+			t.Fatalf("invalid code %d status = %d", i, code)
+		}
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	if code := regenerate(totpCode(key, totpStep(time.Now()))); code != http.StatusTooManyRequests {
+		t.Errorf("code after lockout status = %d", code)
+	}
+}
+
+func TestPasswordGrantMFA(t *testing.T) {
+	s := newTestServer()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username := "alice"
+	user := &model.User{ID: suid.New(), Username: &username, Hash: string(hash)}
+	client := &model.Client{ID: "cli", Type: model.ClientTypeConfidential, Secret: "cs", Scopes: model.Strings{"openid"},
+		GrantTypes: model.Strings{string(PasswordCredentials)}, TrustedPeers: model.Strings{"192.0.2.1:1234"}}
+	db := &mfaStorage{user: user}
+	db.clients = map[string]*model.Client{"cli": client}
+	s.db = db
+	grant := func(form string) error {
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(username, "secret")
+		_, err := s.validatePasswordCredentialsGrant(r)
+		return err
+	}
+
+	// passkeys cannot be presented, the grant is refused
+	db.passkeys = map[string]*model.Passkey{"key": {ID: "key", UserID: user.ID}}
+	if err := grant("client_id=cli&client_secret=cs&otp=123456"); err != xerr.ErrInvalidGrant {
+		t.Errorf("passkey only: err = %v", err)
+	}
+	db.totp = &model.TOTP{UserID: user.ID, Secret: newTOTPSecret(), Confirmed: true}
+	if err := grant("client_id=cli&client_secret=cs"); err != xerr.ErrMFARequired {
+		t.Errorf("totp without code: err = %v", err)
+	}
+}
+
+func TestSharedLockout(t *testing.T) {
+	s := newTestServer(WithSupportedGrantTypes([]string{string(PasswordCredentials)}))
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, username := "alice@example.com", "alice"
+	user := &model.User{ID: suid.New(), Email: &email, Username: &username, Hash: string(hash)}
+	client := &model.Client{ID: "cli", Type: model.ClientTypeConfidential, Secret: "cs", Scopes: model.Strings{"openid"},
+		GrantTypes: model.Strings{string(PasswordCredentials)}, TrustedPeers: model.Strings{"192.0.2.1:1234"}}
+	db := &mfaStorage{sessionStorage: sessionStorage{sessions: map[string]*model.Session{}}, user: user}
+	db.clients = map[string]*model.Client{"cli": client}
+	s.db = db
+
+	// failures of the password grant under the username lock the login by email
+	for i := range 5 {
+		r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader("grant_type=password&client_id=cli&client_secret=cs"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(username, "wrong")
+		w := httptest.NewRecorder()
+		s.handleToken(w, r)
+		if w.Code == http.StatusOK || w.Code == http.StatusTooManyRequests {
+			t.Fatalf("failed grant %d status = %d", i, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.handleLogin(w, httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"alice@example.com","password":"secret"}`)))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("login after locked grant status = %d", w.Code)
+	}
+}
@@ -6,13 +6,21 @@ import (
 	"net/netip"
 	"time"
 
+	"sutext.github.io/entry/audit"
+	"sutext.github.io/entry/cache"
 	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/webhook"
 	"sutext.github.io/entry/xlog"
 )
 
 type RefreshTokenResolveHandler func(r *http.Request) (string, error)
 type PasswordAuthorizationHandler func(ctx context.Context, clientID, username, password string) (userID string, err error)
 
+// AccessTokenClaimsHandler returns custom claims, e.g. roles, tenant or groups,
+// added to the access token described by the request. Claims set by the server
+// such as iss, sub, aud or scope cannot be replaced.
+type AccessTokenClaimsHandler func(ctx context.Context, tgr *TokenGenerateRequest) (map[string]any, error)
+
 type options struct {
 	addr                          string
 	secret                        string
@@ -29,17 +37,46 @@ type options struct {
 	supportedGrantTypes           map[string]struct{}
 	supportedResponseTypes        map[string]struct{}
 	supportedCodeChallengeMethods map[string]struct{}
+	rateLimits                    map[RateLimitScope]RateLimit
+	rateLimitCache                cache.Cache[RateLimitState]
+	lockoutPolicy                 LockoutPolicy
+	auditSinks                    []audit.Sink
+	auditStorage                  bool
+	adminToken                    string
+	webhookOptions                webhook.Options
+	trustedIssuers                []TrustedIssuer
+	authorizationDetailsTypes     map[string]string
+	accessTokenClaimsHandler      AccessTokenClaimsHandler
+	accessTokenFormat             model.AccessTokenFormat
+	tokenPurgeInterval            time.Duration
+	sessionIdleTimeout            time.Duration
+	sessionAbsoluteTimeout        time.Duration
+	revokeRefreshOnLogout         bool
+	backchannelOptions            BackchannelOptions
+	pairwiseSalt                  string
+	claimSources                  []ClaimSource
+	webauthnOrigins               []string
 }
 
 func newOptions(opts ...Option) *options {
 	os := &options{
-		addr:                 ":8080",
-		secret:               "R7xWhPNWejiOzxHPuiD2SRsvOwF81xWXcbxUJtXlG7A=",
-		logger:               xlog.NewText(xlog.LevelInfo),
-		accessTokenDuration:  time.Hour * 2,
-		refreshTokenDuration: time.Hour * 24 * 7,
+		addr:                   ":8080",
+		secret:                 "R7xWhPNWejiOzxHPuiD2SRsvOwF81xWXcbxUJtXlG7A=",
+		logger:                 xlog.NewText(xlog.LevelInfo),
+		accessTokenDuration:    time.Hour * 2,
+		refreshTokenDuration:   time.Hour * 24 * 7,
+		accessTokenFormat:      model.AccessTokenJWT,
+		tokenPurgeInterval:     time.Hour,
+		sessionIdleTimeout:     time.Minute * 30,
+		sessionAbsoluteTimeout: time.Hour * 12,
 		supportedResponseTypes: map[string]struct{}{
-			ResponseTypeCode.String(): {},
+			ResponseTypeCode.String():             {},
+			ResponseTypeToken.String():            {},
+			ResponseTypeIDToken.String():          {},
+			ResponseTypeIDTokenToken.String():     {},
+			ResponseTypeCodeIDToken.String():      {},
+			ResponseTypeCodeToken.String():        {},
+			ResponseTypeCodeIDTokenToken.String(): {},
 		},
 		supportedCodeChallengeMethods: map[string]struct{}{
 			CodeChallengePlain.String(): {},
@@ -50,6 +87,18 @@ func newOptions(opts ...Option) *options {
 			PasswordCredentials.String(): {},
 			ClientCredentials.String():   {},
 			Refreshing.String():          {},
+			TokenExchange.String():       {},
+			JWTBearer.String():           {},
+		},
+		rateLimits: map[RateLimitScope]RateLimit{
+			RateLimitPerIP:      {Strategy: RateLimitTokenBucket, Limit: 30, Window: time.Minute},
+			RateLimitTokenPerIP: {Strategy: RateLimitTokenBucket, Limit: 600, Window: time.Minute},
+			RateLimitPerAccount: {Strategy: RateLimitSlidingWindow, Limit: 10, Window: time.Minute},
+		},
+		lockoutPolicy: LockoutPolicy{
+			MaxFailures: 5,
+			BaseDelay:   time.Minute,
+			MaxDelay:    time.Hour,
 		},
 	}
 	for _, o := range opts {
@@ -118,7 +167,7 @@ func WithSupportedResponseTypes(responseTypes []string) Option {
 	return option(func(o *options) {
 		o.supportedResponseTypes = make(map[string]struct{}, len(responseTypes))
 		for _, rt := range responseTypes {
-			o.supportedResponseTypes[rt] = struct{}{}
+			o.supportedResponseTypes[ParseResponseType(rt).String()] = struct{}{}
 		}
 	})
 }
@@ -130,3 +179,146 @@ func WithSupportedCodeChallengeMethods(methods []CodeChallengeMethod) Option {
 		}
 	})
 }
+
+// WithRateLimit sets the limit applied to the login and token endpoints for the given scope.
+// The token endpoint uses RateLimitTokenPerIP instead of RateLimitPerIP.
+// A zero Limit disables rate limiting for that scope.
+func WithRateLimit(scope RateLimitScope, limit RateLimit) Option {
+	return option(func(o *options) {
+		if o.rateLimits == nil {
+			o.rateLimits = make(map[RateLimitScope]RateLimit)
+		}
+		o.rateLimits[scope] = limit
+	})
+}
+
+// WithRateLimitCache sets the cache holding limiter and lockout state.
+// Use a shared cache to enforce limits across replicas.
+func WithRateLimitCache(c cache.Cache[RateLimitState]) Option {
+	return option(func(o *options) {
+		o.rateLimitCache = c
+	})
+}
+func WithLockoutPolicy(policy LockoutPolicy) Option {
+	return option(func(o *options) {
+		o.lockoutPolicy = policy
+	})
+}
+
+// WithAuditSink adds sinks receiving security audit events.
+// When no sink is configured events are written to the server logger.
+func WithAuditSink(sinks ...audit.Sink) Option {
+	return option(func(o *options) {
+		o.auditSinks = append(o.auditSinks, sinks...)
+	})
+}
+
+// WithAuditStorage also persists audit events through the server storage.
+func WithAuditStorage() Option {
+	return option(func(o *options) {
+		o.auditStorage = true
+	})
+}
+
+// WithAdminToken enables the admin API for requests bearing the token.
+func WithAdminToken(token string) Option {
+	return option(func(o *options) {
+		o.adminToken = token
+	})
+}
+func WithWebhookOptions(opts webhook.Options) Option {
+	return option(func(o *options) {
+		o.webhookOptions = opts
+	})
+}
+
+// WithTrustedIssuers registers the issuers whose JWTs are accepted by the JWT bearer grant.
+func WithTrustedIssuers(issuers ...TrustedIssuer) Option {
+	return option(func(o *options) {
+		o.trustedIssuers = append(o.trustedIssuers, issuers...)
+	})
+}
+
+// WithAuthorizationDetailsType registers an authorization details type of Rich
+// Authorization Requests with the JSON schema its objects must satisfy.
+func WithAuthorizationDetailsType(typ, schema string) Option {
+	return option(func(o *options) {
+		if o.authorizationDetailsTypes == nil {
+			o.authorizationDetailsTypes = make(map[string]string)
+		}
+		o.authorizationDetailsTypes[typ] = schema
+	})
+}
+
+// WithAccessTokenClaimsHandler sets the handler adding custom claims to access tokens.
+func WithAccessTokenClaimsHandler(handler AccessTokenClaimsHandler) Option {
+	return option(func(o *options) {
+		o.accessTokenClaimsHandler = handler
+	})
+}
+
+// WithAccessTokenFormat sets the format of the access tokens issued to clients
+// which do not choose one themselves. Opaque access tokens are stored and can
+// only be validated through the introspection and userinfo endpoints.
+func WithAccessTokenFormat(format model.AccessTokenFormat) Option {
+	return option(func(o *options) {
+		o.accessTokenFormat = format
+	})
+}
+
+// WithTokenPurgeInterval sets how often expired opaque access tokens and timed
+// out sessions are purged.
+func WithTokenPurgeInterval(interval time.Duration) Option {
+	return option(func(o *options) {
+		o.tokenPurgeInterval = interval
+	})
+}
+
+// WithSessionTimeouts sets how long a browser session lasts without activity
+// and at most since the user signed in.
+func WithSessionTimeouts(idle, absolute time.Duration) Option {
+	return option(func(o *options) {
+		o.sessionIdleTimeout = idle
+		o.sessionAbsoluteTimeout = absolute
+	})
+}
+
+// WithRevokeRefreshOnLogout revokes the refresh tokens issued in a browser
+// session when the user logs out, including those granted offline access.
+func WithRevokeRefreshOnLogout(revoke bool) Option {
+	return option(func(o *options) {
+		o.revokeRefreshOnLogout = revoke
+	})
+}
+
+// WithWebAuthnOrigins sets the origins of the pages allowed to register and
+// use passkeys, the origin of the issuer URL by default. Passkeys are scoped
+// to the host of the issuer URL, the origins have to be on it or its subdomains.
+func WithWebAuthnOrigins(origins ...string) Option {
+	return option(func(o *options) {
+		o.webauthnOrigins = origins
+	})
+}
+
+// WithBackchannelOptions tunes the delivery of back-channel logout tokens.
+func WithBackchannelOptions(opts BackchannelOptions) Option {
+	return option(func(o *options) {
+		o.backchannelOptions = opts
+	})
+}
+
+// WithPairwiseSalt sets the salt of pairwise subject identifiers. It defaults to
+// a value derived from the secret; changing it changes every pairwise subject.
+func WithPairwiseSalt(salt string) Option {
+	return option(func(o *options) {
+		o.pairwiseSalt = salt
+	})
+}
+
+// WithClaimSource registers a source of claims about users in addition to the
+// standard claims stored with them.
+func WithClaimSource(src ClaimSource) Option {
+	return option(func(o *options) {
+		o.claimSources = append(o.claimSources, src)
+	})
+}
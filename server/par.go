@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid/guid"
+)
+
+const (
+	// requestURIPrefix identifies the request URIs issued by the pushed
+	// authorization request endpoint.
+	requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+	// pushedRequestLifetime is how long a request URI can be used.
+	pushedRequestLifetime = time.Minute
+)
+
+// handlePAR validates an authorization request posted by an authenticated
+// client and returns a one-time request URI referring to it.
+// https://www.rfc-editor.org/rfc/rfc9126
+func (s *server) handlePAR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if retry, ok := s.throttleToken(r, "", r.FormValue("client_id")); !ok {
+		s.tokenThrottled(w, retry)
+		return
+	}
+	client, err := s.authenticateClient(r, AuthorizationCode)
+	if err != nil {
+		s.tokenError(w, err)
+		return
+	}
+	// a pushed request cannot refer to another one
+	if r.FormValue("request_uri") != "" {
+		s.tokenError(w, xerr.ErrInvalidRequest)
+		return
+	}
+	req, err := s.validateAuthorizeRequest(r)
+	if err != nil {
+		s.tokenError(w, err)
+		return
+	}
+	if err := s.validateClientSettings(client, req, r); err != nil {
+		if err == xerr.ErrInvalidRedirectURI {
+			err = xerr.ErrInvalidRequest
+		}
+		s.tokenError(w, err)
+		return
+	}
+	if err := s.checkResources(r.Context(), req.Resources, req.Scope); err != nil {
+		s.tokenError(w, err)
+		return
+	}
+	if req.AuthorizationDetails, err = s.parseAuthorizationDetails(client, r.FormValue("authorization_details")); err != nil {
+		s.tokenError(w, err)
+		return
+	}
+	uri := requestURIPrefix + guid.New().String()
+	s.parCache.Set(uri, req, pushedRequestLifetime)
+	s.token(w, map[string]any{
+		"request_uri": uri,
+		"expires_in":  int(pushedRequestLifetime / time.Second),
+	}, nil, http.StatusCreated)
+}
+
+// pushedRequest returns the authorization request pushed by the client under
+// the request URI. Each request URI can be used once.
+func (s *server) pushedRequest(r *http.Request) (*AuthorizeRequest, error) {
+	uri := r.FormValue("request_uri")
+	req, err := s.parCache.Get(uri)
+	if err != nil {
+		return nil, xerr.ErrInvalidRequest
+	}
+	s.parCache.Delete(uri)
+	if req.ClientID != r.FormValue("client_id") {
+		return nil, xerr.ErrInvalidRequest
+	}
+	return req, nil
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/webauthn"
+	"sutext.github.io/entry/xlog"
+	"sutext.github.io/suid"
+)
+
+// webauthnTimeout bounds the time to answer the options of a ceremony.
+const webauthnTimeout = 5 * time.Minute
+
+// webauthnCeremony is a registration or login waiting for the response of
+// the authenticator. Ceremonies are cached by their challenge, which comes
+// back in the client data of the response.
+type webauthnCeremony struct {
+	Registration bool
+	// UserID is the registering user or the user of the pending login,
+	// zero for passwordless logins which learn the user from the passkey.
+	UserID suid.SUID
+	// MFAToken is the pending login a passkey completes as second factor.
+	MFAToken string
+}
+
+type passkeyRegistration struct {
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+	// Code is the second factor of users with a TOTP authenticator.
+	Code string `json:"code,omitempty"`
+}
+
+type passkeyLoginOptionsRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+type passkeyLoginRequest struct {
+	MFAToken   string                     `json:"mfa_token"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+func credentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// passkeyMethod returns the method reference of a login with the passkey:
+// backed up passkeys are synced between devices and count as software keys.
+func passkeyMethod(passkey *model.Passkey) string {
+	if passkey.BackedUp {
+		return AMRSoftwareKey
+	}
+	return AMRHardwareKey
+}
+
+// startCeremony caches the ceremony under a new challenge.
+func (s *server) startCeremony(c *webauthnCeremony) (webauthn.Bytes, error) {
+	challenge := webauthn.NewChallenge()
+	return challenge, s.webauthnCache.Set(base64.RawURLEncoding.EncodeToString(challenge), c, webauthnTimeout)
+}
+
+// finishCeremony returns the ceremony the client data answers with its
+// challenge. A challenge is answered once.
+func (s *server) finishCeremony(clientDataJSON []byte, registration bool) (*webauthnCeremony, []byte, error) {
+	cd, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := base64.RawURLEncoding.EncodeToString(cd.Challenge)
+	c, err := s.webauthnCache.Get(key)
+	if err != nil || c.Registration != registration {
+		return nil, nil, errors.New("unknown or expired challenge")
+	}
+	s.webauthnCache.Delete(key)
+	return c, cd.Challenge, nil
+}
+
+// handlePasskeys lists the passkeys of the user, or registers a new one with
+// the response to the options of handlePasskeyOptions.
+func (s *server) handlePasskeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sess, err := s.currentSession(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	userID := sess.UserID
+	switch r.Method {
+	case http.MethodGet:
+		passkeys, err := s.db.ListPasskeys(ctx, userID)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.writeJSON(w, http.StatusOK, passkeys)
+	case http.MethodPost:
+		var req passkeyRegistration
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.ensurePasskeyStepUp(w, r, sess, req.Code) {
+			return
+		}
+		resp := req.Credential.Response
+		c, challenge, err := s.finishCeremony(resp.ClientDataJSON, true)
+		if err != nil || c.UserID != userID {
+			s.writeError(w, http.StatusBadRequest, "unknown or expired challenge")
+			return
+		}
+		cred, err := s.relyingParty.VerifyRegistration(challenge, resp.ClientDataJSON, resp.AttestationObject, false)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid passkey: "+err.Error())
+			return
+		}
+		id := credentialID(cred.ID)
+		if _, err := s.db.GetPasskey(ctx, id); err == nil {
+			s.writeError(w, http.StatusConflict, "passkey is registered already")
+			return
+		}
+		if req.Name == "" {
+			req.Name = "Passkey"
+		}
+		passkey := &model.Passkey{
+			ID:          id,
+			UserID:      userID,
+			Name:        req.Name,
+			PublicKey:   cred.PublicKey,
+			SignCount:   cred.SignCount,
+			AAGUID:      hex.EncodeToString(cred.AAGUID),
+			Attestation: cred.Attestation,
+			Transports:  resp.Transports,
+			BackedUp:    cred.BackedUp,
+			CreatedAt:   time.Now(),
+		}
+		if err := s.db.CreatePasskey(ctx, passkey); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.audit(r, model.AuditPasskeyAdded, model.AuditSuccess, userID.String(), "", passkey.Name)
+		s.writeJSON(w, http.StatusCreated, passkey)
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handlePasskeyOptions starts the registration of a passkey of the user.
+func (s *server) handlePasskeyOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	userID, err := s.ensureLoggedIn(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	user, err := s.db.GetUser(ctx, userID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	passkeys, err := s.db.ListPasskeys(ctx, userID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	exclude := make([][]byte, 0, len(passkeys))
+	for _, p := range passkeys {
+		if id, err := base64.RawURLEncoding.DecodeString(p.ID); err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+	challenge, err := s.startCeremony(&webauthnCeremony{Registration: true, UserID: userID})
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	name := accountName(user)
+	entity := webauthn.UserEntity{ID: []byte(userID.String()), Name: name, DisplayName: name}
+	s.writeJSON(w, http.StatusOK, s.relyingParty.CreationOptions(challenge, entity, exclude, webauthnTimeout))
+}
+
+// handlePasskey removes a passkey of the user, who proves the second factor
+// like for a new one.
+func (s *server) handlePasskey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	sess, err := s.currentSession(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.ensurePasskeyStepUp(w, r, sess, req.Code) {
+		return
+	}
+	userID := sess.UserID
+	id := r.PathValue("id")
+	if err := s.db.DeletePasskey(r.Context(), userID, id); err != nil {
+		s.writeError(w, http.StatusNotFound, "passkey not found")
+		return
+	}
+	s.audit(r, model.AuditPasskeyRemoved, model.AuditSuccess, userID.String(), "", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePasskeyLoginOptions starts a login with a passkey. With the token of
+// a pending login the passkey is the second factor after the password and
+// one of the passkeys of the user is asked for. Otherwise it is the only
+// factor, any discoverable passkey verifying the user will do.
+func (s *server) handlePasskeyLoginOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	var req passkeyLoginOptionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := &webauthnCeremony{MFAToken: req.MFAToken}
+	verification := webauthn.Required
+	var allow [][]byte
+	if req.MFAToken != "" {
+		pending, err := s.mfaCache.Get(req.MFAToken)
+		if err != nil {
+			s.writeError(w, http.StatusUnauthorized, "login expired, sign in again")
+			return
+		}
+		passkeys, err := s.db.ListPasskeys(ctx, pending.UserID)
+		if err != nil || len(passkeys) == 0 {
+			s.writeError(w, http.StatusBadRequest, "no passkey registered")
+			return
+		}
+		for _, p := range passkeys {
+			if id, err := base64.RawURLEncoding.DecodeString(p.ID); err == nil {
+				allow = append(allow, id)
+			}
+		}
+		c.UserID, verification = pending.UserID, webauthn.Preferred
+	}
+	challenge, err := s.startCeremony(c)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.relyingParty.RequestOptions(challenge, allow, verification, webauthnTimeout))
+}
+
+// handlePasskeyLogin completes a login with the response to the options of
+// handlePasskeyLoginOptions.
+func (s *server) handlePasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx := r.Context()
+	var req passkeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp := req.Credential.Response
+	c, challenge, err := s.finishCeremony(resp.ClientDataJSON, false)
+	if err != nil || c.MFAToken != req.MFAToken {
+		s.writeError(w, http.StatusBadRequest, "unknown or expired challenge")
+		return
+	}
+	var pending *pendingLogin
+	if c.MFAToken != "" {
+		if pending, err = s.mfaCache.Get(c.MFAToken); err != nil {
+			s.writeError(w, http.StatusUnauthorized, "login expired, sign in again")
+			return
+		}
+	}
+	var account string
+	if pending != nil {
+		account = pending.Account
+	}
+	if retry, ok := s.throttle(r, account, ""); !ok {
+		s.audit(r, model.AuditLoginFailed, model.AuditDenied, account, "", "throttled")
+		s.writeThrottled(w, retry)
+		return
+	}
+	fail := func(actor, detail string) {
+		if account != "" {
+			s.limiter.fail(account, time.Now())
+		}
+		s.audit(r, model.AuditLoginFailed, model.AuditFailure, actor, "", detail)
+		s.writeError(w, http.StatusUnauthorized, "invalid passkey")
+	}
+	passkey, err := s.db.GetPasskey(ctx, credentialID(req.Credential.RawID))
+	if err != nil {
+		fail(account, "unknown passkey")
+		return
+	}
+	if (c.UserID != 0 && passkey.UserID != c.UserID) ||
+		(len(resp.UserHandle) != 0 && string(resp.UserHandle) != passkey.UserID.String()) {
+		fail(passkey.UserID.String(), "passkey of another user")
+		return
+	}
+	// a passkey standing in for the password has to verify the user
+	authData, err := s.relyingParty.VerifyAssertion(challenge, passkey.PublicKey, passkey.SignCount,
+		resp.ClientDataJSON, resp.AuthenticatorData, resp.Signature, pending == nil)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		s.logger.Warn("passkey signature counter did not increase, it may be cloned",
+			xlog.Str("passkey", passkey.ID),
+			xlog.Str("user", passkey.UserID.String()),
+		)
+	}
+	if err != nil {
+		fail(passkey.UserID.String(), "invalid passkey: "+err.Error())
+		return
+	}
+	now := time.Now()
+	passkey.SignCount, passkey.BackedUp, passkey.LastUsedAt = authData.SignCount, authData.Has(webauthn.FlagBackedUp), &now
+	if err := s.db.UpdatePasskey(ctx, passkey); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// the passkey is one factor, the user verified by the authenticator another
+	method := passkeyMethod(passkey)
+	amr := withMethod(nil, method)
+	if pending != nil {
+		s.mfaCache.Delete(c.MFAToken)
+		s.limiter.succeed(pending.Account)
+		amr = withMethod(pending.Amr, method)
+	}
+	if authData.Has(webauthn.FlagUserVerified) {
+		amr = withMethod(amr, AMRMultiFactor)
+	}
+	user, err := s.db.GetUser(ctx, passkey.UserID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.audit(r, model.AuditLogin, model.AuditSuccess, user.ID.String(), "", method)
+	if _, err := s.startSession(w, r, user.ID, amr); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, loginResponse{User: user.ToView()})
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/webauthn"
+	"sutext.github.io/entry/webauthn/webauthntest"
+	"sutext.github.io/suid"
+)
+
+func TestPasskeyLogin(t *testing.T) {
+	const origin = "http://localhost:8080"
+	s := New(WithIssuerURL(origin)).(*server)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "alice@example.com"
+	user := &model.User{ID: suid.New(), Email: &email, Hash: string(hash)}
+	db := &mfaStorage{sessionStorage: sessionStorage{sessions: map[string]*model.Session{}}, user: user}
+	s.db = db
+
+	call := func(h http.HandlerFunc, cookie *http.Cookie, req, resp any) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if resp != nil && w.Code < 300 {
+			if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+	session := func(w *httptest.ResponseRecorder) *authSession {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		sess, err := s.currentSession(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess
+	}
+
+	// the first passkey needs a recent sign-in, not just a session cookie
+	w := httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), user.ID, []string{AMRPassword}); err != nil {
+		t.Fatal(err)
+	}
+	for _, sess := range db.sessions {
+		sess.AuthTime = time.Now().Add(-stepUpMaxAge - time.Minute)
+	}
+	stale := w.Result().Cookies()[0]
+	var creation webauthn.CreationOptions
+	call(s.handlePasskeyOptions, stale, nil, &creation)
+	if w := call(s.handlePasskeys, stale, passkeyRegistration{Credential: *webauthntest.New().Create(origin, &creation, "packed")}, nil); w.Code != http.StatusForbidden {
+		t.Errorf("first registration with a stale session status = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), user.ID, []string{AMRPassword}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	call(s.handlePasskeyOptions, cookie, nil, &creation)
+	if creation.RP.ID != "localhost" || string(creation.User.ID) != user.ID.String() {
+		t.Fatalf("creation options = %+v", creation)
+	}
+	a := webauthntest.New()
+	if w := call(s.handlePasskeys, cookie, passkeyRegistration{Name: "laptop", Credential: *a.Create(origin, &creation, "packed")}, nil); w.Code != http.StatusCreated {
+		t.Fatalf("registration status = %d: %s", w.Code, w.Body)
+	}
+
+	// passwordless
+	var request webauthn.RequestOptions
+	call(s.handlePasskeyLoginOptions, nil, nil, &request)
+	if request.UserVerification != webauthn.Required || len(request.AllowCredentials) != 0 {
+		t.Errorf("request options = %+v", request)
+	}
+	assertion := a.Get(origin, &request)
+	w = call(s.handlePasskeyLogin, nil, passkeyLoginRequest{Credential: *assertion}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("passkey login status = %d: %s", w.Code, w.Body)
+	}
+	if sess := session(w); sess.UserID != user.ID || sess.Acr != ACRMultiFactor {
+		t.Errorf("session = %+v", sess)
+	}
+	mfaCookie := w.Result().Cookies()[0]
+	if w := call(s.handlePasskeyLogin, nil, passkeyLoginRequest{Credential: *assertion}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed assertion status = %d", w.Code)
+	}
+
+	// second factor after the password
+	var login loginResponse
+	call(s.handleLogin, nil, loginRequest{Email: email, Password: "secret"}, &login)
+	if !login.MFARequired || !slices.Equal(login.MFAMethods, []string{mfaMethodPasskey}) {
+		t.Fatalf("login = %+v", login)
+	}
+	call(s.handlePasskeyLoginOptions, nil, passkeyLoginOptionsRequest{MFAToken: login.MFAToken}, &request)
+	if len(request.AllowCredentials) != 1 || !bytes.Equal(request.AllowCredentials[0].ID, a.CredentialID) {
+		t.Errorf("allowed credentials = %+v", request.AllowCredentials)
+	}
+	w = call(s.handlePasskeyLogin, nil, passkeyLoginRequest{MFAToken: login.MFAToken, Credential: *a.Get(origin, &request)}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("second factor status = %d: %s", w.Code, w.Body)
+	}
+	if sess := session(w); !slices.Contains(sess.Amr, AMRPassword) || !slices.Contains(sess.Amr, AMRHardwareKey) {
+		t.Errorf("session amr = %v", sess.Amr)
+	}
+
+	// a clone lags behind the signature counter
+	for _, p := range db.passkeys {
+		p.SignCount += 10
+	}
+	call(s.handlePasskeyLoginOptions, nil, nil, &request)
+	if w := call(s.handlePasskeyLogin, nil, passkeyLoginRequest{Credential: *a.Get(origin, &request)}, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("cloned passkey status = %d", w.Code)
+	}
+
+	// changing passkeys needs a recent multi-factor login
+	call(s.handlePasskeyOptions, cookie, nil, &creation)
+	if w := call(s.handlePasskeys, cookie, passkeyRegistration{Credential: *webauthntest.New().Create(origin, &creation, "packed")}, nil); w.Code != http.StatusForbidden {
+		t.Errorf("registration without step-up status = %d", w.Code)
+	}
+	remove := func(cookie *http.Cookie) int {
+		r := httptest.NewRequest("DELETE", "/", nil)
+		r.SetPathValue("id", credentialID(a.CredentialID))
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		s.handlePasskey(w, r)
+		return w.Code
+	}
+	if code := remove(cookie); code != http.StatusForbidden {
+		t.Errorf("removal without step-up status = %d", code)
+	}
+	if code := remove(mfaCookie); code != http.StatusNoContent {
+		t.Errorf("removal after passkey login status = %d", code)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sutext.github.io/entry/cache"
+	"sutext.github.io/entry/xerr"
+)
+
+// RateLimitStrategy the algorithm used to count requests
+type RateLimitStrategy string
+
+const (
+	// RateLimitTokenBucket refills Limit tokens evenly over Window and allows bursts up to Limit.
+	RateLimitTokenBucket RateLimitStrategy = "token_bucket"
+	// RateLimitSlidingWindow allows at most Limit requests in any Window, weighting the previous window.
+	RateLimitSlidingWindow RateLimitStrategy = "sliding_window"
+)
+
+// RateLimitScope what a rate limit is keyed on
+type RateLimitScope string
+
+const (
+	RateLimitPerIP      RateLimitScope = "ip"
+	RateLimitPerAccount RateLimitScope = "account"
+	RateLimitPerClient  RateLimitScope = "client"
+	// RateLimitTokenPerIP replaces RateLimitPerIP on the token endpoint, where
+	// machine clients behind a single address make far more requests than a
+	// browser logging in.
+	RateLimitTokenPerIP RateLimitScope = "token_ip"
+)
+
+// RateLimit describes a single limit. A zero Limit disables it.
+type RateLimit struct {
+	Strategy RateLimitStrategy
+	Limit    int
+	Window   time.Duration
+}
+
+// LockoutPolicy temporarily locks an account after MaxFailures consecutive failed
+// password checks. Every further failure doubles the lock, starting at BaseDelay and
+// capped at MaxDelay. A zero MaxFailures disables lockout.
+type LockoutPolicy struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// RateLimitState is the per-key limiter state kept in the rate limit cache.
+// It is a plain value so that shared cache implementations can serialize it.
+type RateLimitState struct {
+	Tokens      float64   `json:"tokens,omitempty"`
+	Updated     time.Time `json:"updated"`
+	WindowStart time.Time `json:"window_start"`
+	PrevCount   int       `json:"prev_count,omitempty"`
+	CurrCount   int       `json:"curr_count,omitempty"`
+	Failures    int       `json:"failures,omitempty"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// rateLimiter keeps its state in the cache only and relies on Cache.Update
+// being atomic, so that replicas sharing the cache count every request.
+type rateLimiter struct {
+	state   cache.Cache[RateLimitState]
+	limits  map[RateLimitScope]RateLimit
+	lockout LockoutPolicy
+}
+
+func newRateLimiter(state cache.Cache[RateLimitState], limits map[RateLimitScope]RateLimit, lockout LockoutPolicy) *rateLimiter {
+	if state == nil {
+		state = cache.NewMemory[RateLimitState]()
+	}
+	return &rateLimiter{
+		state:   state,
+		limits:  limits,
+		lockout: lockout,
+	}
+}
+
+// allow records a request for key in the given scope and reports whether it may proceed.
+// When it may not, retryAfter tells the caller how long to wait.
+func (l *rateLimiter) allow(scope RateLimitScope, key string, now time.Time) (retryAfter time.Duration, ok bool) {
+	limit, found := l.limits[scope]
+	if !found || limit.Limit <= 0 || limit.Window <= 0 || key == "" {
+		return 0, true
+	}
+	ckey := "rate:" + string(scope) + ":" + key
+	_, err := l.state.Update(ckey, limit.Window*2, func(st RateLimitState, _ bool) RateLimitState {
+		switch limit.Strategy {
+		case RateLimitSlidingWindow:
+			retryAfter, ok = slidingWindow(&st, limit, now)
+		default:
+			retryAfter, ok = tokenBucket(&st, limit, now)
+		}
+		return st
+	})
+	if err != nil {
+		// an unreachable limiter cache must not lock everyone out
+		return 0, true
+	}
+	return retryAfter, ok
+}
+
+func tokenBucket(st *RateLimitState, limit RateLimit, now time.Time) (time.Duration, bool) {
+	rate := float64(limit.Limit) / float64(limit.Window)
+	if st.Updated.IsZero() {
+		st.Tokens = float64(limit.Limit)
+	} else {
+		st.Tokens = math.Min(float64(limit.Limit), st.Tokens+float64(now.Sub(st.Updated))*rate)
+	}
+	st.Updated = now
+	if st.Tokens < 1 {
+		return time.Duration((1 - st.Tokens) / rate), false
+	}
+	st.Tokens--
+	return 0, true
+}
+
+func slidingWindow(st *RateLimitState, limit RateLimit, now time.Time) (time.Duration, bool) {
+	start := now.Truncate(limit.Window)
+	switch {
+	case st.WindowStart.Equal(start):
+	case st.WindowStart.Add(limit.Window).Equal(start):
+		st.PrevCount, st.CurrCount = st.CurrCount, 0
+	default:
+		st.PrevCount, st.CurrCount = 0, 0
+	}
+	st.WindowStart = start
+	st.Updated = now
+	elapsed := now.Sub(start)
+	weight := float64(limit.Window-elapsed) / float64(limit.Window)
+	if float64(st.PrevCount)*weight+float64(st.CurrCount) >= float64(limit.Limit) {
+		return limit.Window - elapsed, false
+	}
+	st.CurrCount++
+	return 0, true
+}
+
+// locked reports whether the account is locked out and for how long.
+func (l *rateLimiter) locked(account string, now time.Time) (time.Duration, bool) {
+	if l.lockout.MaxFailures <= 0 || account == "" {
+		return 0, false
+	}
+	st, err := l.state.Get("lock:" + account)
+	if err != nil || !now.Before(st.LockedUntil) {
+		return 0, false
+	}
+	return st.LockedUntil.Sub(now), true
+}
+
+// fail records a failed authentication attempt and locks the account once the
+// policy threshold is reached.
+func (l *rateLimiter) fail(account string, now time.Time) {
+	if l.lockout.MaxFailures <= 0 || account == "" {
+		return
+	}
+	ttl := l.lockout.MaxDelay
+	if ttl <= 0 {
+		ttl = time.Hour * 24
+	}
+	l.state.Update("lock:"+account, ttl*2, func(st RateLimitState, _ bool) RateLimitState {
+		st.Failures++
+		st.Updated = now
+		if over := st.Failures - l.lockout.MaxFailures; over >= 0 {
+			delay := l.lockout.BaseDelay << min(over, 30)
+			if l.lockout.MaxDelay > 0 && (delay > l.lockout.MaxDelay || delay <= 0) {
+				delay = l.lockout.MaxDelay
+			}
+			st.LockedUntil = now.Add(delay)
+		}
+		return st
+	})
+}
+
+// succeed clears the failure counter of the account.
+func (l *rateLimiter) succeed(account string) {
+	if l.lockout.MaxFailures <= 0 || account == "" {
+		return
+	}
+	l.state.Delete("lock:" + account)
+}
+
+// throttle applies the ip, account and client limits of the request in that order.
+// Empty keys are skipped.
+func (s *server) throttle(r *http.Request, account, clientID string) (time.Duration, bool) {
+	return s.throttleScope(r, RateLimitPerIP, account, clientID)
+}
+
+// throttleToken is throttle for the token endpoint.
+func (s *server) throttleToken(r *http.Request, account, clientID string) (time.Duration, bool) {
+	return s.throttleScope(r, RateLimitTokenPerIP, account, clientID)
+}
+
+func (s *server) throttleScope(r *http.Request, ipScope RateLimitScope, account, clientID string) (time.Duration, bool) {
+	now := time.Now()
+	ip, _ := s.parseRealIP(r)
+	if retry, ok := s.limiter.allow(ipScope, ip, now); !ok {
+		return retry, false
+	}
+	if retry, ok := s.limiter.locked(account, now); ok {
+		return retry, false
+	}
+	if retry, ok := s.limiter.allow(RateLimitPerAccount, account, now); !ok {
+		return retry, false
+	}
+	if retry, ok := s.limiter.allow(RateLimitPerClient, clientID, now); !ok {
+		return retry, false
+	}
+	return 0, true
+}
+
+func retryAfterHeader(retry time.Duration) http.Header {
+	secs := int64(math.Ceil(retry.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	h := make(http.Header)
+	h.Set("Retry-After", strconv.FormatInt(secs, 10))
+	return h
+}
+
+func (s *server) writeThrottled(w http.ResponseWriter, retry time.Duration) {
+	for k, v := range retryAfterHeader(retry) {
+		w.Header()[k] = v
+	}
+	s.writeError(w, http.StatusTooManyRequests, xerr.Descriptions[xerr.ErrTooManyRequests])
+}
+
+func (s *server) tokenThrottled(w http.ResponseWriter, retry time.Duration) error {
+	data, statusCode, _ := s.getErrorData(xerr.ErrTooManyRequests)
+	return s.token(w, data, retryAfterHeader(retry), statusCode)
+}
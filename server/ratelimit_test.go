@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	l := newRateLimiter(nil, map[RateLimitScope]RateLimit{
+		RateLimitPerIP: {Strategy: RateLimitTokenBucket, Limit: 3, Window: time.Minute},
+	}, LockoutPolicy{})
+	now := time.Now()
+	for i := range 3 {
+		if _, ok := l.allow(RateLimitPerIP, "10.0.0.1", now); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	retry, ok := l.allow(RateLimitPerIP, "10.0.0.1", now)
+	if ok {
+		t.Fatalf("fourth request should be throttled")
+	}
+	if retry <= 0 || retry > 20*time.Second {
+		t.Errorf("unexpected retry after %s", retry)
+	}
+	if _, ok := l.allow(RateLimitPerIP, "10.0.0.2", now); !ok {
+		t.Errorf("other ip should not be throttled")
+	}
+	if _, ok := l.allow(RateLimitPerIP, "10.0.0.1", now.Add(20*time.Second)); !ok {
+		t.Errorf("bucket should refill one token after 20s")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	l := newRateLimiter(nil, map[RateLimitScope]RateLimit{
+		RateLimitPerAccount: {Strategy: RateLimitSlidingWindow, Limit: 2, Window: time.Minute},
+	}, LockoutPolicy{})
+	now := time.Now().Truncate(time.Minute)
+	l.allow(RateLimitPerAccount, "a@b.c", now)
+	l.allow(RateLimitPerAccount, "a@b.c", now)
+	if _, ok := l.allow(RateLimitPerAccount, "a@b.c", now.Add(time.Second)); ok {
+		t.Fatalf("third request in window should be throttled")
+	}
+	// half way into the next window the previous one still weighs 1 request
+	if _, ok := l.allow(RateLimitPerAccount, "a@b.c", now.Add(90*time.Second)); !ok {
+		t.Errorf("request should be allowed in the next window")
+	}
+	if _, ok := l.allow(RateLimitPerAccount, "a@b.c", now.Add(90*time.Second)); ok {
+		t.Errorf("weighted previous window should throttle")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := newRateLimiter(nil, nil, LockoutPolicy{MaxFailures: 2, BaseDelay: time.Minute, MaxDelay: 3 * time.Minute})
+	now := time.Now()
+	l.fail("a@b.c", now)
+	if _, ok := l.locked("a@b.c", now); ok {
+		t.Fatalf("account should not be locked after one failure")
+	}
+	l.fail("a@b.c", now)
+	if retry, ok := l.locked("a@b.c", now); !ok || retry != time.Minute {
+		t.Fatalf("account should be locked for 1m, got %s %v", retry, ok)
+	}
+	l.fail("a@b.c", now)
+	if retry, _ := l.locked("a@b.c", now); retry != 2*time.Minute {
+		t.Errorf("lock should double, got %s", retry)
+	}
+	l.fail("a@b.c", now)
+	if retry, _ := l.locked("a@b.c", now); retry != 3*time.Minute {
+		t.Errorf("lock should be capped, got %s", retry)
+	}
+	l.succeed("a@b.c")
+	if _, ok := l.locked("a@b.c", now); ok {
+		t.Errorf("success should clear the lock")
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	l := newRateLimiter(nil, map[RateLimitScope]RateLimit{
+		RateLimitPerIP: {Strategy: RateLimitSlidingWindow, Limit: 10, Window: time.Minute},
+	}, LockoutPolicy{MaxFailures: 1000, BaseDelay: time.Minute})
+	now := time.Now()
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			if _, ok := l.allow(RateLimitPerIP, "10.0.0.1", now); ok {
+				allowed.Add(1)
+			}
+			l.fail("a@b.c", now)
+		})
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 10 {
+		t.Errorf("%d concurrent requests allowed, want 10", n)
+	}
+	if st, _ := l.state.Get("lock:a@b.c"); st.Failures != 50 {
+		t.Errorf("%d failures recorded, want 50", st.Failures)
+	}
+}
+
+func TestTokenEndpointLimit(t *testing.T) {
+	s := New(
+		WithRateLimit(RateLimitPerIP, RateLimit{Limit: 1, Window: time.Minute}),
+		WithRateLimit(RateLimitTokenPerIP, RateLimit{Limit: 2, Window: time.Minute}),
+	).(*server)
+	r := httptest.NewRequest("POST", "/oauth/token", nil)
+	for i := range 2 {
+		if _, ok := s.throttleToken(r, "", ""); !ok {
+			t.Fatalf("token request %d throttled by the login limit", i)
+		}
+	}
+	if _, ok := s.throttleToken(r, "", ""); ok {
+		t.Error("token limit not applied")
+	}
+	if _, ok := s.throttle(r, "", ""); !ok {
+		t.Error("token requests counted against the login limit")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+
+	"sutext.github.io/entry/xerr"
+)
+
+// identityScopes are granted by the end-user's identity rather than by a resource.
+var identityScopes = []string{"openid", "profile", "email", "phone", "address", "offline_access"}
+
+// checkResources validates the resource indicators of a request. Every resource
+// must be a registered absolute URI without fragment, and every requested scope
+// other than the identity scopes must be allowed by at least one of them.
+// https://www.rfc-editor.org/rfc/rfc8707#section-2
+func (s *server) checkResources(ctx context.Context, resources []string, scp string) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	allowed := make([]string, 0)
+	for _, res := range resources {
+		u, err := url.Parse(res)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return xerr.ErrInvalidTarget
+		}
+		resource, err := s.db.GetResource(ctx, res)
+		if err != nil {
+			return xerr.ErrInvalidTarget
+		}
+		allowed = append(allowed, resource.Scopes...)
+	}
+	for v := range strings.FieldsSeq(scp) {
+		if !slices.Contains(identityScopes, v) && !slices.Contains(allowed, v) {
+			return xerr.ErrInvalidScope
+		}
+	}
+	return nil
+}
+
+// narrowResources returns the resources requested at the token endpoint, which
+// must be a subset of the granted ones. No request keeps all granted resources.
+func narrowResources(granted, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	for _, res := range requested {
+		if !slices.Contains(granted, res) {
+			return nil, xerr.ErrInvalidTarget
+		}
+	}
+	return requested, nil
+}
+
+// tokenAudience returns the audience of an access token: the resources it is
+// meant for, or the client itself when no resource was requested.
+func tokenAudience(resources []string, clientID string) []string {
+	if len(resources) > 0 {
+		return resources
+	}
+	if clientID == "" {
+		return nil
+	}
+	return []string{clientID}
+}
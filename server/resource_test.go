@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+)
+
+func TestCheckResources(t *testing.T) {
+	s := &server{db: &resourceStorage{resources: map[string]*model.Resource{
+		"https://api.example.com": {ID: "https://api.example.com", Scopes: model.Strings{"read"}},
+	}}}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		resources []string
+		scope     string
+		want      error
+	}{
+		{nil, "anything", nil},
+		{[]string{"https://api.example.com"}, "openid read", nil},
+		{[]string{"https://api.example.com"}, "write", xerr.ErrInvalidScope},
+		{[]string{"https://unknown.example.com"}, "read", xerr.ErrInvalidTarget},
+		{[]string{"https://api.example.com#frag"}, "read", xerr.ErrInvalidTarget},
+		{[]string{"api"}, "read", xerr.ErrInvalidTarget},
+	} {
+		if err := s.checkResources(ctx, tc.resources, tc.scope); err != tc.want {
+			t.Errorf("checkResources(%v, %q) = %v, want %v", tc.resources, tc.scope, err, tc.want)
+		}
+	}
+}
+
+func TestNarrowResources(t *testing.T) {
+	granted := []string{"https://a.example.com", "https://b.example.com"}
+	if got, _ := narrowResources(granted, nil); len(got) != 2 {
+		t.Errorf("no request must keep every granted resource, got %v", got)
+	}
+	if got, _ := narrowResources(granted, []string{"https://b.example.com"}); len(got) != 1 || got[0] != "https://b.example.com" {
+		t.Errorf("unexpected narrowed resources %v", got)
+	}
+	if _, err := narrowResources(granted, []string{"https://c.example.com"}); err != xerr.ErrInvalidTarget {
+		t.Errorf("err = %v, want %v", err, xerr.ErrInvalidTarget)
+	}
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"context"
+	"crypto"
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -12,12 +14,18 @@ import (
 	"net/netip"
 	"net/url"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/go-jose/go-jose/v4"
+	"sutext.github.io/entry/audit"
 	"sutext.github.io/entry/cache"
 	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/rar"
 	"sutext.github.io/entry/view"
+	"sutext.github.io/entry/web"
+	"sutext.github.io/entry/webauthn"
+	"sutext.github.io/entry/webhook"
 	"sutext.github.io/entry/xerr"
 	"sutext.github.io/entry/xlog"
 )
@@ -25,17 +33,23 @@ import (
 type endpints struct {
 	JWKS       string
 	Authorize  string
+	PAR        string
 	Token      string
 	Login      string
 	Logout     string
+	EndSession string
 	Device     string
 	Profile    string
 	Approve    string
 	Preview    string
+	Callback   string
 	Register   string
 	UserInfo   string
 	Discovery  string
 	Introspect string
+	Revoke     string
+	Password   string
+	Admin      string
 }
 
 type Server interface {
@@ -46,13 +60,45 @@ type server struct {
 	db                            model.Storage
 	mux                           *http.ServeMux
 	reqCache                      cache.Cache[*AuthorizeRequest]
+	parCache                      cache.Cache[*AuthorizeRequest]
+	sectorCache                   cache.Cache[[]string]
+	httpClient                    *http.Client
 	codeCache                     cache.Cache[*AuthorizeRequest]
+	respCache                     cache.Cache[*pendingResponse]
+	dpopCache                     cache.Cache[bool]
+	assertionCache                cache.Cache[bool]
+	mfaCache                      cache.Cache[*pendingLogin]
+	webauthnCache                 cache.Cache[*webauthnCeremony]
+	relyingParty                  *webauthn.RelyingParty
+	web                           *web.WebSite
+	limiter                       *rateLimiter
 	secret                        ed25519.PublicKey
+	keyID                         string
 	signer                        jose.Signer
+	accessTokenSigner             jose.Signer
+	logoutTokenSigner             jose.Signer
+	backchannel                   *webhook.Queue
+	accessTokenClaimsHandler      AccessTokenClaimsHandler
+	defaultAccessTokenFormat      model.AccessTokenFormat
+	tokenPurgeInterval            time.Duration
+	sessionIdleTimeout            time.Duration
+	sessionAbsoluteTimeout        time.Duration
+	revokeRefreshOnLogout         bool
+	stop                          chan struct{}
+	pairwiseSalt                  string
+	claimSources                  []ClaimSource
 	logger                        *xlog.Logger
+	auditSink                     audit.Sink
+	auditStorage                  bool
+	adminToken                    string
+	webhooks                      *webhook.Dispatcher
+	webhookOptions                webhook.Options
 	dirver                        model.Driver
 	endpoints                     endpints
 	issuerURL                     url.URL
+	issuer                        string
+	trustedIssuers                map[string]*TrustedIssuer
+	detailsTypes                  rar.Registry
 	allHeaders                    http.Header
 	realIPHeader                  string
 	allowedOrigins                []string
@@ -81,10 +127,23 @@ func New(opts ...Option) Server {
 	s := &server{
 		mux:                           http.NewServeMux(),
 		reqCache:                      cache.NewMemory[*AuthorizeRequest](),
+		parCache:                      cache.NewMemory[*AuthorizeRequest](),
+		sectorCache:                   cache.NewMemory[[]string](),
+		httpClient:                    &http.Client{Timeout: 10 * time.Second},
 		codeCache:                     cache.NewMemory[*AuthorizeRequest](),
+		respCache:                     cache.NewMemory[*pendingResponse](),
+		dpopCache:                     cache.NewMemory[bool](),
+		assertionCache:                cache.NewMemory[bool](),
+		mfaCache:                      cache.NewMemory[*pendingLogin](),
+		webauthnCache:                 cache.NewMemory[*webauthnCeremony](),
+		limiter:                       newRateLimiter(options.rateLimitCache, options.rateLimits, options.lockoutPolicy),
 		logger:                        options.logger,
+		auditStorage:                  options.auditStorage,
+		adminToken:                    options.adminToken,
+		webhookOptions:                options.webhookOptions,
 		dirver:                        options.dirver,
 		issuerURL:                     *issuerURL,
+		issuer:                        normalizeIssuer(issuerURL),
 		allHeaders:                    options.allHeaders,
 		realIPHeader:                  options.realIPHeader,
 		allowedOrigins:                options.allowedOrigins,
@@ -95,30 +154,106 @@ func New(opts ...Option) Server {
 		supportedGrantTypes:           options.supportedGrantTypes,
 		supportedResponseTypes:        options.supportedResponseTypes,
 		supportedCodeChallengeMethods: options.supportedCodeChallengeMethods,
+		accessTokenClaimsHandler:      options.accessTokenClaimsHandler,
+		defaultAccessTokenFormat:      options.accessTokenFormat,
+		tokenPurgeInterval:            options.tokenPurgeInterval,
+		sessionIdleTimeout:            options.sessionIdleTimeout,
+		sessionAbsoluteTimeout:        options.sessionAbsoluteTimeout,
+		revokeRefreshOnLogout:         options.revokeRefreshOnLogout,
+		stop:                          make(chan struct{}),
+		pairwiseSalt:                  options.pairwiseSalt,
+		claimSources:                  options.claimSources,
+	}
+	s.relyingParty = &webauthn.RelyingParty{
+		ID:      issuerURL.Hostname(),
+		Name:    "entry",
+		Origins: options.webauthnOrigins,
+	}
+	if len(s.relyingParty.Origins) == 0 {
+		s.relyingParty.Origins = []string{issuerURL.Scheme + "://" + issuerURL.Host}
+	}
+	if s.pairwiseSalt == "" {
+		sum := sha256.Sum256(append([]byte("pairwise:"), seed...))
+		s.pairwiseSalt = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	s.web, err = web.NewWebSite(web.Config{
+		FS:        web.FS(),
+		Issuer:    "entry",
+		IssuerURL: s.issuer,
+	})
+	if err != nil {
+		panic(err)
+	}
+	s.trustedIssuers = make(map[string]*TrustedIssuer, len(options.trustedIssuers))
+	for i := range options.trustedIssuers {
+		ti := &options.trustedIssuers[i]
+		if ti.JWKS == nil {
+			ti.file = &jwksFile{}
+		}
+		s.trustedIssuers[ti.Issuer] = ti
+	}
+	s.detailsTypes = make(rar.Registry, len(options.authorizationDetailsTypes))
+	for typ, schema := range options.authorizationDetailsTypes {
+		if s.detailsTypes[typ], err = rar.ParseSchema([]byte(schema)); err != nil {
+			panic(fmt.Errorf("invalid schema of authorization details type %s: %w", typ, err))
+		}
+	}
+	if len(options.auditSinks) == 0 {
+		s.auditSink = audit.NewLogger(options.logger)
+	} else {
+		s.auditSink = audit.Multi(options.auditSinks...)
 	}
 	secret := ed25519.NewKeyFromSeed(seed)
+	s.secret = secret.Public().(ed25519.PublicKey)
+	thumbprint, err := (&jose.JSONWebKey{Key: s.secret}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+	s.keyID = base64.RawURLEncoding.EncodeToString(thumbprint)
 	s.signer, err = jose.NewSigner(jose.SigningKey{
 		Algorithm: jose.EdDSA,
 		Key:       secret,
-	}, nil)
-	s.secret = secret.Public().(ed25519.PublicKey)
+	}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", s.keyID))
 	if err != nil {
 		panic(err)
 	}
+	// access tokens are typed to keep them apart from ID tokens, RFC 9068 section 2.1
+	s.accessTokenSigner, err = jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.EdDSA,
+		Key:       secret,
+	}, (&jose.SignerOptions{}).WithType("at+jwt").WithHeader("kid", s.keyID))
+	if err != nil {
+		panic(err)
+	}
+	// logout tokens are typed so they cannot pass for ID tokens
+	s.logoutTokenSigner, err = jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.EdDSA,
+		Key:       secret,
+	}, (&jose.SignerOptions{}).WithType("logout+jwt").WithHeader("kid", s.keyID))
+	if err != nil {
+		panic(err)
+	}
+	s.backchannel = newLogoutQueue(options.backchannelOptions, s.logger)
 	s.endpoints = endpints{
 		JWKS:       "/oauth/keys",
 		Token:      "/oauth/token",
 		Device:     "/oauth/device/code",
 		Authorize:  "/oauth/authorize",
+		PAR:        "/oauth/par",
 		Approve:    "/oauth/authorize/approve",
 		Preview:    "/oauth/authorize/preview",
+		Callback:   "/oauth/authorize/callback",
 		Profile:    "/profile",
 		Login:      "/login",
 		Logout:     "/logout",
+		EndSession: "/oauth/logout",
 		Register:   "/register",
+		Password:   "/password",
+		Admin:      "/admin",
 		UserInfo:   "/oauth/userinfo",
 		Discovery:  "/.well-known/openid-configuration",
 		Introspect: "/oauth/token/introspect",
+		Revoke:     "/oauth/revoke",
 	}
 	return s
 }
@@ -131,6 +266,17 @@ func (s *server) Serve() error {
 		return err
 	}
 	s.db = db
+	if s.auditStorage {
+		s.auditSink = audit.Multi(s.auditSink, audit.NewStorage(db))
+	}
+	if s.webhookOptions.Logger == nil {
+		s.webhookOptions.Logger = s.logger
+	}
+	s.webhooks = webhook.NewDispatcher(db, s.webhookOptions)
+	s.db = audit.Wrap(webhook.Wrap(db, s.webhooks), s.auditSink, s.logger)
+	if s.tokenPurgeInterval > 0 {
+		go s.purge()
+	}
 	fss, err := view.FileServer()
 	if err != nil {
 		return err
@@ -141,7 +287,7 @@ func (s *server) Serve() error {
 	// 	LogoURL:      "https://example.com/logo.png",
 	// 	Secret:       "22222222",
 	// 	Scopes:       []string{"all", "profile", "email"},
-	// 	Public:       true,
+	// 	Type:         model.ClientTypePublic,
 	// 	Description:  "This a test client",
 	// 	RedirectURIs: []string{"http://localhost:9094/oauth2"},
 	// }
@@ -149,17 +295,53 @@ func (s *server) Serve() error {
 
 	s.mux.Handle("/", fss)
 	s.mux.HandleFunc(s.endpoints.Logout, s.handleLogout)
+	s.mux.HandleFunc(s.endpoints.EndSession, s.handleEndSession)
 	s.mux.HandleFunc(s.endpoints.Discovery, s.handleDiscovery)
+	s.mux.HandleFunc(s.endpoints.JWKS, s.handleJWKS)
 	s.mux.HandleFunc(s.endpoints.Login, s.handleLogin)
+	s.mux.HandleFunc(s.endpoints.Login+"/mfa", s.handleLoginMFA)
+	s.mux.HandleFunc(s.endpoints.Login+"/passkey", s.handlePasskeyLogin)
+	s.mux.HandleFunc(s.endpoints.Login+"/passkey/options", s.handlePasskeyLoginOptions)
 	s.mux.HandleFunc(s.endpoints.Token, s.handleToken)
+	s.mux.HandleFunc(s.endpoints.Introspect, s.handleIntrospect)
+	s.mux.HandleFunc(s.endpoints.Revoke, s.handleRevoke)
+	s.mux.HandleFunc(s.endpoints.UserInfo, s.handleUserInfo)
 	s.mux.HandleFunc(s.endpoints.Profile, s.handleProfile)
+	s.mux.HandleFunc(s.endpoints.Profile+"/grants", s.handleGrants)
+	s.mux.HandleFunc(s.endpoints.Profile+"/grants/{client_id}", s.handleRevokeGrant)
+	s.mux.HandleFunc(s.endpoints.Profile+"/mfa", s.handleDisableMFA)
+	s.mux.HandleFunc(s.endpoints.Profile+"/mfa/totp", s.handleTOTPEnroll)
+	s.mux.HandleFunc(s.endpoints.Profile+"/mfa/totp/verify", s.handleTOTPConfirm)
+	s.mux.HandleFunc(s.endpoints.Profile+"/mfa/recovery-codes", s.handleRecoveryCodes)
+	s.mux.HandleFunc(s.endpoints.Profile+"/passkeys", s.handlePasskeys)
+	s.mux.HandleFunc(s.endpoints.Profile+"/passkeys/options", s.handlePasskeyOptions)
+	s.mux.HandleFunc(s.endpoints.Profile+"/passkeys/{id}", s.handlePasskey)
 	s.mux.HandleFunc(s.endpoints.Register, s.handleRegister)
+	s.mux.HandleFunc(s.endpoints.Password, s.handlePassword)
+	s.mux.HandleFunc(s.endpoints.Admin+"/webhooks", s.handleAdminWebhooks)
+	s.mux.HandleFunc(s.endpoints.Admin+"/webhooks/{id}", s.handleAdminWebhook)
+	s.mux.HandleFunc(s.endpoints.Admin+"/deadletters", s.handleAdminDeadLetters)
+	s.mux.HandleFunc(s.endpoints.Admin+"/deadletters/{id}/replay", s.handleAdminReplay)
+	s.mux.HandleFunc(s.endpoints.Admin+"/resources", s.handleAdminResources)
+	s.mux.HandleFunc(s.endpoints.Admin+"/resources/{id}", s.handleAdminResource)
+	s.mux.HandleFunc(s.endpoints.Admin+"/users/{id}/mfa", s.handleAdminUserMFA)
 	s.mux.HandleFunc(s.endpoints.Authorize, s.handleAuthorize)
+	s.mux.HandleFunc(s.endpoints.PAR, s.handlePAR)
 	s.mux.HandleFunc(s.endpoints.Preview, s.handleAuthorizePreview)
 	s.mux.HandleFunc(s.endpoints.Approve, s.handleAuthorizeApprove)
+	s.mux.HandleFunc(s.endpoints.Callback, s.handleAuthorizeCallback)
 	return http.ListenAndServe(":8080", s.mux)
 }
 func (s *server) Shoutdown(ctx context.Context) error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	if s.webhooks != nil {
+		s.webhooks.Close()
+	}
+	s.backchannel.Close()
 	return nil
 }
 
@@ -215,6 +397,19 @@ func (s *server) parseRealIP(r *http.Request) (string, error) {
 	}
 	return remoteAddr, nil
 }
+
+// normalizeIssuer returns the issuer identifier of the server: a lower case
+// scheme and host, no query or fragment and no trailing slash. The same value
+// is used in discovery, token iss claims and authorization responses so clients
+// can compare it by simple string comparison.
+func normalizeIssuer(u *url.URL) string {
+	n := url.URL{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   strings.ToLower(u.Host),
+		Path:   strings.TrimRight(u.Path, "/"),
+	}
+	return n.String()
+}
 func (s *server) absURL(pathItems ...string) string {
 	u := s.issuerURL
 	u.Path = s.absPath(pathItems...)
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xlog"
+	"sutext.github.io/suid"
+)
+
+// sessionCookie is the name of the cookie holding the browser session.
+const sessionCookie = "entry_session"
+
+// sessionTouchInterval bounds how often the last activity of a session is written.
+const sessionTouchInterval = time.Minute
+
+// authSession is the authenticated end-user of a request.
+type authSession struct {
+	// ID is the stored ID of the browser session.
+	ID       string
+	UserID   suid.SUID
+	AuthTime time.Time
+	// Amr are the methods the user authenticated with, Acr the class they reach.
+	Amr []string
+	Acr string
+}
+
+func newAuthSession(session *model.Session) *authSession {
+	return &authSession{
+		ID:       session.ID,
+		UserID:   session.UserID,
+		AuthTime: session.AuthTime,
+		Amr:      session.Amr,
+		Acr:      acrOf(session.Amr),
+	}
+}
+
+// startSession signs the user in the browser after they authenticated with the
+// methods: it stores a new session and sets its cookie. A session of the
+// request is replaced so a session ID never outlives a change of the user.
+func (s *server) startSession(w http.ResponseWriter, r *http.Request, userID suid.SUID, amr []string) (*authSession, error) {
+	ctx := r.Context()
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := s.db.DeleteSession(ctx, tokenHash(c.Value)); err != nil {
+			s.logger.Error("failed to delete replaced session", xlog.Err(err))
+		}
+	}
+	value := rand.Text()
+	now := time.Now()
+	session := &model.Session{
+		ID:        tokenHash(value),
+		UserID:    userID,
+		AuthTime:  now,
+		Amr:       amr,
+		UserAgent: r.UserAgent(),
+		LastSeen:  now,
+		ExpiresAt: now.Add(s.sessionAbsoluteTimeout),
+		CreatedAt: now,
+	}
+	session.IP, _ = s.parseRealIP(r)
+	if err := s.db.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return newAuthSession(session), nil
+}
+
+// currentSession returns the browser session of the logged in end-user. Using
+// the session extends it up to the idle timeout, never past its absolute end.
+func (s *server) currentSession(r *http.Request) (*authSession, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil, fmt.Errorf("missing session cookie")
+	}
+	ctx := r.Context()
+	session, err := s.db.GetSession(ctx, tokenHash(c.Value))
+	if err != nil {
+		return nil, fmt.Errorf("unknown session")
+	}
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.Sub(session.LastSeen) > s.sessionIdleTimeout {
+		if err := s.db.DeleteSession(ctx, session.ID); err != nil {
+			s.logger.Error("failed to delete expired session", xlog.Err(err))
+		}
+		return nil, fmt.Errorf("session expired")
+	}
+	if now.Sub(session.LastSeen) > sessionTouchInterval {
+		session.LastSeen = now
+		if err := s.db.UpdateSession(ctx, session); err != nil {
+			return nil, err
+		}
+	}
+	return newAuthSession(session), nil
+}
+
+// sessionAlive reports whether the browser session still exists and has not
+// timed out. Tokens issued without a session are always bound to a live one.
+func (s *server) sessionAlive(ctx context.Context, id string) bool {
+	if id == "" {
+		return true
+	}
+	session, err := s.db.GetSession(ctx, id)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	return now.Before(session.ExpiresAt) && now.Sub(session.LastSeen) <= s.sessionIdleTimeout
+}
+
+// joinSession records that the user signed in to the client through the
+// browser session, so the client is notified when the session ends.
+func (s *server) joinSession(ctx context.Context, sessionID, clientID string) error {
+	if sessionID == "" {
+		return nil
+	}
+	session, err := s.db.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(session.Clients, clientID) {
+		return nil
+	}
+	session.Clients = append(session.Clients, clientID)
+	return s.db.UpdateSession(ctx, session)
+}
+
+// endSession deletes the browser session of the request and clears its cookie.
+// It returns the ended session, or nil when there was none.
+func (s *server) endSession(w http.ResponseWriter, r *http.Request) (*model.Session, error) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil, nil
+	}
+	ctx := r.Context()
+	session, err := s.db.GetSession(ctx, tokenHash(c.Value))
+	if err != nil {
+		return nil, nil
+	}
+	return session, s.db.DeleteSession(ctx, session.ID)
+}
+
+// purgeSessions removes sessions which timed out.
+func (s *server) purgeSessions(now time.Time) {
+	n, err := s.db.PurgeSessions(context.Background(), now.Add(-s.sessionIdleTimeout), now)
+	if err != nil {
+		s.logger.Error("failed to purge sessions", xlog.Err(err))
+		return
+	}
+	if n > 0 {
+		s.logger.Info("purged expired sessions", xlog.I64("count", n))
+	}
+}
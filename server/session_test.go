@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"sutext.github.io/entry/audit"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+func TestSession(t *testing.T) {
+	s := New(WithSessionTimeouts(time.Minute*30, time.Hour)).(*server)
+	db := &sessionStorage{sessions: map[string]*model.Session{}}
+	s.db = db
+	userID := suid.New()
+
+	w := httptest.NewRecorder()
+	started, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), userID, []string{AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("session cookie = %+v", cookies)
+	}
+	request := func() *http.Request {
+		r := httptest.NewRequest("GET", "/profile", nil)
+		r.AddCookie(cookies[0])
+		return r
+	}
+	sess, err := s.currentSession(request())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.ID != started.ID || sess.UserID != userID || sess.Acr != ACRSingleFactor {
+		t.Errorf("session = %+v", sess)
+	}
+	if _, ok := db.sessions[cookies[0].Value]; ok {
+		t.Error("session stored under the cookie value")
+	}
+
+	// an idle session ends
+	db.sessions[sess.ID].LastSeen = time.Now().Add(-time.Hour)
+	if _, err := s.currentSession(request()); err == nil {
+		t.Error("idle session accepted")
+	}
+	if s.sessionAlive(context.Background(), sess.ID) {
+		t.Error("idle session alive")
+	}
+
+	// logging out deletes the session and clears the cookie
+	w = httptest.NewRecorder()
+	started, err = s.startSession(w, request(), userID, []string{AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies = w.Result().Cookies()
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(cookies[0])
+	s.handleLogout(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout status = %d", w.Code)
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("cookie not cleared: %+v", c)
+	}
+	if s.sessionAlive(context.Background(), started.ID) {
+		t.Error("session alive after logout")
+	}
+	if !s.sessionAlive(context.Background(), "") {
+		t.Error("tokens without session must stay alive")
+	}
+}
+
+func TestPasswordChange(t *testing.T) {
+	var outcomes []model.AuditOutcome
+	s := newTestServer(WithAuditSink(audit.SinkFunc(func(ctx context.Context, e *model.AuditEvent) error {
+		if e.Type == model.AuditPasswordChanged {
+			outcomes = append(outcomes, e.Outcome)
+		}
+		return nil
+	})))
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{ID: suid.New(), Hash: string(hash)}
+	db := &mfaStorage{sessionStorage: sessionStorage{sessions: map[string]*model.Session{}}, user: user}
+	s.db = db
+	var cookies []*http.Cookie
+	for range 2 {
+		w := httptest.NewRecorder()
+		if _, err := s.startSession(w, httptest.NewRequest("POST", "/login", nil), user.ID, []string{AMRPassword}); err != nil {
+			t.Fatal(err)
+		}
+		cookies = append(cookies, w.Result().Cookies()[0])
+	}
+	change := func(old string) int {
+		r := httptest.NewRequest("POST", "/password", strings.NewReader(`{"old_password":"`+old+`","new_password":"changed"}`))
+		r.AddCookie(cookies[0])
+		w := httptest.NewRecorder()
+		s.handlePassword(w, r)
+		return w.Code
+	}
+	if code := change("wrong"); code != http.StatusUnauthorized || len(db.sessions) != 2 {
+		t.Errorf("wrong password status = %d, %d sessions", code, len(db.sessions))
+	}
+	if code := change("secret"); code != http.StatusNoContent {
+		t.Fatalf("change status = %d", code)
+	}
+	for i, want := range []bool{true, false} {
+		r := httptest.NewRequest("GET", "/profile", nil)
+		r.AddCookie(cookies[i])
+		if _, err := s.currentSession(r); (err == nil) != want {
+			t.Errorf("session %d alive = %v, want %v", i, err == nil, want)
+		}
+	}
+	if !slices.Equal(outcomes, []model.AuditOutcome{model.AuditFailure, model.AuditSuccess}) {
+		t.Errorf("audited outcomes = %v", outcomes)
+	}
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid"
+	"sutext.github.io/suid/guid"
+)
+
+// testIssuer is the issuer of the servers of the tests.
+const testIssuer = "http://localhost:8080"
+
+// newTestServer returns a server issuing as testIssuer, further options are
+// applied after it.
+func newTestServer(opts ...Option) *server {
+	return New(append([]Option{WithIssuerURL(testIssuer)}, opts...)...).(*server)
+}
+
+// clientStorage serves clients and pairwise subjects from memory, every other
+// storage method panics.
+type clientStorage struct {
+	model.Storage
+	clients  map[string]*model.Client
+	subjects map[string]*model.PairwiseSubject
+}
+
+func (s *clientStorage) GetPairwiseSubject(ctx context.Context, id string) (*model.PairwiseSubject, error) {
+	if p, ok := s.subjects[id]; ok {
+		return p, nil
+	}
+	return nil, xerr.ErrInvalidRequest
+}
+
+func (s *clientStorage) SavePairwiseSubject(ctx context.Context, p *model.PairwiseSubject) error {
+	if s.subjects == nil {
+		s.subjects = make(map[string]*model.PairwiseSubject)
+	}
+	s.subjects[p.ID] = p
+	return nil
+}
+
+func (s *clientStorage) GetClient(ctx context.Context, id string) (*model.Client, error) {
+	if c, ok := s.clients[id]; ok {
+		return c, nil
+	}
+	return nil, xerr.ErrInvalidClient
+}
+
+// sessionStorage serves browser sessions and clients from memory.
+type sessionStorage struct {
+	clientStorage
+	sessions map[string]*model.Session
+}
+
+func (s *sessionStorage) GetSession(ctx context.Context, id string) (*model.Session, error) {
+	if session, ok := s.sessions[id]; ok {
+		copied := *session
+		return &copied, nil
+	}
+	return nil, xerr.ErrInvalidRequest
+}
+
+func (s *sessionStorage) CreateSession(ctx context.Context, session *model.Session) error {
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *sessionStorage) UpdateSession(ctx context.Context, session *model.Session) error {
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *sessionStorage) DeleteSession(ctx context.Context, id string) error {
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *sessionStorage) ListUserSessions(ctx context.Context, userID suid.SUID) ([]*model.Session, error) {
+	var sessions []*model.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *sessionStorage) DeleteUserSessions(ctx context.Context, userID suid.SUID, keep string) error {
+	for id, session := range s.sessions {
+		if session.UserID == userID && id != keep {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// userStorage serves users and clients from memory.
+type userStorage struct {
+	clientStorage
+	users map[suid.SUID]*model.User
+}
+
+func (s *userStorage) GetUser(ctx context.Context, id suid.SUID) (*model.User, error) {
+	if u, ok := s.users[id]; ok {
+		return u, nil
+	}
+	return nil, xerr.ErrInvalidRequest
+}
+
+// tokenStorage keeps opaque access tokens in memory.
+type tokenStorage struct {
+	clientStorage
+	tokens map[string]*model.AccessToken
+}
+
+func (s *tokenStorage) GetToken(ctx context.Context, id string) (*model.AccessToken, error) {
+	if t, ok := s.tokens[id]; ok {
+		return t, nil
+	}
+	return nil, xerr.ErrInvalidRequest
+}
+
+func (s *tokenStorage) CreateToken(ctx context.Context, token *model.AccessToken) error {
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *tokenStorage) DeleteToken(ctx context.Context, token *model.AccessToken) error {
+	delete(s.tokens, token.ID)
+	return nil
+}
+
+// grantStorage keeps grants and refresh tokens in memory.
+type grantStorage struct {
+	sessionStorage
+	grants    map[string]*model.Grant
+	refreshes map[guid.GUID]model.RefreshToken
+}
+
+func (s *grantStorage) GetGrant(ctx context.Context, userID suid.SUID, clientID string) (*model.Grant, error) {
+	if g, ok := s.grants[userID.String()+clientID]; ok {
+		return g, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *grantStorage) SaveGrant(ctx context.Context, g *model.Grant) error {
+	if s.grants == nil {
+		s.grants = map[string]*model.Grant{}
+	}
+	s.grants[g.UserID.String()+g.ClientID] = g
+	return nil
+}
+
+func (s *grantStorage) GetRefresh(ctx context.Context, id guid.GUID) (model.RefreshToken, error) {
+	if rt, ok := s.refreshes[id]; ok {
+		return rt, nil
+	}
+	return model.RefreshToken{}, gorm.ErrRecordNotFound
+}
+
+func (s *grantStorage) CreateRefresh(ctx context.Context, rt model.RefreshToken) error {
+	s.refreshes[rt.ID] = rt
+	return nil
+}
+
+func (s *grantStorage) DeleteRefreshes(ctx context.Context, userID suid.SUID, clientID string) (int64, error) {
+	var n int64
+	for id, rt := range s.refreshes {
+		if rt.UserID == userID && rt.ClientID == clientID {
+			delete(s.refreshes, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *grantStorage) DeleteGrant(ctx context.Context, userID suid.SUID, clientID string) error {
+	delete(s.grants, userID.String()+clientID)
+	return nil
+}
+
+func (s *grantStorage) DeleteTokens(ctx context.Context, userID suid.SUID, clientID string) error {
+	return nil
+}
+
+// mfaStorage keeps a single user with the second factors in memory.
+type mfaStorage struct {
+	sessionStorage
+	user     *model.User
+	totp     *model.TOTP
+	codes    map[string]bool
+	passkeys map[string]*model.Passkey
+}
+
+func (s *mfaStorage) GetUser(ctx context.Context, id suid.SUID) (*model.User, error) {
+	return s.user, nil
+}
+
+func (s *mfaStorage) UpdateUser(ctx context.Context, user *model.User) error {
+	s.user = user
+	return nil
+}
+
+func (s *mfaStorage) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	if s.user.Email == nil || *s.user.Email != email {
+		return nil, xerr.ErrInvalidRequest
+	}
+	return s.user, nil
+}
+
+func (s *mfaStorage) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	if s.user.Username == nil || *s.user.Username != username {
+		return nil, xerr.ErrInvalidRequest
+	}
+	return s.user, nil
+}
+
+func (s *mfaStorage) GetTOTP(ctx context.Context, userID suid.SUID) (*model.TOTP, error) {
+	if s.totp == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	totp := *s.totp
+	return &totp, nil
+}
+
+func (s *mfaStorage) SaveTOTP(ctx context.Context, totp *model.TOTP) error {
+	s.totp = totp
+	return nil
+}
+
+func (s *mfaStorage) UseTOTPStep(ctx context.Context, userID suid.SUID, step int64) error {
+	if s.totp == nil || s.totp.LastStep >= step {
+		return gorm.ErrRecordNotFound
+	}
+	s.totp.LastStep = step
+	return nil
+}
+
+func (s *mfaStorage) UseRecoveryCode(ctx context.Context, userID suid.SUID, id string) error {
+	if !s.codes[id] {
+		return gorm.ErrRecordNotFound
+	}
+	delete(s.codes, id)
+	return nil
+}
+
+func (s *mfaStorage) GetPasskey(ctx context.Context, id string) (*model.Passkey, error) {
+	if p, ok := s.passkeys[id]; ok {
+		passkey := *p
+		return &passkey, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *mfaStorage) ListPasskeys(ctx context.Context, userID suid.SUID) ([]*model.Passkey, error) {
+	var passkeys []*model.Passkey
+	for _, p := range s.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (s *mfaStorage) CreatePasskey(ctx context.Context, passkey *model.Passkey) error {
+	return s.UpdatePasskey(ctx, passkey)
+}
+
+func (s *mfaStorage) UpdatePasskey(ctx context.Context, passkey *model.Passkey) error {
+	if s.passkeys == nil {
+		s.passkeys = map[string]*model.Passkey{}
+	}
+	s.passkeys[passkey.ID] = passkey
+	return nil
+}
+
+func (s *mfaStorage) DeletePasskey(ctx context.Context, userID suid.SUID, id string) error {
+	if p, ok := s.passkeys[id]; !ok || p.UserID != userID {
+		return gorm.ErrRecordNotFound
+	}
+	delete(s.passkeys, id)
+	return nil
+}
+
+// resourceStorage serves registered resources from memory.
+type resourceStorage struct {
+	model.Storage
+	resources map[string]*model.Resource
+}
+
+func (s *resourceStorage) GetResource(ctx context.Context, id string) (*model.Resource, error) {
+	if r, ok := s.resources[id]; ok {
+		return r, nil
+	}
+	return nil, xerr.ErrInvalidTarget
+}
+
+// failingStorage fails to create users like a database rejecting a duplicate.
+type failingStorage struct {
+	model.Storage
+}
+
+func (s *failingStorage) CreateUser(ctx context.Context, user *model.User) error {
+	return errors.New(`duplicate key value violates unique constraint "users_email_key"`)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+// sectorRefresh is how long a verified sector identifier document is trusted.
+const sectorRefresh = time.Hour
+
+// subjectFor returns the subject identifier of the user as seen by the client.
+// Pairwise subjects are recorded so they can be resolved back to the user.
+// https://openid.net/specs/openid-connect-core-1_0.html#PairwiseAlg
+func (s *server) subjectFor(ctx context.Context, client *model.Client, userID suid.SUID) (string, error) {
+	if client == nil || client.SubjectType != model.SubjectPairwise {
+		return userID.String(), nil
+	}
+	sector, err := client.Sector()
+	if err != nil {
+		return "", err
+	}
+	if err := s.verifySector(ctx, client); err != nil {
+		return "", err
+	}
+	// the space keeps sector and user apart, hosts and IDs never contain one
+	mac := hmac.New(sha256.New, []byte(s.pairwiseSalt))
+	mac.Write([]byte(sector + " " + userID.String()))
+	sub := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if _, err := s.db.GetPairwiseSubject(ctx, sub); err == nil {
+		return sub, nil
+	}
+	err = s.db.SavePairwiseSubject(ctx, &model.PairwiseSubject{
+		ID:        sub,
+		Sector:    sector,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return sub, nil
+}
+
+// verifySector checks that the sector identifier document of the client lists
+// every redirect URI of the client, so a client cannot join the sector of
+// another one. Clients are registered in the storage directly, hence the
+// document is fetched when the sector is used and trusted for sectorRefresh.
+// https://openid.net/specs/openid-connect-registration-1_0.html#SectorIdentifierValidation
+func (s *server) verifySector(ctx context.Context, client *model.Client) error {
+	if client.SectorIdentifierURI == "" {
+		return nil
+	}
+	uris, err := s.sectorCache.Get(client.SectorIdentifierURI)
+	if err != nil {
+		if uris, err = s.fetchSector(ctx, client.SectorIdentifierURI); err != nil {
+			return err
+		}
+		s.sectorCache.Set(client.SectorIdentifierURI, uris, sectorRefresh)
+	}
+	for _, uri := range client.RedirectURIs {
+		if !slices.Contains(uris, uri) {
+			return fmt.Errorf("sector identifier uri of client %s does not list %q", client.ID, uri)
+		}
+	}
+	return nil
+}
+
+// fetchSector downloads the JSON array of redirect URIs at the sector identifier URI.
+func (s *server) fetchSector(ctx context.Context, uri string) ([]string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "https" {
+		return nil, fmt.Errorf("sector identifier uri %q must use https", uri)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch sector identifier uri %q: %s", uri, resp.Status)
+	}
+	var uris []string
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&uris); err != nil {
+		return nil, fmt.Errorf("invalid sector identifier document at %q: %w", uri, err)
+	}
+	return uris, nil
+}
+
+// resolveSubject returns the user a public or pairwise subject identifier stands for.
+func (s *server) resolveSubject(ctx context.Context, sub string) (suid.SUID, error) {
+	if p, err := s.db.GetPairwiseSubject(ctx, sub); err == nil {
+		return p.UserID, nil
+	}
+	return suid.Parse(sub)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+func TestPairwiseSubject(t *testing.T) {
+	s := newTestServer()
+	db := &clientStorage{}
+	s.db = db
+	sector := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["https://other.example.com/cb"]`))
+	}))
+	defer sector.Close()
+	s.httpClient = sector.Client()
+	ctx := context.Background()
+	uid := suid.New()
+	app := &model.Client{ID: "app", SubjectType: model.SubjectPairwise, RedirectURIs: model.Strings{"https://app.example.com/cb"}}
+	admin := &model.Client{ID: "admin", SubjectType: model.SubjectPairwise, RedirectURIs: model.Strings{"https://app.example.com/admin/cb"}}
+	other := &model.Client{ID: "other", SubjectType: model.SubjectPairwise, SectorIdentifierURI: sector.URL + "/sector.json", RedirectURIs: model.Strings{"https://other.example.com/cb"}}
+
+	if sub, err := s.subjectFor(ctx, &model.Client{ID: "public"}, uid); err != nil || sub != uid.String() {
+		t.Errorf("public subject = %q, %v", sub, err)
+	}
+	sub, err := s.subjectFor(ctx, app, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub == uid.String() {
+		t.Error("pairwise subject must differ from the user ID")
+	}
+	if same, _ := s.subjectFor(ctx, admin, uid); same != sub {
+		t.Errorf("clients of one sector must share subjects: %q != %q", same, sub)
+	}
+	if diff, _ := s.subjectFor(ctx, other, uid); diff == sub {
+		t.Error("clients of different sectors must not share subjects")
+	}
+	created := db.subjects[sub].CreatedAt.Add(-time.Hour)
+	db.subjects[sub].CreatedAt = created
+	if again, _ := s.subjectFor(ctx, app, uid); again != sub || !db.subjects[sub].CreatedAt.Equal(created) {
+		t.Error("existing pairwise subject saved again")
+	}
+	if resolved, err := s.resolveSubject(ctx, sub); err != nil || resolved != uid {
+		t.Errorf("resolveSubject = %v, %v, want %v", resolved, err, uid)
+	}
+	app.RedirectURIs = append(app.RedirectURIs, "https://evil.example.com/cb")
+	if _, err := s.subjectFor(ctx, app, uid); err == nil {
+		t.Error("redirect uris on several hosts require a sector identifier uri")
+	}
+	other.RedirectURIs = append(other.RedirectURIs, "https://evil.example.com/cb")
+	if _, err := s.subjectFor(ctx, other, uid); err == nil {
+		t.Error("redirect uri missing from the sector identifier document accepted")
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-jose/go-jose/v4"
 	"github.com/go-jose/go-jose/v4/jwt"
 	"golang.org/x/crypto/bcrypt"
 	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/rar"
+	"sutext.github.io/entry/scope"
 	"sutext.github.io/entry/xerr"
+	"sutext.github.io/suid"
 	"sutext.github.io/suid/guid"
 )
 
@@ -22,39 +26,238 @@ func (s *server) handleToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "grant_type not supported", http.StatusBadRequest)
 		return
 	}
-	switch gtype {
-	case AuthorizationCode:
-		data, err := s.validateCodeGrant(r)
+	var account string
+	if gtype == PasswordCredentials {
+		account = s.passwordGrantAccount(r)
+	}
+	clientID := r.FormValue("client_id")
+	event := model.AuditTokenIssued
+	if gtype == Refreshing {
+		event = model.AuditTokenRefreshed
+	}
+	if retry, ok := s.throttleToken(r, account, clientID); !ok {
+		s.audit(r, event, model.AuditDenied, account, clientID, gtype.String()+": throttled")
+		s.tokenThrottled(w, retry)
+		return
+	}
+	// tokens requested with a DPoP proof are bound to its key
+	if r.Header.Get("DPoP") != "" {
+		jkt, err := s.verifyDPoPProof(r, "")
 		if err != nil {
-			s.tokenError(w, err)
+			s.audit(r, event, model.AuditFailure, account, clientID, gtype.String()+": "+xerr.ErrInvalidDPoPProof.Error())
+			s.tokenError(w, xerr.ErrInvalidDPoPProof)
 			return
 		}
-		s.token(w, data, nil, http.StatusOK)
+		r = withDPoP(r, jkt)
+	}
+	var data map[string]any
+	var err error
+	switch gtype {
+	case AuthorizationCode:
+		data, err = s.validateCodeGrant(r)
 	case Refreshing:
-		data, err := s.validateRefreshGrant(r)
-		if err != nil {
-			s.tokenError(w, err)
-			return
-		}
-		s.token(w, data, nil, http.StatusOK)
+		data, err = s.validateRefreshGrant(r)
 	case ClientCredentials:
-		data, err := s.validateClientCredentialsGrant(r)
-		if err != nil {
-			s.tokenError(w, err)
-			return
-		}
-		s.token(w, data, nil, http.StatusOK)
+		data, err = s.validateClientCredentialsGrant(r)
 	case PasswordCredentials:
-		data, err := s.validatePasswordCredentialsGrant(r)
-		if err != nil {
-			s.tokenError(w, err)
-			return
-		}
-		s.token(w, data, nil, http.StatusOK)
+		data, err = s.validatePasswordCredentialsGrant(r)
+	case TokenExchange:
+		data, err = s.validateTokenExchangeGrant(r)
+	case JWTBearer:
+		data, err = s.validateJWTBearerGrant(r)
 	default:
 		http.Error(w, "grant_type not supported", http.StatusBadRequest)
 		return
 	}
+	if err != nil {
+		s.audit(r, event, model.AuditFailure, account, clientID, gtype.String()+": "+auditReason(err))
+		s.tokenError(w, err)
+		return
+	}
+	s.token(w, data, nil, http.StatusOK)
+}
+
+// authenticateClient loads the client of the token request and checks that it
+// is active, allowed to use the grant type and presents valid credentials.
+// Confidential clients always have to authenticate, and so does every client
+// using the client credentials grant.
+func (s *server) authenticateClient(r *http.Request, gt GrantType) (*model.Client, error) {
+	client, err := s.verifyClient(r, gt == ClientCredentials)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrantType(gt.String()) {
+		return nil, xerr.ErrUnauthorizedClient
+	}
+	return client, nil
+}
+
+// verifyClient loads the active client of the request and checks its
+// credentials, which public clients only have to present when requireSecret is
+// set. Confidential clients must also connect from one of their trusted peers.
+func (s *server) verifyClient(r *http.Request, requireSecret bool) (*model.Client, error) {
+	clientID := r.FormValue("client_id")
+	if clientID == "" {
+		return nil, xerr.ErrInvalidClient
+	}
+	client, err := s.db.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, xerr.ErrInvalidClient
+	}
+	if !client.Active() {
+		return nil, xerr.ErrInvalidClient
+	}
+	if !client.IsPublic() || requireSecret {
+		clientSecret := r.FormValue("client_secret")
+		if clientSecret == "" {
+			return nil, xerr.ErrUnauthorizedClient
+		}
+		if client.Secret != clientSecret {
+			return nil, xerr.ErrUnauthorizedClient
+		}
+	}
+	if !client.IsPublic() {
+		if !s.checkTrustedPeer(client.TrustedPeers, r.RemoteAddr) {
+			return nil, xerr.ErrUnauthorizedClient
+		}
+	}
+	return client, nil
+}
+
+// accessTokenClaims are the claims of the access tokens issued by this server.
+type accessTokenClaims struct {
+	jwt.Claims
+	ClientID             string        `json:"client_id,omitempty"`
+	Scope                string        `json:"scope,omitempty"`
+	Actor                *actorClaim   `json:"act,omitempty"`
+	AuthTime             int64         `json:"auth_time,omitempty"`
+	Acr                  string        `json:"acr,omitempty"`
+	Amr                  []string      `json:"amr,omitempty"`
+	AuthorizationDetails rar.Details   `json:"authorization_details,omitempty"`
+	Confirmation         *confirmation `json:"cnf,omitempty"`
+	// Claims are the claims requested individually for the userinfo response.
+	RequestedClaims *model.ClaimsRequest `json:"claims,omitempty"`
+}
+
+// parseToken verifies a JWT access token issued by this server and returns its
+// claims. ID tokens are signed with the same key and are rejected by their type.
+func (s *server) parseToken(token string) (*accessTokenClaims, error) {
+	tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		return nil, err
+	}
+	if tok.Headers[0].ExtraHeaders[jose.HeaderType] != "at+jwt" {
+		return nil, xerr.ErrInvalidAccessToken
+	}
+	var claims accessTokenClaims
+	if err = tok.Claims(s.secret, &claims); err != nil {
+		return nil, err
+	}
+	if err = claims.Validate(jwt.Expected{Issuer: s.issuer, Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// accessTokenFormat returns the format of the access tokens issued to the client.
+func (s *server) accessTokenFormat(client *model.Client) model.AccessTokenFormat {
+	if client != nil && client.AccessTokenFormat != "" {
+		return client.AccessTokenFormat
+	}
+	return s.defaultAccessTokenFormat
+}
+
+// generateAccessToken issues the access token described by the request, either
+// as a JWT following the JWT profile for OAuth 2.0 access tokens or as an opaque
+// token. Custom claims of the AccessTokenClaimsHandler never replace the claims
+// set by the server.
+// https://www.rfc-editor.org/rfc/rfc9068
+func (s *server) generateAccessToken(tgr *TokenGenerateRequest) (string, error) {
+	claims, custom, err := s.accessTokenClaims(tgr)
+	if err != nil {
+		return "", err
+	}
+	if s.accessTokenFormat(tgr.Client) == model.AccessTokenOpaque {
+		return s.generateOpaqueToken(tgr, claims, custom)
+	}
+	builder := jwt.Signed(s.accessTokenSigner)
+	if custom != nil {
+		// later claims take precedence
+		builder = builder.Claims(custom)
+	}
+	return builder.Claims(claims).Serialize()
+}
+
+func (s *server) accessTokenClaims(tgr *TokenGenerateRequest) (accessTokenClaims, map[string]any, error) {
+	ctx := tgr.context()
+	sub := tgr.Subject()
+	if uid, err := suid.Parse(tgr.UserID); err == nil {
+		if sub, err = s.subjectFor(ctx, tgr.Client, uid); err != nil {
+			return accessTokenClaims{}, nil, err
+		}
+	}
+	now := time.Now()
+	claims := accessTokenClaims{
+		Claims: jwt.Claims{
+			ID:       guid.New().String(),
+			Issuer:   s.issuer,
+			Subject:  sub,
+			Audience: tgr.Audience,
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(tgr.AccessTokenExp)),
+		},
+		ClientID:             tgr.ClientID,
+		Scope:                tgr.Scope,
+		Actor:                tgr.actor,
+		AuthorizationDetails: tgr.AuthorizationDetails,
+	}
+	if !tgr.AuthTime.IsZero() {
+		claims.AuthTime = tgr.AuthTime.Unix()
+		claims.Acr = tgr.Acr
+		claims.Amr = tgr.Amr
+	}
+	if len(tgr.Claims.UserInfo) > 0 {
+		claims.RequestedClaims = &model.ClaimsRequest{UserInfo: tgr.Claims.UserInfo}
+	}
+	if jkt := dpopThumbprint(ctx); jkt != "" {
+		claims.Confirmation = &confirmation{JKT: jkt}
+	}
+	if s.accessTokenClaimsHandler == nil {
+		return claims, nil, nil
+	}
+	custom, err := s.accessTokenClaimsHandler(ctx, tgr)
+	return claims, custom, err
+}
+
+// getTokenData returns the token response parameters describing the access token.
+func (s *server) getTokenData(tgr *TokenGenerateRequest, accessToken string) map[string]any {
+	data := map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(tgr.AccessTokenExp / time.Second),
+	}
+	if dpopThumbprint(tgr.context()) != "" {
+		data["token_type"] = "DPoP"
+	}
+	if tgr.Scope != "" {
+		data["scope"] = tgr.Scope
+	}
+	if len(tgr.AuthorizationDetails) > 0 {
+		data["authorization_details"] = tgr.AuthorizationDetails
+	}
+	return data
+}
+func (s *server) accessTokenTTL(client *model.Client) time.Duration {
+	if client != nil && client.AccessTokenTTL > 0 {
+		return client.AccessTokenTTL
+	}
+	return s.accessTokenDuration
+}
+func (s *server) refreshTokenTTL(client *model.Client) time.Duration {
+	if client != nil && client.RefreshTokenTTL > 0 {
+		return client.RefreshTokenTTL
+	}
+	return s.refreshTokenDuration
 }
 func (s *server) validateCodeGrant(r *http.Request) (data map[string]any, err error) {
 	ctx := r.Context()
@@ -66,69 +269,103 @@ func (s *server) validateCodeGrant(r *http.Request) (data map[string]any, err er
 	if code == "" {
 		return data, xerr.ErrInvalidAuthorizeCode
 	}
-	codeVerifier := r.FormValue("code_verifier")
-	if codeVerifier == "" {
-		return data, xerr.ErrMissingCodeChallenge
-	}
-	clientID := r.FormValue("client_id")
-	if clientID == "" {
-		return data, xerr.ErrInvalidClient
-	}
-	client, err := s.db.GetClient(ctx, clientID)
+	client, err := s.authenticateClient(r, AuthorizationCode)
 	if err != nil {
 		return data, err
 	}
+	clientID := client.ID
 	if !client.RedirectURIs.Contains(redirectURI) {
 		return data, xerr.ErrInvalidRedirectURI
 	}
-	if !client.Public {
-		clientSecret := r.FormValue("client_secret")
-		if clientSecret == "" {
-			return data, xerr.ErrUnauthorizedClient
-		}
-		if client.Secret != clientSecret {
-			return data, xerr.ErrUnauthorizedClient
+	codeReq, err := s.codeCache.Get(code)
+	if err != nil {
+		return data, xerr.ErrInvalidGrant
+	}
+	s.codeCache.Delete(code)
+	if codeReq.ClientID != clientID || codeReq.RedirectURI != redirectURI {
+		return data, xerr.ErrInvalidGrant
+	}
+	if codeReq.CodeChallenge != "" {
+		codeVerifier := r.FormValue("code_verifier")
+		if codeVerifier == "" {
+			return data, xerr.ErrMissingCodeVerifier
 		}
-		if !s.checkTrustedPeer(client.TrustedPeers, r.RemoteAddr) {
-			return data, xerr.ErrUnauthorizedClient
+		if !codeReq.CodeChallengeMethod.Validate(codeReq.CodeChallenge, codeVerifier) {
+			return data, xerr.ErrInvalidCodeChallenge
 		}
 	}
-	codeReq, err := s.codeCache.Get(code)
+	resources, err := narrowResources(codeReq.Resources, r.Form["resource"])
 	if err != nil {
 		return data, err
 	}
-	s.codeCache.Delete(code)
-	if !codeReq.CodeChallengeMethod.Validate(codeReq.CodeChallenge, codeVerifier) {
-		return data, xerr.ErrInvalidCodeChallenge
+	details, err := s.narrowAuthorizationDetails(codeReq.AuthorizationDetails, r.FormValue("authorization_details"))
+	if err != nil {
+		return data, err
 	}
 	refreshToken := model.RefreshToken{
-		ID:        guid.New(),
-		ClientID:  clientID,
-		UserID:    codeReq.UserID,
-		Scope:     codeReq.Scope,
-		ExpiryIn:  time.Now().Add(s.refreshTokenDuration),
-		CreatedAt: time.Now(),
-		LastUsed:  time.Now(),
+		ID:                   guid.New(),
+		ClientID:             clientID,
+		UserID:               codeReq.UserID,
+		Scope:                codeReq.Scope,
+		Resources:            codeReq.Resources,
+		AuthorizationDetails: codeReq.AuthorizationDetails,
+		Claims:               codeReq.Claims,
+		Nonce:                codeReq.Nonce,
+		AuthTime:             codeReq.AuthTime,
+		Acr:                  codeReq.Acr,
+		Amr:                  codeReq.Amr,
+		SessionID:            codeReq.SessionID,
+		ExpiryIn:             time.Now().Add(s.refreshTokenTTL(client)),
+		CreatedAt:            time.Now(),
+		LastUsed:             time.Now(),
+	}
+	// refresh tokens of public clients are bound to the DPoP key, confidential
+	// clients authenticate instead
+	// https://www.rfc-editor.org/rfc/rfc9449#section-5
+	if client.IsPublic() {
+		refreshToken.JKT = dpopThumbprint(ctx)
 	}
 	if err = s.db.CreateRefresh(ctx, refreshToken); err != nil {
 		return data, err
 	}
-	claims := jwt.Claims{
-		Subject:  codeReq.UserID.String(),
-		Audience: []string{clientID},
-		Expiry:   jwt.NewNumericDate(time.Now().Add(s.accessTokenDuration)),
-		IssuedAt: jwt.NewNumericDate(time.Now()),
-	}
-	accessToken, err := jwt.Signed(s.signer).Claims(claims).Serialize()
+	tgr := &TokenGenerateRequest{
+		GrantType:            AuthorizationCode,
+		ClientID:             clientID,
+		Client:               client,
+		UserID:               codeReq.UserID.String(),
+		Scope:                codeReq.Scope,
+		Audience:             tokenAudience(resources, clientID),
+		AuthTime:             codeReq.AuthTime,
+		Acr:                  codeReq.Acr,
+		Amr:                  codeReq.Amr,
+		AuthorizationDetails: details,
+		Claims:               codeReq.Claims,
+		AccessTokenExp:       s.accessTokenTTL(client),
+		Request:              r,
+	}
+	accessToken, err := s.generateAccessToken(tgr)
 	if err != nil {
 		return data, err
 	}
-	data = map[string]any{
-		"refresh_token": refreshToken.ID.String(),
-		"expires_in":    claims.Expiry,
-		"token_type":    "Bearer",
-		"access_token":  accessToken,
+	data = s.getTokenData(tgr, accessToken)
+	data["refresh_token"] = refreshToken.ID.String()
+	if wantsIDToken(codeReq.Scope) {
+		idToken, err := s.createIDToken(ctx, idTokenRequest{
+			UserID:    codeReq.UserID,
+			Client:    client,
+			Claims:    codeReq.Claims.IDToken,
+			Nonce:     codeReq.Nonce,
+			AuthTime:  codeReq.AuthTime,
+			Acr:       codeReq.Acr,
+			Amr:       codeReq.Amr,
+			SessionID: codeReq.SessionID,
+		})
+		if err != nil {
+			return data, err
+		}
+		data["id_token"] = idToken
 	}
+	s.audit(r, model.AuditTokenIssued, model.AuditSuccess, codeReq.UserID.String(), clientID, AuthorizationCode.String())
 	return data, nil
 }
 func (s *server) validateRefreshGrant(r *http.Request) (data map[string]any, err error) {
@@ -137,29 +374,15 @@ func (s *server) validateRefreshGrant(r *http.Request) (data map[string]any, err
 	if err != nil {
 		return data, xerr.ErrInvalidRefreshToken
 	}
-	clientID := r.FormValue("client_id")
-	if clientID == "" {
-		return data, xerr.ErrInvalidClient
-	}
-	client, err := s.db.GetClient(ctx, clientID)
+	client, err := s.authenticateClient(r, Refreshing)
 	if err != nil {
 		return data, err
 	}
-	if !client.Public {
-		clientSecret := r.FormValue("client_secret")
-		if clientSecret == "" {
-			return data, xerr.ErrUnauthorizedClient
-		}
-		if client.Secret != clientSecret {
-			return data, xerr.ErrUnauthorizedClient
-		}
-		if !s.checkTrustedPeer(client.TrustedPeers, r.RemoteAddr) {
-			return data, xerr.ErrUnauthorizedClient
-		}
-	}
+	clientID := client.ID
 	rt, err := s.db.GetRefresh(ctx, refreshToken)
 	if err != nil {
-		return data, err
+		// revoked tokens are gone
+		return data, xerr.ErrInvalidRefreshToken
 	}
 	if rt.ClientID != clientID {
 		return data, xerr.ErrUnauthorizedClient
@@ -167,87 +390,171 @@ func (s *server) validateRefreshGrant(r *http.Request) (data map[string]any, err
 	if rt.ExpiryIn.Before(time.Now()) {
 		return data, xerr.ErrExpiredRefreshToken
 	}
-	claims := jwt.Claims{
-		Subject:  rt.UserID.String(),
-		Audience: []string{clientID},
-		Expiry:   jwt.NewNumericDate(time.Now().Add(s.accessTokenDuration)),
-		IssuedAt: jwt.NewNumericDate(time.Now()),
+	if rt.JKT != "" && rt.JKT != dpopThumbprint(ctx) {
+		return data, xerr.ErrInvalidDPoPProof
 	}
-	accessToken, err := jwt.Signed(s.signer).Claims(claims).Serialize()
+	// signing out ends the tokens of the session, offline access outlives it
+	if !scope.Parse(rt.Scope).Contains(scope.OfflineAccess) && !s.sessionAlive(ctx, rt.SessionID) {
+		return data, xerr.ErrInvalidGrant
+	}
+	// the access token may be narrowed to some of the granted resources
+	resources, err := narrowResources(rt.Resources, r.Form["resource"])
 	if err != nil {
 		return data, err
 	}
-	data = map[string]any{
-		"refresh_token": rt.ID.String(),
-		"expires_in":    claims.Expiry,
-		"token_type":    "Bearer",
-		"access_token":  accessToken,
+	details, err := s.narrowAuthorizationDetails(rt.AuthorizationDetails, r.FormValue("authorization_details"))
+	if err != nil {
+		return data, err
+	}
+	tgr := &TokenGenerateRequest{
+		GrantType:            Refreshing,
+		ClientID:             clientID,
+		Client:               client,
+		UserID:               rt.UserID.String(),
+		Scope:                rt.Scope,
+		Audience:             tokenAudience(resources, clientID),
+		AuthTime:             rt.AuthTime,
+		Acr:                  rt.Acr,
+		Amr:                  rt.Amr,
+		AuthorizationDetails: details,
+		Claims:               rt.Claims,
+		AccessTokenExp:       s.accessTokenTTL(client),
+		Request:              r,
+	}
+	accessToken, err := s.generateAccessToken(tgr)
+	if err != nil {
+		return data, err
+	}
+	data = s.getTokenData(tgr, accessToken)
+	data["refresh_token"] = rt.ID.String()
+	if wantsIDToken(rt.Scope) {
+		// the nonce and auth_time of the original authentication are preserved
+		idToken, err := s.createIDToken(ctx, idTokenRequest{
+			UserID:    rt.UserID,
+			Client:    client,
+			Claims:    rt.Claims.IDToken,
+			Nonce:     rt.Nonce,
+			AuthTime:  rt.AuthTime,
+			Acr:       rt.Acr,
+			Amr:       rt.Amr,
+			SessionID: rt.SessionID,
+		})
+		if err != nil {
+			return data, err
+		}
+		data["id_token"] = idToken
 	}
+	s.audit(r, model.AuditTokenRefreshed, model.AuditSuccess, rt.UserID.String(), clientID, "")
 	return data, nil
 }
 func (s *server) validateClientCredentialsGrant(r *http.Request) (data map[string]any, err error) {
-	ctx := r.Context()
-	clientID := r.FormValue("client_id")
-	if clientID == "" {
-		return data, xerr.ErrInvalidClient
-	}
-	client, err := s.db.GetClient(ctx, clientID)
+	client, err := s.authenticateClient(r, ClientCredentials)
 	if err != nil {
 		return data, err
 	}
-	clientSecret := r.FormValue("client_secret")
-	if clientSecret == "" {
-		return data, xerr.ErrUnauthorizedClient
-	}
-	if client.Secret != clientSecret {
-		return data, xerr.ErrUnauthorizedClient
-	}
-	if !client.Public {
-		if !s.checkTrustedPeer(client.TrustedPeers, r.RemoteAddr) {
-			return data, xerr.ErrUnauthorizedClient
-		}
-	}
-	claims := jwt.Claims{
-		Subject:  clientID,
-		Expiry:   jwt.NewNumericDate(time.Now().Add(s.accessTokenDuration)),
-		IssuedAt: jwt.NewNumericDate(time.Now()),
+	clientID := client.ID
+	resources := r.Form["resource"]
+	if err := s.checkResources(r.Context(), resources, r.FormValue("scope")); err != nil {
+		return data, err
 	}
-	accessToken, err := jwt.Signed(s.signer).Claims(claims).Serialize()
+	details, err := s.parseAuthorizationDetails(client, r.FormValue("authorization_details"))
 	if err != nil {
 		return data, err
 	}
-	data = map[string]any{
-		"token_type":   "Bearer",
-		"access_token": accessToken,
+	tgr := &TokenGenerateRequest{
+		GrantType:            ClientCredentials,
+		ClientID:             clientID,
+		Client:               client,
+		Scope:                r.FormValue("scope"),
+		Audience:             tokenAudience(resources, clientID),
+		AuthorizationDetails: details,
+		AccessTokenExp:       s.accessTokenTTL(client),
+		Request:              r,
+	}
+	accessToken, err := s.generateAccessToken(tgr)
+	if err != nil {
+		return data, err
 	}
+	data = s.getTokenData(tgr, accessToken)
+	s.audit(r, model.AuditTokenIssued, model.AuditSuccess, "", clientID, ClientCredentials.String())
 	return data, nil
 }
+
+// passwordGrantAccount returns the lockout account of the user named in the
+// Basic credentials of a password grant.
+func (s *server) passwordGrantAccount(r *http.Request) string {
+	username, _, _ := r.BasicAuth()
+	user, err := s.db.GetUserByUsername(r.Context(), username)
+	if err != nil {
+		return lockoutAccount(nil, username)
+	}
+	return lockoutAccount(user, username)
+}
+
 func (s *server) validatePasswordCredentialsGrant(r *http.Request) (data map[string]any, err error) {
 	ctx := r.Context()
+	client, err := s.authenticateClient(r, PasswordCredentials)
+	if err != nil {
+		return data, err
+	}
 	username, password, ok := r.BasicAuth()
 	if !ok {
 		return data, xerr.ErrUnauthorizedClient
 	}
 	user, err := s.db.GetUserByUsername(ctx, username)
 	if err != nil {
+		s.limiter.fail(lockoutAccount(nil, username), time.Now())
 		return data, err
 	}
+	account := lockoutAccount(user, username)
 	if err = bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
+		s.limiter.fail(account, time.Now())
 		return data, xerr.ErrUnauthorizedClient
 	}
-	claims := jwt.Claims{
-		Subject:  user.ID.String(),
-		Expiry:   jwt.NewNumericDate(time.Now().Add(s.accessTokenDuration)),
-		IssuedAt: jwt.NewNumericDate(time.Now()),
+	// the password grant has no second step, a user with a TOTP authenticator
+	// sends a code along with the password. Passkeys cannot be presented to
+	// the token endpoint, users with passkeys only cannot use the grant.
+	amr := []string{AMRPassword}
+	switch {
+	case s.totpEnrolled(ctx, user.ID):
+		otp := r.FormValue("otp")
+		if otp == "" {
+			return data, xerr.ErrMFARequired
+		}
+		if !s.verifySecondFactor(ctx, user.ID, otp) {
+			s.limiter.fail(account, time.Now())
+			return data, xerr.ErrMFARequired
+		}
+		amr = withMethod(amr, AMROTP)
+	case s.mfaEnrolled(ctx, user.ID):
+		return data, xerr.ErrInvalidGrant
+	case client.RequireMFA:
+		return data, xerr.ErrMFARequired
+	}
+	s.limiter.succeed(account)
+	resources := r.Form["resource"]
+	if err := s.checkResources(ctx, resources, r.FormValue("scope")); err != nil {
+		return data, err
 	}
-	accessToken, err := jwt.Signed(s.signer).Claims(claims).Serialize()
+	tgr := &TokenGenerateRequest{
+		GrantType:      PasswordCredentials,
+		ClientID:       client.ID,
+		Client:         client,
+		UserID:         user.ID.String(),
+		Scope:          r.FormValue("scope"),
+		Audience:       tokenAudience(resources, client.ID),
+		AuthTime:       time.Now(),
+		Acr:            acrOf(amr),
+		Amr:            amr,
+		AccessTokenExp: s.accessTokenTTL(client),
+		Request:        r,
+	}
+	accessToken, err := s.generateAccessToken(tgr)
 	if err != nil {
 		return data, err
 	}
-	data = map[string]any{
-		"token_type":   "Bearer",
-		"access_token": accessToken,
-	}
+	data = s.getTokenData(tgr, accessToken)
+	s.audit(r, model.AuditTokenIssued, model.AuditSuccess, user.ID.String(), client.ID, PasswordCredentials.String())
 	return data, nil
 }
 
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+func TestGenerateAccessToken(t *testing.T) {
+	s := New(
+		WithIssuerURL("http://localhost:8080"),
+		WithAccessTokenClaimsHandler(func(ctx context.Context, tgr *TokenGenerateRequest) (map[string]any, error) {
+			return map[string]any{"roles": []string{"admin"}, "sub": "spoofed"}, nil
+		}),
+	).(*server)
+	authTime := time.Now().Add(-time.Minute)
+	token, err := s.generateAccessToken(&TokenGenerateRequest{
+		GrantType:      AuthorizationCode,
+		ClientID:       "client",
+		UserID:         "user",
+		Scope:          "openid read",
+		Audience:       []string{"https://api.example.com"},
+		AuthTime:       authTime,
+		AccessTokenExp: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.EdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := tok.Headers[0].ExtraHeaders[jose.HeaderType]; typ != "at+jwt" {
+		t.Errorf("typ = %v, want at+jwt", typ)
+	}
+	var claims struct {
+		accessTokenClaims
+		Roles []string `json:"roles"`
+	}
+	if err := tok.Claims(s.secret, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "http://localhost:8080" || claims.ID == "" || claims.ClientID != "client" {
+		t.Errorf("missing required claims: %+v", claims)
+	}
+	if claims.Subject != "user" {
+		t.Errorf("sub = %q, custom claims must not replace it", claims.Subject)
+	}
+	if claims.Scope != "openid read" || claims.AuthTime != authTime.Unix() {
+		t.Errorf("unexpected scope or auth_time: %+v", claims)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "admin" {
+		t.Errorf("roles = %v", claims.Roles)
+	}
+}
+
+func TestTokenIssuer(t *testing.T) {
+	s := New(WithIssuerURL("HTTP://LOCALHOST:8080/")).(*server)
+	s.db = &clientStorage{clients: map[string]*model.Client{"svc": {
+		ID:           "svc",
+		Type:         model.ClientTypeConfidential,
+		Secret:       "secret",
+		Scopes:       model.Strings{"read"},
+		TrustedPeers: model.Strings{"192.0.2.1:1234"},
+	}}}
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"svc"}, "client_secret": {"secret"}, "scope": {"read"}}
+	r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.handleToken(w, r)
+	var resp map[string]any
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token status = %d: %v", w.Code, resp)
+	}
+	claims, err := s.parseToken(resp["access_token"].(string))
+	if err != nil {
+		t.Fatalf("issued token rejected: %v", err)
+	}
+	if claims.Issuer != "http://localhost:8080" {
+		t.Errorf("iss = %q", claims.Issuer)
+	}
+	idToken, err := s.createIDToken(t.Context(), idTokenRequest{UserID: suid.New(), Client: &model.Client{ID: "svc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.parseToken(idToken); err == nil {
+		t.Error("ID token accepted as access token")
+	}
+}
+
+func TestRefreshDPoPBinding(t *testing.T) {
+	s, _, _, userID, _ := authorizeFlow(t)
+	s.codeCache.Set("code", &AuthorizeRequest{ClientID: "spa", RedirectURI: "https://spa.example.com/cb", UserID: userID, Scope: "openid"}, time.Minute)
+	_, key, _ := ed25519.GenerateKey(nil)
+	token := func(form url.Values, key ed25519.PrivateKey) (int, map[string]any) {
+		r := httptest.NewRequest("POST", "http://localhost:8080/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if key != nil {
+			r.Header.Set("DPoP", dpopProof(t, key, "POST", "http://localhost:8080/oauth/token", ""))
+		}
+		w := httptest.NewRecorder()
+		s.handleToken(w, r)
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	code, resp := token(url.Values{"grant_type": {"authorization_code"}, "client_id": {"spa"}, "code": {"code"}, "redirect_uri": {"https://spa.example.com/cb"}}, key)
+	if code != http.StatusOK {
+		t.Fatalf("code grant status = %d: %v", code, resp)
+	}
+	refresh := url.Values{"grant_type": {"refresh_token"}, "client_id": {"spa"}, "refresh_token": {resp["refresh_token"].(string)}}
+	if code, resp := token(refresh, nil); code != http.StatusBadRequest && code != http.StatusUnauthorized {
+		t.Errorf("refresh without proof status = %d: %v", code, resp)
+	}
+	_, other, _ := ed25519.GenerateKey(nil)
+	if code, resp := token(refresh, other); code != http.StatusBadRequest && code != http.StatusUnauthorized {
+		t.Errorf("refresh with another key status = %d: %v", code, resp)
+	}
+	if code, resp := token(refresh, key); code != http.StatusOK || resp["token_type"] != "DPoP" {
+		t.Errorf("refresh with the bound key status = %d: %v", code, resp)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TOTP parameters, the defaults of RFC 6238 every authenticator app supports.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of steps a code may be behind or ahead of the
+	// server time, allowing for clock drift and slow typing.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random base32 encoded secret of 160 bits, the size
+// of an HMAC-SHA1 key recommended by RFC 4226.
+func newTOTPSecret() string {
+	key := make([]byte, 20)
+	rand.Read(key)
+	return totpEncoding.EncodeToString(key)
+}
+
+// totpStep returns the time step of the time.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode returns the code of the secret for the time step.
+// https://www.rfc-editor.org/rfc/rfc4226#section-5.3
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// matchTOTP returns the time step within the skew whose code matches, the
+// steps up to last are not accepted again.
+func matchTOTP(secret, code string, last int64, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the provisioning URI of the secret, which authenticator apps
+// scan as a QR code.
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func totpURI(issuer, account, secret string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+	}
+	u.RawQuery = url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}.Encode()
+	return u.String()
+}
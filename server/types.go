@@ -1,54 +1,187 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/rar"
 	"sutext.github.io/suid"
 )
 
+// TokenGenerateRequest describes an access token about to be issued.
+// It is passed to the AccessTokenClaimsHandler to derive custom claims.
 type TokenGenerateRequest struct {
-	ClientID            string
-	ClientSecret        string
-	UserID              string
-	RedirectURI         string
-	Scope               string
-	Code                string
-	CodeChallenge       string
-	CodeChallengeMethod CodeChallengeMethod
-	Refresh             string
-	CodeVerifier        string
-	AccessTokenExp      time.Duration
-	Request             *http.Request
+	GrantType GrantType
+	ClientID  string
+	// Client the token is issued to, it is nil when no client is involved.
+	Client *model.Client
+	// UserID is the end-user the token is issued for, it is empty for tokens
+	// issued to the client itself.
+	UserID               string
+	Scope                string
+	Audience             []string
+	AuthTime             time.Time
+	Acr                  string
+	Amr                  []string
+	AuthorizationDetails rar.Details
+	// Claims requested with the claims request parameter.
+	Claims         model.ClaimsRequest
+	AccessTokenExp time.Duration
+	Request        *http.Request
+	// actor is the act claim of delegated tokens
+	actor *actorClaim
+}
+
+// Subject returns who the token is issued for: the user, or the client when no
+// user is involved. The sub claim is the pairwise subject of the user for
+// clients using pairwise subjects.
+func (tgr *TokenGenerateRequest) Subject() string {
+	if tgr.UserID != "" {
+		return tgr.UserID
+	}
+	return tgr.ClientID
 }
+
+func (tgr *TokenGenerateRequest) context() context.Context {
+	if tgr.Request != nil {
+		return tgr.Request.Context()
+	}
+	return context.Background()
+}
+
 type AuthorizeRequest struct {
-	ID                  string
-	ResponseType        ResponseType
-	ClientID            string
-	UserID              suid.SUID
-	Scope               string
-	RedirectURI         string
-	State               string
+	ID                   string
+	ResponseType         ResponseType
+	ResponseMode         ResponseMode
+	ClientID             string
+	UserID               suid.SUID
+	Scope                string
+	Resources            []string
+	AuthorizationDetails rar.Details
+	Claims               model.ClaimsRequest
+	RedirectURI          string
+	State                string
+	Nonce                string
+	AuthTime             time.Time
+	Acr                  string
+	Amr                  []string
+	// SessionID is the browser session the user authorized the request in.
+	SessionID string
+	// ACRValues are the requested authentication context classes.
+	ACRValues           []string
+	Prompt              Prompt
+	MaxAge              *int64
+	LoginHint           string
+	HintUserID          suid.SUID
+	CreatedAt           time.Time
 	CodeChallenge       string
 	CodeChallengeMethod CodeChallengeMethod
 }
 
+// Prompt the space separated prompt values of an authorization request
+type Prompt []string
+
+const (
+	PromptNone          = "none"
+	PromptLogin         = "login"
+	PromptConsent       = "consent"
+	PromptSelectAccount = "select_account"
+)
+
+func ParsePrompt(prompt string) Prompt {
+	return Prompt(strings.Fields(prompt))
+}
+func (p Prompt) Has(value string) bool {
+	return slices.Contains(p, value)
+}
+
 // ResponseType the type of authorization request
 type ResponseType string
 
 // define the type of authorization request
 const (
-	ResponseTypeCode  ResponseType = "code"
-	ResponseTypeToken ResponseType = "token"
+	ResponseTypeCode             ResponseType = "code"
+	ResponseTypeToken            ResponseType = "token"
+	ResponseTypeIDToken          ResponseType = "id_token"
+	ResponseTypeIDTokenToken     ResponseType = "id_token token"
+	ResponseTypeCodeIDToken      ResponseType = "code id_token"
+	ResponseTypeCodeToken        ResponseType = "code token"
+	ResponseTypeCodeIDTokenToken ResponseType = "code id_token token"
 )
 
+var responseTypeOrder = []string{"code", "id_token", "token"}
+
+// ParseResponseType normalizes the space separated response type values, so that
+// "token id_token" and "id_token token" are the same response type. It returns an
+// empty response type for unknown or repeated values.
+func ParseResponseType(v string) ResponseType {
+	values := strings.Fields(v)
+	for _, value := range values {
+		if !slices.Contains(responseTypeOrder, value) {
+			return ""
+		}
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		return slices.Index(responseTypeOrder, a) - slices.Index(responseTypeOrder, b)
+	})
+	if len(slices.Compact(values)) != len(strings.Fields(v)) {
+		return ""
+	}
+	return ResponseType(strings.Join(values, " "))
+}
+
 func (rt ResponseType) String() string {
 	return string(rt)
 }
 
+// Has reports whether the response type includes the value, e.g. "code" or "id_token".
+func (rt ResponseType) Has(value string) bool {
+	return slices.Contains(strings.Fields(string(rt)), value)
+}
+
+// Implicit reports whether the response type returns tokens from the authorization endpoint.
+func (rt ResponseType) Implicit() bool {
+	return rt.Has("token") || rt.Has("id_token")
+}
+
+// ResponseMode how the authorization response is delivered to the client
+type ResponseMode string
+
+const (
+	ResponseModeQuery    ResponseMode = "query"
+	ResponseModeFragment ResponseMode = "fragment"
+	ResponseModeFormPost ResponseMode = "form_post"
+	// JWT Secured Authorization Response Modes
+	ResponseModeQueryJWT    ResponseMode = "query.jwt"
+	ResponseModeFragmentJWT ResponseMode = "fragment.jwt"
+	ResponseModeFormPostJWT ResponseMode = "form_post.jwt"
+	ResponseModeJWT         ResponseMode = "jwt"
+)
+
+// JWT reports whether the response is wrapped in a signed JWT.
+func (m ResponseMode) JWT() bool {
+	return m == ResponseModeJWT || strings.HasSuffix(string(m), ".jwt")
+}
+
+// Base returns the mode delivering the parameters, e.g. query for query.jwt.
+func (m ResponseMode) Base() ResponseMode {
+	return ResponseMode(strings.TrimSuffix(string(m), ".jwt"))
+}
+
+// DefaultResponseMode returns the mode used when the request has no response_mode.
+func (rt ResponseType) DefaultResponseMode() ResponseMode {
+	if rt.Implicit() {
+		return ResponseModeFragment
+	}
+	return ResponseModeQuery
+}
+
 // GrantType authorization model
 type GrantType string
 
@@ -58,6 +191,8 @@ const (
 	PasswordCredentials GrantType = "password"
 	ClientCredentials   GrantType = "client_credentials"
 	Refreshing          GrantType = "refresh_token"
+	TokenExchange       GrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	JWTBearer           GrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
 	Implicit            GrantType = "__implicit"
 )
 
@@ -65,7 +200,9 @@ func (gt GrantType) String() string {
 	if gt == AuthorizationCode ||
 		gt == PasswordCredentials ||
 		gt == ClientCredentials ||
-		gt == Refreshing {
+		gt == Refreshing ||
+		gt == TokenExchange ||
+		gt == JWTBearer {
 		return string(gt)
 	}
 	return ""
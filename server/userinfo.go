@@ -2,28 +2,238 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/scope"
+	"sutext.github.io/entry/xerr"
 )
 
+// scopeClaims are the standard claims released by each scope.
+// https://openid.net/specs/openid-connect-core-1_0.html#ScopeClaims
+var scopeClaims = map[string][]string{
+	scope.Profile: {
+		"name", "family_name", "given_name", "middle_name", "nickname", "preferred_username",
+		"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at",
+	},
+	scope.Email:   {"email", "email_verified"},
+	scope.Phone:   {"phone_number", "phone_number_verified"},
+	scope.Address: {"address"},
+}
+
+// standardClaims returns the standard claims known about the user. Attributes
+// without a standard claim, like weight or height, are never released.
+// https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
+func standardClaims(user *model.User) map[string]any {
+	claims := map[string]any{
+		"updated_at": user.UpdatedAt.Unix(),
+	}
+	if user.Username != nil {
+		claims["preferred_username"] = *user.Username
+	}
+	if user.Nickname != nil {
+		claims["nickname"] = *user.Nickname
+	}
+	if user.Avatar != nil {
+		claims["picture"] = *user.Avatar
+	}
+	if user.Gender != model.GenderUnknown {
+		claims["gender"] = user.Gender.String()
+	}
+	if user.Birthday != nil {
+		claims["birthdate"] = user.Birthday.Format("2006-01-02")
+	}
+	if user.Email != nil {
+		claims["email"] = *user.Email
+	}
+	if user.Phone != nil {
+		claims["phone_number"] = *user.Phone
+	}
+	return claims
+}
+
+// handleUserInfo returns the claims about the end-user an access token with
+// the openid scope grants access to.
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
 func (s *server) handleUserInfo(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		s.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	userID, err := s.ensureLoggedIn(r)
+	ctx := r.Context()
+	token, scheme, err := accessTokenFromRequest(r)
+	if err != nil || token == "" {
+		s.bearerError(w, scheme, err)
+		return
+	}
+	claims, _, err := s.validateAccessToken(ctx, token)
+	if err != nil {
+		s.bearerError(w, scheme, xerr.ErrInvalidToken)
+		return
+	}
+	// DPoP bound tokens need a proof of the bound key, bearer tokens must not claim one
+	if claims.Confirmation != nil {
+		if scheme != "DPoP" {
+			s.bearerError(w, scheme, xerr.ErrInvalidToken)
+			return
+		}
+		jkt, err := s.verifyDPoPProof(r, token)
+		if err != nil || jkt != claims.Confirmation.JKT {
+			s.bearerError(w, scheme, xerr.ErrInvalidDPoPProof)
+			return
+		}
+	} else if scheme == "DPoP" {
+		s.bearerError(w, scheme, xerr.ErrInvalidToken)
+		return
+	}
+	scopes := scope.Parse(claims.Scope)
+	if !scopes.Contains(scope.OpenID) {
+		s.bearerError(w, scheme, xerr.ErrInsufficientScope)
+		return
+	}
+	userID, err := s.resolveSubject(ctx, claims.Subject)
 	if err != nil {
-		s.writeError(w, http.StatusUnauthorized, err.Error())
+		s.bearerError(w, scheme, xerr.ErrInvalidToken)
 		return
 	}
-	user, err := s.db.GetUser(r.Context(), userID)
+	user, err := s.db.GetUser(ctx, userID)
+	if err != nil {
+		s.bearerError(w, scheme, xerr.ErrInvalidToken)
+		return
+	}
+	// the response format depends on the client, tokens of removed clients are dead
+	client, err := s.db.GetClient(ctx, claims.ClientID)
+	if err != nil || !client.Active() {
+		s.bearerError(w, scheme, xerr.ErrInvalidToken)
+		return
+	}
+	var requested map[string]*model.ClaimRequest
+	if claims.RequestedClaims != nil {
+		requested = claims.RequestedClaims.UserInfo
+	}
+	info, err := s.userClaims(ctx, user, client, scopes, requested)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	resp := user.ToView()
-	enc := json.NewEncoder(w)
-	if err := enc.Encode(resp); err != nil {
+	// the subject is the one of the token, pairwise or not
+	info["sub"] = claims.Subject
+	s.writeUserInfo(w, client, info)
+}
+
+// accessTokenFromRequest returns the access token of a protected resource
+// request and the scheme it is presented with. Tokens in the form body use the
+// Bearer scheme. Presenting the token more than once is an invalid request.
+// https://www.rfc-editor.org/rfc/rfc6750#section-2
+func accessTokenFromRequest(r *http.Request) (token, scheme string, err error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, _ = strings.Cut(auth, " ")
+		switch {
+		case strings.EqualFold(scheme, "Bearer"):
+			scheme = "Bearer"
+		case strings.EqualFold(scheme, "DPoP"):
+			scheme = "DPoP"
+		default:
+			return "", "", xerr.ErrInvalidRequest
+		}
+	}
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form := r.PostFormValue("access_token"); form != "" {
+			if token != "" {
+				return "", scheme, xerr.ErrInvalidRequest
+			}
+			return form, "Bearer", nil
+		}
+	}
+	return strings.TrimSpace(token), scheme, nil
+}
+
+// bearerError answers a protected resource request with the WWW-Authenticate
+// challenge of the scheme. A nil error stands for a request without token.
+// https://www.rfc-editor.org/rfc/rfc6750#section-3
+func (s *server) bearerError(w http.ResponseWriter, scheme string, err error) {
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	var params []string
+	if scheme == "DPoP" {
+		algs := make([]string, len(dpopSigningAlgs))
+		for i, alg := range dpopSigningAlgs {
+			algs[i] = string(alg)
+		}
+		params = append(params, fmt.Sprintf("algs=%q", strings.Join(algs, " ")))
+	}
+	status := http.StatusUnauthorized
+	if err != nil {
+		params = append(params, fmt.Sprintf("error=%q", err.Error()), fmt.Sprintf("error_description=%q", xerr.Descriptions[err]))
+		switch err {
+		case xerr.ErrInvalidRequest:
+			status = http.StatusBadRequest
+		case xerr.ErrInsufficientScope:
+			status = http.StatusForbidden
+		}
+	}
+	challenge := scheme
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+}
+
+// writeUserInfo writes the claims as JSON, or as a signed and/or encrypted JWT
+// when the client registered the algorithms for it.
+func (s *server) writeUserInfo(w http.ResponseWriter, client *model.Client, info map[string]any) {
+	w.Header().Set("Cache-Control", "no-store")
+	if client == nil || (client.UserinfoSignedResponseAlg == "" && client.UserinfoEncryptedResponseAlg == "") {
+		s.writeJSON(w, http.StatusOK, info)
+		return
+	}
+	signed := client.UserinfoSignedResponseAlg != ""
+	if signed {
+		info["iss"] = s.issuer
+		info["aud"] = client.ID
+	}
+	payload, err := json.Marshal(info)
+	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	body := string(payload)
+	if signed {
+		if client.UserinfoSignedResponseAlg != string(jose.EdDSA) {
+			s.writeError(w, http.StatusInternalServerError, "unsupported userinfo signing alg "+client.UserinfoSignedResponseAlg)
+			return
+		}
+		jws, err := s.signer.Sign(payload)
+		if err == nil {
+			body, err = jws.CompactSerialize()
+		}
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if client.UserinfoEncryptedResponseAlg != "" {
+		enc, err := responseEncrypter(client, client.UserinfoEncryptedResponseAlg, client.UserinfoEncryptedResponseEnc, signed)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		jwe, err := enc.Encrypt([]byte(body))
+		if err == nil {
+			body, err = jwe.CompactSerialize()
+		}
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/jwt")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(body))
 }
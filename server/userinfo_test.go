@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"sutext.github.io/entry/model"
+	"sutext.github.io/suid"
+)
+
+// dpopProof signs a DPoP proof for the request with the key.
+func dpopProof(t *testing.T, key ed25519.PrivateKey, method, uri, accessToken string) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: key}, &jose.SignerOptions{
+		EmbedJWK:     true,
+		ExtraHeaders: map[jose.HeaderKey]any{jose.HeaderType: "dpop+jwt"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := dpopClaims{ID: rand.Text(), Method: method, URI: uri, IssuedAt: jwt.NewNumericDate(time.Now())}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims.AccessTokenHash = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	proof, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestUserInfo(t *testing.T) {
+	s := newTestServer()
+	email, nickname := "user@example.com", "user"
+	user := model.NewUser()
+	user.Email = &email
+	user.Nickname = &nickname
+	user.Weight = 70000
+	s.db = &userStorage{
+		clientStorage: clientStorage{clients: map[string]*model.Client{"spa": {ID: "spa"}}},
+		users:         map[suid.SUID]*model.User{user.ID: user},
+	}
+	issue := func(scope string) string {
+		token, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "spa", UserID: user.ID.String(), Scope: scope, AccessTokenExp: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	get := func(auth string, dpop string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://localhost:8080/oauth/userinfo", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		if dpop != "" {
+			r.Header.Set("DPoP", dpop)
+		}
+		w := httptest.NewRecorder()
+		s.handleUserInfo(w, r)
+		return w
+	}
+
+	if w := get("", ""); w.Code != 401 || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("without token: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := get("Bearer "+issue("read"), ""); w.Code != 403 || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) {
+		t.Errorf("without openid: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	w := get("Bearer "+issue("openid profile"), "")
+	if w.Code != 200 {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var info map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info["sub"] != user.ID.String() || info["nickname"] != "user" {
+		t.Errorf("missing claims: %v", info)
+	}
+	if _, ok := info["email"]; ok {
+		t.Errorf("email released without the email scope: %v", info)
+	}
+	if _, ok := info["weight"]; ok {
+		t.Errorf("weight released: %v", info)
+	}
+	removed, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "removed", UserID: user.ID.String(), Scope: "openid", AccessTokenExp: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get("Bearer "+removed, ""); w.Code != 401 {
+		t.Errorf("token of an unknown client: %d", w.Code)
+	}
+
+	_, key, _ := ed25519.GenerateKey(nil)
+	r := httptest.NewRequest("POST", "http://localhost:8080/oauth/token", nil)
+	r.Header.Set("DPoP", dpopProof(t, key, "POST", "http://localhost:8080/oauth/token", ""))
+	jkt, err := s.verifyDPoPProof(r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound, err := s.generateAccessToken(&TokenGenerateRequest{ClientID: "spa", UserID: user.ID.String(), Scope: "openid email", AccessTokenExp: time.Hour, Request: withDPoP(r, jkt)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get("Bearer "+bound, ""); w.Code != 401 {
+		t.Errorf("bound token as bearer: %d", w.Code)
+	}
+	if w := get("DPoP "+bound, dpopProof(t, key, "GET", "http://localhost:8080/oauth/userinfo", "other")); w.Code != 401 ||
+		!strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_dpop_proof"`) {
+		t.Errorf("proof for another token: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := get("DPoP "+bound, dpopProof(t, key, "GET", "http://localhost:8080/oauth/userinfo", bound)); w.Code != 200 || !strings.Contains(w.Body.String(), email) {
+		t.Errorf("dpop request: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -15,23 +15,26 @@ import Register from './Register';
 import Profile from './Profile';
 import { useState } from 'react';
 import Root from './Root';
-import { getToken } from './Service';
+import { isSignedIn } from './Service';
 
 const Protected = ({ children, isAuthenticated }: { children: React.ReactNode, isAuthenticated: boolean }) => {
   const [searchParams] = useSearchParams();
   const reqid = searchParams.get('reqid') || '';
+  const loginHint = searchParams.get('login_hint') || '';
   if (!isAuthenticated) {    
     if (reqid === '') {
       return <Navigate to='/login' replace />;
-    } else {
+    } else if (loginHint === '') {
       return <Navigate to={`/login?reqid=${reqid}`} replace />;
+    } else {
+      return <Navigate to={`/login?reqid=${reqid}&login_hint=${encodeURIComponent(loginHint)}`} replace />;
     }
   }
   return children;
 };
 
 const AppContent = () => {
-  const [isAuthenticated, setIsAuthenticated] = useState(getToken() !== '');
+  const [isAuthenticated, setIsAuthenticated] = useState(isSignedIn());
   const { pathname } = useLocation();
   const isFullscreenLayout = pathname === '/' || pathname === '/profile' || !['/', '/login', '/register', '/approve', '/profile'].includes(pathname);
   return (
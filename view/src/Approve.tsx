@@ -8,29 +8,37 @@ import {
   Smartphone 
 } from 'lucide-react';
 import { cardBaseStyles, Footer } from './Widgets';
-import { preview, ServerError} from './Service';
+import { preview, ServerError, type AuthorizationDetail } from './Service';
 
 const Approve = () => {
   const navigate = useNavigate();
   const [searchParams] = useSearchParams();
   const [isLoading, setIsLoading] = useState(false);
+  const [details, setDetails] = useState<AuthorizationDetail[]>([]);
+  const [claims, setClaims] = useState<string[]>([]);
   const reqid = searchParams.get('reqid') || '';
   useEffect(() => {
     if (reqid === '') {
       return;
     }
     preview(reqid).then(data => {
+      if (data.redirect) {
+        window.location.replace(data.redirect);
+        return;
+      }
       setIsLoading(false);
-      console.log(data);
+      setDetails(data.authorization_details || []);
+      setClaims(data.claims || []);
     }).catch((err) => {
       setIsLoading(false);
       if (err instanceof ServerError && err.status === 401) {
-        navigate('/login?reqid='+reqid);
+        const loginHint = searchParams.get('login_hint') || '';
+        navigate('/login?reqid='+reqid+(loginHint === '' ? '' : '&login_hint='+encodeURIComponent(loginHint)));
       } else {
         console.error('授权失败:', err);
       }
     });
-  });
+  }, [reqid]);
   if (reqid === '') {
     return <Navigate to='/profile' replace />;
   }
@@ -74,6 +82,31 @@ const Approve = () => {
               <p className="text-slate-500 text-xs">仅用于身份验证和通知</p>
             </div>
           </li>
+          {claims.length > 0 && (
+            <li className="flex items-start space-x-3">
+              <div className="mt-1 bg-amber-100 rounded-full p-0.5">
+                <CheckCircle2 className="w-4 h-4 text-amber-600" />
+              </div>
+              <div className="text-sm min-w-0">
+                <p className="font-medium text-slate-700">读取您的其他信息</p>
+                <p className="text-slate-500 text-xs break-all">{claims.join(', ')}</p>
+              </div>
+            </li>
+          )}
+          {details.map((detail, i) => {
+            const { type, ...rest } = detail;
+            return (
+              <li key={i} className="flex items-start space-x-3">
+                <div className="mt-1 bg-amber-100 rounded-full p-0.5">
+                  <CheckCircle2 className="w-4 h-4 text-amber-600" />
+                </div>
+                <div className="text-sm min-w-0">
+                  <p className="font-medium text-slate-700">{type}</p>
+                  <pre className="text-slate-500 text-xs whitespace-pre-wrap break-all">{JSON.stringify(rest, null, 2)}</pre>
+                </div>
+              </li>
+            );
+          })}
         </ul>
       </div>
       <input type="hidden" name="reqid" value={reqid} />
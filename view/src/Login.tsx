@@ -6,32 +6,63 @@ import {
   ChevronRight, 
   ShieldCheck, 
   User, 
+  KeyRound,
+  Fingerprint,
 } from 'lucide-react';
 import { cardBaseStyles, Footer } from './Widgets';
-import { login } from './Service';
+import { login, loginMFA, loginPasskey, UserInfo } from './Service';
 const Login = ({ onLogin }: { onLogin: () => void }) => {
   const navigate = useNavigate();
   const [isLoading, setIsLoading] = useState(false);
-  const [email, setEmail] = useState('');
-  const [password, setPassword] = useState('');
   const [searchParams] = useSearchParams();
+  const [email, setEmail] = useState(searchParams.get('login_hint') || '');
+  const [password, setPassword] = useState('');
+  const [mfaToken, setMfaToken] = useState('');
+  const [mfaMethods, setMfaMethods] = useState<string[]>([]);
+  const [code, setCode] = useState('');
+  const finish = () => {
+    setIsLoading(false);
+    onLogin();
+    const reqid = searchParams.get('reqid') || '';
+    if (reqid === '') {
+        navigate('/profile');
+    } else {
+        navigate(`/approve?reqid=${reqid}`);
+    }
+  };
   const handleLogin = (e:React.SubmitEvent<HTMLFormElement>) => {
     e.preventDefault();
     setIsLoading(true);
-    login({ email, password }).then(() => {
-      setIsLoading(false);
-      onLogin();
-      const reqid = searchParams.get('reqid') || '';
-      if (reqid === '') {
-          navigate('/profile');
-      } else {
-          navigate(`/approve?reqid=${reqid}`);
+    if (mfaToken !== '') {
+      loginMFA(mfaToken, code).then(finish).catch(() => {
+        setIsLoading(false);
+        setCode('');
+        alert('验证码无效，请重试。');
+      });
+      return;
+    }
+    login({ email, password }).then((result) => {
+      if (result instanceof UserInfo) {
+        finish();
+        return;
       }
+      // the account has a second factor, ask for its code
+      setIsLoading(false);
+      setMfaToken(result.mfaToken);
+      setMfaMethods(result.methods);
     }).catch(() => {
       setIsLoading(false);
       alert('登录失败，请重试。');
     });
   };
+  // without a pending login the passkey is the only factor
+  const handlePasskey = () => {
+    setIsLoading(true);
+    loginPasskey(mfaToken).then(finish).catch(() => {
+      setIsLoading(false);
+      alert('通行密钥验证失败，请重试。');
+    });
+  };
 
   return (
     <div className={`${cardBaseStyles} animate-in fade-in slide-in-from-bottom-4 duration-700`}>
@@ -44,6 +75,29 @@ const Login = ({ onLogin }: { onLogin: () => void }) => {
       </div>
 
       <form onSubmit={handleLogin} className="space-y-5">
+        {mfaToken !== '' ? (
+        <div className="space-y-2">
+          {!mfaMethods.includes('totp') && (
+            <p className="text-sm text-slate-500 ml-1">请使用您的通行密钥完成验证</p>
+          )}
+          {mfaMethods.includes('totp') && (<>
+          <label className="text-sm font-medium text-slate-700 ml-1">验证码</label>
+          <div className="relative">
+            <KeyRound className="absolute left-4 top-1/2 -translate-y-1/2 text-slate-400 w-5 h-5" />
+            <input 
+              required
+              autoFocus
+              type="text" 
+              autoComplete="one-time-code"
+              placeholder="身份验证器中的 6 位验证码或恢复码"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              className="w-full pl-12 pr-4 py-3 bg-slate-50 border border-slate-200 rounded-xl focus:outline-none focus:ring-2 focus:ring-blue-400 focus:bg-white transition-all"
+            />
+          </div>
+          </>)}
+        </div>
+        ) : (<>
         <div className="space-y-2">
           <label className="text-sm font-medium text-slate-700 ml-1">电子邮箱</label>
           <div className="relative">
@@ -81,7 +135,9 @@ const Login = ({ onLogin }: { onLogin: () => void }) => {
           </label>
           <a href="#" className="text-blue-500 hover:text-blue-600 font-medium transition-colors">忘记密码？</a>
         </div>
+        </>)}
 
+        {(mfaToken === '' || mfaMethods.includes('totp')) && (
         <button 
           disabled={isLoading}
           type="submit" 
@@ -96,6 +152,18 @@ const Login = ({ onLogin }: { onLogin: () => void }) => {
             </>
           )}
         </button>
+        )}
+        {(mfaToken === '' || mfaMethods.includes('passkey')) && (
+        <button 
+          disabled={isLoading}
+          type="button" 
+          onClick={handlePasskey}
+          className="w-full bg-white hover:bg-slate-50 text-slate-700 font-semibold py-3.5 rounded-xl border border-slate-200 transition-all flex items-center justify-center space-x-2 active:scale-[0.98] disabled:opacity-70"
+        >
+          <Fingerprint className="w-5 h-5" />
+          <span>{mfaToken === '' ? '使用通行密钥登录' : '使用通行密钥验证'}</span>
+        </button>
+        )}
       </form>
 
       <div className="mt-8 pt-8 border-t border-slate-100 flex flex-col items-center">
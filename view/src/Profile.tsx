@@ -1,13 +1,14 @@
 import { useEffect, useState } from "react";
 import { useNavigate } from "react-router-dom";
 import {  Footer } from "./Widgets";
-import { ArrowLeft, Calendar, Camera, LogOut, Mail, Phone, Ruler, Shield, User, Weight, type LucideProps } from "lucide-react";
+import { ArrowLeft, Calendar, Camera, Fingerprint, LogOut, Mail, Phone, Plus, Ruler, Shield, Trash2, User, Weight, type LucideProps } from "lucide-react";
 import React from "react";
-import { profile, ServerError, UserInfo } from "./Service";
+import { deletePasskey, listPasskeys, logout, profile, registerPasskey, ServerError, UserInfo, type Passkey } from "./Service";
 
 const Profile = ({ onLogout }: { onLogout: () => void }) => {
   const navigate = useNavigate();
   const [user, setUser] = useState(new UserInfo({}));
+  const [passkeys, setPasskeys] = useState<Passkey[]>([]);
   useEffect(() => {
     profile().then(setUser).catch((err) => {
       if (err instanceof ServerError && err.status === 401) {
@@ -16,7 +17,35 @@ const Profile = ({ onLogout }: { onLogout: () => void }) => {
         console.error('获取用户信息失败:', err);
       }
     });
+    listPasskeys().then(setPasskeys).catch((err) => console.error('获取通行密钥失败:', err));
   },[]);
+  // withStepUp retries a change of the passkeys with a code of the
+  // authenticator app when the server asks for the second factor.
+  const withStepUp = <T,>(change: (code?: string) => Promise<T>) => change().catch((err) => {
+    if (!(err instanceof ServerError) || err.status !== 403) {
+      throw err;
+    }
+    const code = prompt('请输入验证器中的验证码；未启用验证器时，请重新登录后再试');
+    if (!code) {
+      throw err;
+    }
+    return change(code);
+  });
+  const addPasskey = () => {
+    const name = prompt('为这个通行密钥命名', '通行密钥');
+    if (name === null) {
+      return;
+    }
+    withStepUp((code) => registerPasskey(name, code)).then((p) => setPasskeys([...passkeys, p])).catch((err) => {
+      console.error('创建通行密钥失败:', err);
+      alert('创建通行密钥失败，请重试。');
+    });
+  };
+  const removePasskey = (id: string) => {
+    withStepUp((code) => deletePasskey(id, code)).then(() => setPasskeys(passkeys.filter((p) => p.id !== id))).catch((err) => {
+      console.error('删除通行密钥失败:', err);
+    });
+  };
   return (
     <div className="w-full min-h-screen bg-slate-50/50 flex flex-col animate-in fade-in duration-500">
       {/* Top Header */}
@@ -27,7 +56,7 @@ const Profile = ({ onLogout }: { onLogout: () => void }) => {
           </button>
           <h1 className="text-lg font-bold text-slate-800">个人账号设置</h1>
         </div>
-        <button onClick={onLogout} className="flex items-center space-x-2 text-sm font-semibold text-red-500 hover:bg-red-50 px-4 py-2 rounded-full transition-all">
+        <button onClick={() => logout().catch((err) => console.error('退出登录失败:', err)).finally(onLogout)} className="flex items-center space-x-2 text-sm font-semibold text-red-500 hover:bg-red-50 px-4 py-2 rounded-full transition-all">
           <LogOut className="w-4 h-4" />
           <span>安全退出</span>
         </button>
@@ -83,6 +112,35 @@ const Profile = ({ onLogout }: { onLogout: () => void }) => {
             </div>
           </div>
 
+          <div className="bg-white rounded-[2rem] border border-slate-100 shadow-sm overflow-hidden">
+            <div className="px-8 py-6 border-b border-slate-50 flex justify-between items-center">
+              <h3 className="font-bold text-slate-800">通行密钥</h3>
+              <button onClick={addPasskey} className="flex items-center text-blue-500 text-sm font-bold hover:underline">
+                <Plus className="w-4 h-4 mr-1" />
+                添加
+              </button>
+            </div>
+            {passkeys.length === 0 ? (
+              <p className="px-8 py-6 text-sm text-slate-400">使用指纹、面容或安全密钥代替密码登录。</p>
+            ) : passkeys.map((p) => (
+              <div key={p.id} className="px-8 py-4 border-b border-slate-50 last:border-0 flex items-center justify-between">
+                <div className="flex items-center space-x-4">
+                  <Fingerprint className="w-5 h-5 text-slate-400" />
+                  <div>
+                    <p className="text-base font-bold text-slate-700">{p.name}</p>
+                    <p className="text-xs text-slate-400">
+                      创建于 {new Date(p.created_at).toLocaleDateString()}
+                      {p.last_used_at && ` · 最近使用 ${new Date(p.last_used_at).toLocaleString()}`}
+                    </p>
+                  </div>
+                </div>
+                <button onClick={() => removePasskey(p.id)} className="p-2 text-slate-400 hover:text-red-500 rounded-full">
+                  <Trash2 className="w-4 h-4" />
+                </button>
+              </div>
+            ))}
+          </div>
+
           <div className="bg-white rounded-[2rem] border border-slate-100 shadow-sm overflow-hidden">
             <div className="px-8 py-6 border-b border-slate-50">
               <h3 className="font-bold text-slate-800">身体健康数据</h3>
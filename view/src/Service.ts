@@ -26,9 +26,9 @@ export type RegisterFormData = {
   email: string;
   password: string;
 };
-const TokenKey = 'token';
-export const clearToken = () => localStorage.removeItem(TokenKey);
-export const getToken = () => localStorage.getItem(TokenKey) || '';
+// The session lives in an HttpOnly cookie, only a hint that the user signed in is kept here.
+const SignedInKey = 'signedIn';
+export const isSignedIn = () => localStorage.getItem(SignedInKey) === '1';
 export const register = async (formData: RegisterFormData) => {
   const res = await fetch('/register', {
         method: 'POST',
@@ -39,7 +39,7 @@ export const register = async (formData: RegisterFormData) => {
     });
     if (res.status === 200) {
         return res.json().then((data) => {
-            localStorage.setItem(TokenKey, data.token);
+            localStorage.setItem(SignedInKey, '1');
             return new UserInfo(data.user);
         });
     } else {
@@ -50,7 +50,13 @@ export type LoginFormData = {
   email: string;
   password: string;
 };
-export const login = async (formData: LoginFormData) => {
+// MFAChallenge is returned by login when the account has a second factor,
+// the login completes with loginMFA.
+export type MFAChallenge = {
+  mfaToken: string;
+  methods: string[];
+};
+export const login = async (formData: LoginFormData): Promise<UserInfo | MFAChallenge> => {
     const res = await fetch('/login', {
         method: 'POST',
         headers: {
@@ -60,18 +66,183 @@ export const login = async (formData: LoginFormData) => {
     });
     if (res.status === 200) {
         return res.json().then((data) => {
-            localStorage.setItem(TokenKey, data.token);
+            if (data.mfa_required) {
+                return { mfaToken: data.mfa_token, methods: data.mfa_methods || [] };
+            }
+            localStorage.setItem(SignedInKey, '1');
+            return new UserInfo(data.user);
+        });
+    } else {
+        throw new ServerError(res.status, res.statusText);
+    }
+}
+export const loginMFA = async (mfaToken: string, code: string) => {
+    const res = await fetch('/login/mfa', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ mfa_token: mfaToken, code }),
+    });
+    if (res.status === 200) {
+        return res.json().then((data) => {
+            localStorage.setItem(SignedInKey, '1');
             return new UserInfo(data.user);
         });
     } else {
         throw new ServerError(res.status, res.statusText);
     }
 }
+// WebAuthn options and responses carry binary data as unpadded base64url.
+const fromBase64URL = (s: string) => {
+    const b64 = s.replace(/-/g, '+').replace(/_/g, '/');
+    return Uint8Array.from(atob(b64 + '='.repeat((4 - b64.length % 4) % 4)), (c) => c.charCodeAt(0));
+};
+const toBase64URL = (buf: ArrayBuffer | null) => {
+    if (!buf) {
+        return undefined;
+    }
+    const s = String.fromCharCode(...new Uint8Array(buf));
+    return btoa(s).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+};
+const postJSON = (url: string, body?: unknown) => fetch(url, {
+    method: 'POST',
+    headers: {
+        'Content-Type': 'application/json',
+    },
+    body: body === undefined ? undefined : JSON.stringify(body),
+});
+// loginPasskey signs in with a passkey, as the only factor or, given the token
+// of a login waiting for the second factor, after the password.
+export const loginPasskey = async (mfaToken = '') => {
+    const res = await postJSON('/login/passkey/options', { mfa_token: mfaToken });
+    if (res.status !== 200) {
+        throw new ServerError(res.status, res.statusText);
+    }
+    const opts = await res.json();
+    const cred = await navigator.credentials.get({
+        publicKey: {
+            ...opts,
+            challenge: fromBase64URL(opts.challenge),
+            allowCredentials: (opts.allowCredentials || []).map((c: { type: 'public-key', id: string }) => ({ ...c, id: fromBase64URL(c.id) })),
+        },
+    }) as PublicKeyCredential;
+    const resp = cred.response as AuthenticatorAssertionResponse;
+    const login = await postJSON('/login/passkey', {
+        mfa_token: mfaToken,
+        credential: {
+            id: cred.id,
+            rawId: toBase64URL(cred.rawId),
+            type: cred.type,
+            response: {
+                clientDataJSON: toBase64URL(resp.clientDataJSON),
+                authenticatorData: toBase64URL(resp.authenticatorData),
+                signature: toBase64URL(resp.signature),
+                userHandle: toBase64URL(resp.userHandle),
+            },
+        },
+    });
+    if (login.status !== 200) {
+        throw new ServerError(login.status, login.statusText);
+    }
+    const data = await login.json();
+    localStorage.setItem(SignedInKey, '1');
+    return new UserInfo(data.user);
+};
+export type Passkey = {
+  id: string;
+  name: string;
+  created_at: string;
+  last_used_at?: string;
+};
+export const listPasskeys = async () => {
+    const res = await fetch('/profile/passkeys');
+    if (res.status !== 200) {
+        throw new ServerError(res.status, res.statusText);
+    }
+    return (await res.json() || []) as Passkey[];
+};
+// registerPasskey adds a passkey; users with an authenticator app confirm
+// the change with a code.
+export const registerPasskey = async (name: string, code = '') => {
+    const res = await postJSON('/profile/passkeys/options');
+    if (res.status !== 200) {
+        throw new ServerError(res.status, res.statusText);
+    }
+    const opts = await res.json();
+    const cred = await navigator.credentials.create({
+        publicKey: {
+            ...opts,
+            challenge: fromBase64URL(opts.challenge),
+            user: { ...opts.user, id: fromBase64URL(opts.user.id) },
+            excludeCredentials: (opts.excludeCredentials || []).map((c: { type: 'public-key', id: string }) => ({ ...c, id: fromBase64URL(c.id) })),
+        },
+    }) as PublicKeyCredential;
+    const resp = cred.response as AuthenticatorAttestationResponse;
+    const created = await postJSON('/profile/passkeys', {
+        name,
+        code,
+        credential: {
+            id: cred.id,
+            rawId: toBase64URL(cred.rawId),
+            type: cred.type,
+            response: {
+                clientDataJSON: toBase64URL(resp.clientDataJSON),
+                attestationObject: toBase64URL(resp.attestationObject),
+                transports: resp.getTransports ? resp.getTransports() : [],
+            },
+        },
+    });
+    if (created.status !== 201) {
+        throw new ServerError(created.status, created.statusText);
+    }
+    return await created.json() as Passkey;
+};
+export const deletePasskey = async (id: string, code = '') => {
+    const res = await fetch(`/profile/passkeys/${id}`, {
+        method: 'DELETE',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ code }),
+    });
+    if (res.status !== 204) {
+        throw new ServerError(res.status, res.statusText);
+    }
+};
+export const logout = async () => {
+    localStorage.removeItem(SignedInKey);
+    const res = await fetch('/logout', {
+        method: 'POST',
+    });
+    if (res.status === 204) {
+        return;
+    }
+    if (res.status !== 200) {
+        throw new ServerError(res.status, res.statusText);
+    }
+    // sign out of the clients using front-channel logout in hidden iframes
+    const data = await res.json();
+    const uris: string[] = data.frontchannel_logout_uris || [];
+    await Promise.all(uris.map((uri) => new Promise<void>((resolve) => {
+        const frame = document.createElement('iframe');
+        frame.style.display = 'none';
+        frame.src = uri;
+        frame.onload = () => resolve();
+        setTimeout(resolve, 3000);
+        document.body.appendChild(frame);
+    })));
+};
+export type AuthorizationDetail = {
+  type: string;
+  [key: string]: unknown;
+}
 export type PreviewResponse = {
   scopes: string[];
   clientID: string;
   clientName: string;
   clientLogo: string;
+  authorization_details?: AuthorizationDetail[];
+  claims?: string[];
+  redirect?: string;
 }
 export class ServerError extends Error {
     status: number;
@@ -81,15 +252,10 @@ export class ServerError extends Error {
     }
 }
 export const preview = async (reqid: string)=>{
-    const token = getToken()
-    if (token === '') {
-        throw new Error('请先登录。');
-    }
     const res = await fetch(`/oauth/authorize/preview?reqid=${reqid}`, {
         method: 'GET',
         headers: {
             'Content-Type': 'application/json',
-            'Authorization': `Bearer ${token}`,
         },
     });
     if (res.status === 200) {
@@ -102,15 +268,10 @@ export const preview = async (reqid: string)=>{
 
 }
 export const profile = async () => {
-    const token = getToken();
-    if (token === '') {
-        throw new Error('请先登录。');
-    }
     const res = await fetch('/profile', {
         method: 'GET',
         headers: {
             'Content-Type': 'application/json',
-            'Authorization': `Bearer ${token}`,
         },
     });
     if (res.status === 200) {
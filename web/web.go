@@ -36,6 +36,8 @@ const (
 	tmplApproval      = "approval.html"
 	tmplPassword      = "password.html"
 	tmplDeviceSuccess = "device_success.html"
+	tmplFormPost      = "form_post.html"
+	tmplLogout        = "logout.html"
 )
 
 var requiredTmpls = []string{
@@ -46,6 +48,8 @@ var requiredTmpls = []string{
 	tmplApproval,
 	tmplPassword,
 	tmplDeviceSuccess,
+	tmplFormPost,
+	tmplLogout,
 }
 
 type Config struct {
@@ -321,6 +325,48 @@ func (s *WebSite) RenderOOB(r *http.Request, w http.ResponseWriter, code string)
 	return renderTemplate(w, s.templates[tmplOOB], data)
 }
 
+// RenderFormPost renders a page which auto-submits params to action,
+// see https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html
+func (s *WebSite) RenderFormPost(w http.ResponseWriter, action string, params map[string]string) error {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	data := struct {
+		Action string
+		Params map[string]string
+	}{action, params}
+	return renderTemplate(w, s.templates[tmplFormPost], data)
+}
+
+// RenderLogoutConfirm renders the page asking the user to confirm the logout,
+// it posts params back to postURL.
+func (s *WebSite) RenderLogoutConfirm(r *http.Request, w http.ResponseWriter, clientName, postURL string, params map[string]string) error {
+	w.Header().Set("Cache-Control", "no-store")
+	data := struct {
+		Client  string
+		Confirm bool
+		PostURL string
+		Params  map[string]string
+		ReqPath string
+	}{clientName, true, postURL, params, r.URL.Path}
+	return renderTemplate(w, s.templates[tmplLogout], data)
+}
+
+// RenderLoggedOut renders the page telling the user they signed out. It loads
+// the front-channel logout URIs in hidden iframes, then continues to the
+// redirect URI if there is one.
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html#OPLogout
+func (s *WebSite) RenderLoggedOut(r *http.Request, w http.ResponseWriter, clientName string, frontchannelURIs []string, redirectURI string) error {
+	w.Header().Set("Cache-Control", "no-store")
+	data := struct {
+		Client       string
+		Confirm      bool
+		Frontchannel []string
+		RedirectURI  string
+		ReqPath      string
+	}{clientName, false, frontchannelURIs, redirectURI, r.URL.Path}
+	return renderTemplate(w, s.templates[tmplLogout], data)
+}
+
 func (s *WebSite) RenderError(r *http.Request, w http.ResponseWriter, errCode int, errMsg string) error {
 	w.WriteHeader(errCode)
 	data := struct {
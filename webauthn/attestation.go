@@ -0,0 +1,75 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"slices"
+)
+
+// oidAAGUID is the certificate extension carrying the AAGUID of the authenticator model.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyPacked verifies a packed attestation statement over the signed data,
+// the authenticator data followed by the client data hash. Without a
+// certificate the credential key signs itself.
+// https://www.w3.org/TR/webauthn-3/#sctn-packed-attestation
+func verifyPacked(attStmt map[any]any, key *PublicKey, authData *AuthenticatorData, signed []byte) error {
+	alg, _ := attStmt["alg"].(int64)
+	sig, _ := attStmt["sig"].([]byte)
+	if len(sig) == 0 {
+		return errors.New("missing signature")
+	}
+	x5c, ok := attStmt["x5c"].([]any)
+	if !ok {
+		if alg != key.Algorithm {
+			return errors.New("self attestation algorithm does not match the credential")
+		}
+		return key.Verify(signed, sig)
+	}
+	if len(x5c) == 0 {
+		return errors.New("empty certificate chain")
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	if err := verifyPackedCertificate(cert, authData.AAGUID); err != nil {
+		return err
+	}
+	if !slices.Contains(Algorithms, alg) {
+		return errors.New("unsupported algorithm")
+	}
+	return verifySignature(alg, cert.PublicKey, signed, sig)
+}
+
+// verifyPackedCertificate checks the requirements of packed attestation certificates.
+// https://www.w3.org/TR/webauthn-3/#sctn-packed-attestation-cert-requirements
+func verifyPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 {
+		return errors.New("attestation certificate is not version 3")
+	}
+	subject := cert.Subject
+	if len(subject.Country) == 0 || len(subject.Organization) == 0 || subject.CommonName == "" ||
+		!slices.Contains(subject.OrganizationalUnit, "Authenticator Attestation") {
+		return errors.New("invalid attestation certificate subject")
+	}
+	if cert.IsCA {
+		return errors.New("attestation certificate is a CA")
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return errors.New("critical AAGUID extension")
+		}
+		var v []byte
+		if _, err := asn1.Unmarshal(ext.Value, &v); err != nil || !bytes.Equal(v, aaguid) {
+			return errors.New("attestation certificate AAGUID mismatch")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// COSE algorithms of credential public keys.
+// https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// Algorithms are the supported algorithms, in the order of preference.
+var Algorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters.
+// https://www.rfc-editor.org/rfc/rfc9053#section-7
+const (
+	coseKty int64 = 1
+	coseAlg int64 = 3
+	coseCrv int64 = -1 // the modulus n of RSA keys
+	coseX   int64 = -2 // the exponent e of RSA keys
+	coseY   int64 = -3
+
+	coseKtyOKP int64 = 1
+	coseKtyEC2 int64 = 2
+	coseKtyRSA int64 = 3
+
+	coseCrvP256    int64 = 1
+	coseCrvEd25519 int64 = 6
+)
+
+// PublicKey is a credential public key.
+type PublicKey struct {
+	Algorithm int64
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key of one of the supported algorithms.
+// https://www.w3.org/TR/webauthn-3/#sctn-encoded-credPubKey-examples
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	v, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after the public key")
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, errors.New("public key is not a map")
+	}
+	kty, _ := m[coseKty].(int64)
+	alg, _ := m[coseAlg].(int64)
+	if !slices.Contains(Algorithms, alg) {
+		return nil, fmt.Errorf("unsupported algorithm %d", alg)
+	}
+	crv, _ := m[coseCrv].(int64)
+	x, _ := m[coseX].([]byte)
+	y, _ := m[coseY].([]byte)
+	switch {
+	case alg == AlgES256 && kty == coseKtyEC2 && crv == coseCrvP256:
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), slices.Concat([]byte{4}, x, y))
+		if err != nil {
+			return nil, err
+		}
+		return &PublicKey{Algorithm: alg, Key: key}, nil
+	case alg == AlgEdDSA && kty == coseKtyOKP && crv == coseCrvEd25519:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return &PublicKey{Algorithm: alg, Key: ed25519.PublicKey(x)}, nil
+	case alg == AlgRS256 && kty == coseKtyRSA:
+		n, _ := m[coseCrv].([]byte)
+		if len(n) < 256 || len(x) == 0 || len(x) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		e := new(big.Int).SetBytes(x)
+		return &PublicKey{Algorithm: alg, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(e.Int64())}}, nil
+	default:
+		return nil, fmt.Errorf("key type %d does not match algorithm %d", kty, alg)
+	}
+}
+
+// Verify checks the signature of the data.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	return verifySignature(k.Algorithm, k.Key, data, sig)
+}
+
+// verifySignature checks a signature of the algorithm with the key, which may
+// also come from an attestation certificate.
+func verifySignature(alg int64, key crypto.PublicKey, data, sig []byte) error {
+	ok := false
+	switch alg {
+	case AlgES256:
+		k, isECDSA := key.(*ecdsa.PublicKey)
+		sum := sha256.Sum256(data)
+		ok = isECDSA && ecdsa.VerifyASN1(k, sum[:], sig)
+	case AlgEdDSA:
+		k, isEd25519 := key.(ed25519.PublicKey)
+		ok = isEd25519 && ed25519.Verify(k, data, sig)
+	case AlgRS256:
+		k, isRSA := key.(*rsa.PublicKey)
+		sum := sha256.Sum256(data)
+		ok = isRSA && rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+	default:
+		return fmt.Errorf("unsupported algorithm %d", alg)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
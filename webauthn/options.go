@@ -0,0 +1,128 @@
+package webauthn
+
+import "time"
+
+// Values of the user verification and resident key requirements.
+const (
+	Required    = "required"
+	Preferred   = "preferred"
+	Discouraged = "discouraged"
+)
+
+// The JSON serialization of the options and responses, as accepted by
+// PublicKeyCredential.parseCreationOptionsFromJSON and produced by
+// PublicKeyCredential.toJSON in the browser.
+// https://www.w3.org/TR/webauthn-3/#sctn-parseCreationOptionsFromJSON
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions are the options of navigator.credentials.create.
+type CreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation,omitempty"`
+}
+
+// RequestOptions are the options of navigator.credentials.get.
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+// AttestationResponse is the response of navigator.credentials.create.
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes    `json:"clientDataJSON"`
+		AttestationObject Bytes    `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the response of navigator.credentials.get.
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// CreationOptions returns the options registering a discoverable credential
+// of the user, excluding the credentials the user has already. Passkeys are
+// asked for without attestation, which most authenticators only provide
+// with enterprise policies.
+func (rp *RelyingParty) CreationOptions(challenge Bytes, user UserEntity, exclude [][]byte, timeout time.Duration) *CreationOptions {
+	opts := &CreationOptions{
+		RP:        RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:      user,
+		Challenge: challenge,
+		Timeout:   timeout.Milliseconds(),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      Required,
+			UserVerification: Preferred,
+		},
+		Attestation: "none",
+	}
+	for _, alg := range Algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	for _, id := range exclude {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return opts
+}
+
+// RequestOptions returns the options asking for one of the allowed
+// credentials, or any discoverable credential when none are given.
+func (rp *RelyingParty) RequestOptions(challenge Bytes, allow [][]byte, userVerification string, timeout time.Duration) *RequestOptions {
+	opts := &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeout.Milliseconds(),
+		RPID:             rp.ID,
+		UserVerification: userVerification,
+	}
+	for _, id := range allow {
+		opts.AllowCredentials = append(opts.AllowCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return opts
+}
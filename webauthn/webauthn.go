@@ -0,0 +1,304 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies.
+//
+// The server hands out creation or request options carrying a random
+// challenge, the browser passes them to navigator.credentials and returns the
+// response of the authenticator, which is verified against the challenge:
+//
+//	rp := &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+//	cred, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject, false)
+//
+// The "none" and "packed" attestation formats are supported. Packed
+// attestation certificates are checked but not chained to trusted roots, the
+// server does not restrict the authenticator models.
+//
+// See https://www.w3.org/TR/webauthn-3/
+package webauthn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Authenticator data flags.
+// https://www.w3.org/TR/webauthn-3/#authdata-flags
+const (
+	FlagUserPresent    byte = 0x01
+	FlagUserVerified   byte = 0x04
+	FlagBackupEligible byte = 0x08
+	FlagBackedUp       byte = 0x10
+	FlagAttested       byte = 0x40
+	FlagExtensions     byte = 0x80
+)
+
+// Client data types of the ceremonies.
+const (
+	TypeCreate = "webauthn.create"
+	TypeGet    = "webauthn.get"
+)
+
+// ErrSignCount reports a signature counter that did not increase, a sign of a
+// cloned authenticator.
+var ErrSignCount = errors.New("signature counter did not increase")
+
+// Bytes is binary data encoded as unpadded base64url in JSON, the encoding of
+// the JSON serialization of WebAuthn options and responses.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// NewChallenge returns a random challenge of 32 bytes.
+func NewChallenge() Bytes {
+	b := make(Bytes, 32)
+	rand.Read(b)
+	return b
+}
+
+// ClientData is the data the browser passes to the authenticator.
+// https://www.w3.org/TR/webauthn-3/#dictdef-collectedclientdata
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   Bytes  `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// ParseClientData decodes the clientDataJSON of a response.
+func ParseClientData(data []byte) (*ClientData, error) {
+	var cd ClientData
+	if err := json.Unmarshal(data, &cd); err != nil {
+		return nil, fmt.Errorf("malformed client data: %w", err)
+	}
+	return &cd, nil
+}
+
+// AuthenticatorData is the data signed by the authenticator.
+// https://www.w3.org/TR/webauthn-3/#sctn-authenticator-data
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// AAGUID, CredentialID and PublicKey are the attested credential data,
+	// only present in registrations.
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// Has reports whether the flag is set.
+func (d *AuthenticatorData) Has(flag byte) bool {
+	return d.Flags&flag != 0
+}
+
+// ParseAuthenticatorData decodes authenticator data.
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+	d := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if d.Has(FlagAttested) {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		d.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return nil, errors.New("credential id too short")
+		}
+		d.CredentialID, rest = rest[:n], rest[n:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("malformed credential public key: %w", err)
+		}
+		d.PublicKey, rest = rest[:len(rest)-len(after)], after
+	}
+	if d.Has(FlagExtensions) {
+		// extensions are not requested, their outputs are skipped
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, fmt.Errorf("malformed extensions: %w", err)
+		}
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing authenticator data")
+	}
+	return d, nil
+}
+
+// RelyingParty verifies the ceremonies of a relying party.
+type RelyingParty struct {
+	// ID is the domain credentials are scoped to.
+	ID   string
+	Name string
+	// Origins are the origins of the pages running the ceremonies.
+	Origins []string
+}
+
+// Credential is a registered credential.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	Algorithm int64
+	SignCount uint32
+	AAGUID    []byte
+	// Attestation is the attestation format of the registration.
+	Attestation    string
+	UserVerified   bool
+	BackupEligible bool
+	BackedUp       bool
+}
+
+// verifyClientData checks the type, challenge and origin of the client data.
+func (rp *RelyingParty) verifyClientData(typ string, challenge, clientDataJSON []byte) error {
+	cd, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("client data type %q, want %q", cd.Type, typ)
+	}
+	if subtle.ConstantTimeCompare(cd.Challenge, challenge) != 1 {
+		return errors.New("challenge mismatch")
+	}
+	if !slices.Contains(rp.Origins, cd.Origin) {
+		return fmt.Errorf("origin %s is not allowed", cd.Origin)
+	}
+	if cd.CrossOrigin {
+		return errors.New("cross origin ceremonies are not allowed")
+	}
+	return nil
+}
+
+// verifyAuthenticatorData checks the relying party and the user flags.
+func (rp *RelyingParty) verifyAuthenticatorData(d *AuthenticatorData, requireUV bool) error {
+	sum := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(d.RPIDHash, sum[:]) != 1 {
+		return errors.New("relying party id mismatch")
+	}
+	if !d.Has(FlagUserPresent) {
+		return errors.New("user not present")
+	}
+	if requireUV && !d.Has(FlagUserVerified) {
+		return errors.New("user not verified")
+	}
+	return nil
+}
+
+// VerifyRegistration verifies the response of navigator.credentials.create
+// to the challenge and returns the new credential.
+// https://www.w3.org/TR/webauthn-3/#sctn-registering-a-new-credential
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte, requireUV bool) (*Credential, error) {
+	if err := rp.verifyClientData(TypeCreate, challenge, clientDataJSON); err != nil {
+		return nil, err
+	}
+	v, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("malformed attestation object: %w", err)
+	}
+	obj, ok := v.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("malformed attestation object")
+	}
+	format, _ := obj["fmt"].(string)
+	attStmt, _ := obj["attStmt"].(map[any]any)
+	rawAuthData, _ := obj["authData"].([]byte)
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+	if !authData.Has(FlagAttested) {
+		return nil, errors.New("no attested credential data")
+	}
+	if len(authData.CredentialID) > 1023 {
+		return nil, errors.New("credential id too long")
+	}
+	key, err := ParsePublicKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	switch format {
+	case "none":
+		if len(attStmt) != 0 {
+			return nil, errors.New("none attestation with a statement")
+		}
+	case "packed":
+		if err := verifyPacked(attStmt, key, authData, slices.Concat(rawAuthData, clientDataHash[:])); err != nil {
+			return nil, fmt.Errorf("packed attestation: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported attestation format %q", format)
+	}
+	return &Credential{
+		ID:             authData.CredentialID,
+		PublicKey:      authData.PublicKey,
+		Algorithm:      key.Algorithm,
+		SignCount:      authData.SignCount,
+		AAGUID:         authData.AAGUID,
+		Attestation:    format,
+		UserVerified:   authData.Has(FlagUserVerified),
+		BackupEligible: authData.Has(FlagBackupEligible),
+		BackedUp:       authData.Has(FlagBackedUp),
+	}, nil
+}
+
+// VerifyAssertion verifies the response of navigator.credentials.get to the
+// challenge with the stored public key and sign count of the credential.
+// ErrSignCount is returned when the counter did not increase.
+// https://www.w3.org/TR/webauthn-3/#sctn-verifying-assertion
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, publicKey []byte, signCount uint32, clientDataJSON, authenticatorData, signature []byte, requireUV bool) (*AuthenticatorData, error) {
+	if err := rp.verifyClientData(TypeGet, challenge, clientDataJSON); err != nil {
+		return nil, err
+	}
+	authData, err := ParseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := key.Verify(slices.Concat(authenticatorData, clientDataHash[:]), signature); err != nil {
+		return nil, err
+	}
+	// authenticators without a counter always report zero
+	if (authData.SignCount != 0 || signCount != 0) && authData.SignCount <= signCount {
+		return authData, ErrSignCount
+	}
+	return authData, nil
+}
@@ -0,0 +1,91 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/webauthn"
+	"sutext.github.io/entry/webauthn/webauthntest"
+)
+
+const origin = "https://example.com"
+
+var rp = &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{origin}}
+
+func register(t *testing.T, a *webauthntest.Authenticator, format string) *webauthn.Credential {
+	t.Helper()
+	challenge := webauthn.NewChallenge()
+	opts := rp.CreationOptions(challenge, webauthn.UserEntity{ID: []byte("user"), Name: "alice"}, nil, time.Minute)
+	resp := a.Create(origin, opts, format)
+	cred, err := rp.VerifyRegistration(challenge, resp.Response.ClientDataJSON, resp.Response.AttestationObject, true)
+	if err != nil {
+		t.Fatalf("%s registration: %v", format, err)
+	}
+	return cred
+}
+
+func TestRegistration(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		a := webauthntest.New()
+		cred := register(t, a, format)
+		if string(cred.ID) != string(a.CredentialID) || cred.Algorithm != webauthn.AlgES256 || cred.Attestation != format || !cred.UserVerified {
+			t.Errorf("%s credential = %+v", format, cred)
+		}
+	}
+
+	a := webauthntest.New()
+	challenge := webauthn.NewChallenge()
+	opts := rp.CreationOptions(challenge, webauthn.UserEntity{ID: []byte("user")}, nil, time.Minute)
+	resp := a.Create(origin, opts, "none")
+	if _, err := rp.VerifyRegistration(webauthn.NewChallenge(), resp.Response.ClientDataJSON, resp.Response.AttestationObject, false); err == nil {
+		t.Error("registration with another challenge accepted")
+	}
+	resp = a.Create("https://evil.example", opts, "none")
+	if _, err := rp.VerifyRegistration(challenge, resp.Response.ClientDataJSON, resp.Response.AttestationObject, false); err == nil {
+		t.Error("registration from another origin accepted")
+	}
+	opts.RP.ID = "evil.example"
+	resp = a.Create(origin, opts, "none")
+	if _, err := rp.VerifyRegistration(challenge, resp.Response.ClientDataJSON, resp.Response.AttestationObject, false); err == nil {
+		t.Error("registration for another relying party accepted")
+	}
+	a.Flags = 0
+	resp = a.Create(origin, rp.CreationOptions(challenge, webauthn.UserEntity{ID: []byte("user")}, nil, time.Minute), "none")
+	if _, err := rp.VerifyRegistration(challenge, resp.Response.ClientDataJSON, resp.Response.AttestationObject, true); err == nil {
+		t.Error("registration without user verification accepted")
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	a := webauthntest.New()
+	cred := register(t, a, "none")
+	assert := func(signCount uint32) (*webauthn.AuthenticatorData, error) {
+		challenge := webauthn.NewChallenge()
+		resp := a.Get(origin, rp.RequestOptions(challenge, [][]byte{cred.ID}, webauthn.Required, time.Minute))
+		return rp.VerifyAssertion(challenge, cred.PublicKey, signCount, resp.Response.ClientDataJSON,
+			resp.Response.AuthenticatorData, resp.Response.Signature, true)
+	}
+	data, err := assert(cred.SignCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.SignCount != cred.SignCount+1 {
+		t.Errorf("sign count = %d", data.SignCount)
+	}
+	if _, err := assert(data.SignCount + 5); !errors.Is(err, webauthn.ErrSignCount) {
+		t.Errorf("regressed counter: err = %v", err)
+	}
+	a.Counterless, a.SignCount = true, 0
+	if _, err := assert(0); err != nil {
+		t.Errorf("authenticator without counter: %v", err)
+	}
+
+	challenge := webauthn.NewChallenge()
+	resp := a.Get(origin, rp.RequestOptions(challenge, nil, webauthn.Required, time.Minute))
+	resp.Response.Signature[len(resp.Response.Signature)-1] ^= 1
+	if _, err := rp.VerifyAssertion(challenge, cred.PublicKey, 0, resp.Response.ClientDataJSON,
+		resp.Response.AuthenticatorData, resp.Response.Signature, false); err == nil {
+		t.Error("tampered signature accepted")
+	}
+}
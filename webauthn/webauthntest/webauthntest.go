@@ -0,0 +1,179 @@
+// Package webauthntest provides a software authenticator for testing WebAuthn
+// relying parties.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	"sutext.github.io/entry/webauthn"
+)
+
+// Authenticator is a software authenticator holding a single ES256 credential.
+type Authenticator struct {
+	Key          *ecdsa.PrivateKey
+	CredentialID []byte
+	AAGUID       []byte
+	// SignCount is incremented by every ceremony, unless Counterless is set.
+	SignCount   uint32
+	Counterless bool
+	// UserHandle is the user id of the registration.
+	UserHandle []byte
+	// Flags are added to the user present flag of every response.
+	Flags byte
+}
+
+// New returns an authenticator verifying the user.
+func New() *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &Authenticator{Key: key, CredentialID: id, AAGUID: make([]byte, 16), Flags: webauthn.FlagUserVerified}
+}
+
+// Create answers the creation options with a registration in the attestation
+// format, "none" or a "packed" self attestation.
+func (a *Authenticator) Create(origin string, opts *webauthn.CreationOptions, format string) *webauthn.AttestationResponse {
+	a.UserHandle = opts.User.ID
+	clientData := a.clientData(webauthn.TypeCreate, origin, opts.Challenge)
+	authData := a.authData(opts.RP.ID, true)
+	attStmt := map[any]any{}
+	if format == "packed" {
+		sum := sha256.Sum256(clientData)
+		attStmt["alg"] = webauthn.AlgES256
+		attStmt["sig"] = a.sign(slices.Concat(authData, sum[:]))
+	}
+	resp := &webauthn.AttestationResponse{ID: encodeID(a.CredentialID), RawID: a.CredentialID, Type: "public-key"}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AttestationObject = encode(map[any]any{"fmt": format, "authData": authData, "attStmt": attStmt})
+	return resp
+}
+
+// Get answers the request options with an assertion.
+func (a *Authenticator) Get(origin string, opts *webauthn.RequestOptions) *webauthn.AssertionResponse {
+	clientData := a.clientData(webauthn.TypeGet, origin, opts.Challenge)
+	authData := a.authData(opts.RPID, false)
+	sum := sha256.Sum256(clientData)
+	resp := &webauthn.AssertionResponse{ID: encodeID(a.CredentialID), RawID: a.CredentialID, Type: "public-key"}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = a.sign(slices.Concat(authData, sum[:]))
+	resp.Response.UserHandle = a.UserHandle
+	return resp
+}
+
+func (a *Authenticator) clientData(typ, origin string, challenge []byte) []byte {
+	b, _ := json.Marshal(webauthn.ClientData{Type: typ, Challenge: challenge, Origin: origin})
+	return b
+}
+
+func (a *Authenticator) authData(rpID string, attested bool) []byte {
+	if !a.Counterless {
+		a.SignCount++
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags := webauthn.FlagUserPresent | a.Flags
+	if attested {
+		flags |= webauthn.FlagAttested
+	}
+	data := binary.BigEndian.AppendUint32(append(rpIDHash[:], flags), a.SignCount)
+	if attested {
+		data = append(data, a.AAGUID...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.CredentialID)))
+		data = append(data, a.CredentialID...)
+		data = append(data, a.PublicKey()...)
+	}
+	return data
+}
+
+// PublicKey returns the COSE key of the credential.
+func (a *Authenticator) PublicKey() []byte {
+	point, err := a.Key.PublicKey.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	return encode(map[any]any{
+		int64(1):  int64(2),
+		int64(3):  webauthn.AlgES256,
+		int64(-1): int64(1),
+		int64(-2): point[1:33],
+		int64(-3): point[33:],
+	})
+}
+
+func (a *Authenticator) sign(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, a.Key, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+func encodeID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// encode returns the CBOR encoding of integers, byte strings, text and maps
+// with sorted keys.
+func encode(v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		keys := make([][]byte, 0, len(v))
+		items := make(map[string][]byte, len(v))
+		for k, item := range v {
+			ek := encode(k)
+			keys = append(keys, ek)
+			items[string(ek)] = encode(item)
+		}
+		// canonical CBOR orders keys by their encoding
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return string(keys[i]) < string(keys[j])
+		})
+		out := head(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, k...), items[string(k)]...)
+		}
+		return out
+	default:
+		panic(fmt.Sprintf("webauthntest: cannot encode %T", v))
+	}
+}
+
+func head(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+}
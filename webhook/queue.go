@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sutext.github.io/entry/xlog"
+)
+
+// Delivery is a notification posted by a Queue.
+type Delivery struct {
+	// Target names the receiver in logs, e.g. the webhook or client ID.
+	Target string
+	// Request returns the request of an attempt, it is called for every attempt.
+	Request func() (*http.Request, error)
+	// GiveUp is called with the number of attempts made and the last error when
+	// the delivery is given up. It may be nil.
+	GiveUp func(attempts int, err error)
+}
+
+// Queue posts deliveries in the background and retries failed ones with
+// exponential backoff. It carries webhook events as well as other
+// notifications, e.g. back-channel logout tokens.
+type Queue struct {
+	name  string
+	opts  Options
+	queue chan Delivery
+	wg    sync.WaitGroup
+	stop  chan struct{}
+	// mu guards closed, so that no delivery is sent on the queue after Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQueue starts the workers of a queue, name tells its deliveries apart in logs.
+func NewQueue(name string, opts Options) *Queue {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = xlog.Default
+	}
+	q := &Queue{
+		name:  name,
+		opts:  opts,
+		queue: make(chan Delivery, opts.QueueSize),
+		stop:  make(chan struct{}),
+	}
+	for range opts.Workers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Close stops accepting deliveries and drains the queue. Every queued delivery
+// is attempted once more; the ones failing are given up without waiting for
+// their retries.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.stop)
+	close(q.queue)
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// Enqueue queues the delivery, which is given up at once when the queue is
+// full or closed.
+func (q *Queue) Enqueue(d Delivery) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.giveUp(d, 0, fmt.Errorf("%s queue is closed", q.name))
+		return
+	}
+	select {
+	case q.queue <- d:
+	default:
+		q.giveUp(d, 0, fmt.Errorf("%s queue is full", q.name))
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for d := range q.queue {
+		q.deliver(d)
+	}
+}
+
+func (q *Queue) deliver(d Delivery) {
+	var err error
+	delay := q.opts.Backoff
+	for attempt := 1; attempt <= q.opts.MaxAttempts; attempt++ {
+		if err = q.post(d); err == nil {
+			return
+		}
+		q.opts.Logger.Warn(q.name+" delivery failed",
+			xlog.Str("target", d.Target),
+			xlog.Int("attempt", attempt),
+			xlog.Err(err),
+		)
+		if attempt == q.opts.MaxAttempts {
+			break
+		}
+		select {
+		case <-q.stop:
+			q.giveUp(d, attempt, err)
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	q.giveUp(d, q.opts.MaxAttempts, err)
+}
+
+func (q *Queue) post(d Delivery) error {
+	req, err := d.Request()
+	if err != nil {
+		return err
+	}
+	resp, err := q.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (q *Queue) giveUp(d Delivery, attempts int, err error) {
+	q.opts.Logger.Error("gave up "+q.name+" delivery", xlog.Str("target", d.Target), xlog.Int("attempts", attempts), xlog.Err(err))
+	if d.GiveUp != nil {
+		d.GiveUp(attempts, err)
+	}
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"context"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xlog"
+	"sutext.github.io/suid"
+)
+
+type clientView struct {
+	ID     string             `json:"id"`
+	Name   string             `json:"name"`
+	Type   model.ClientType   `json:"type"`
+	Status model.ClientStatus `json:"status"`
+}
+
+// storage publishes lifecycle events for user and client mutations
+// made through the wrapped storage.
+type storage struct {
+	model.Storage
+	d *Dispatcher
+}
+
+// Wrap returns a storage which publishes events to d after successful
+// user and client mutations.
+func Wrap(db model.Storage, d *Dispatcher) model.Storage {
+	return &storage{Storage: db, d: d}
+}
+
+func (s *storage) publish(ctx context.Context, typ string, data any) {
+	if err := s.d.Publish(ctx, typ, data); err != nil {
+		s.d.logger.Error("failed to publish webhook event", xlog.Str("event", typ), xlog.Err(err))
+	}
+}
+
+func (s *storage) CreateUser(ctx context.Context, user *model.User) error {
+	if err := s.Storage.CreateUser(ctx, user); err != nil {
+		return err
+	}
+	s.publish(ctx, UserRegistered, user.ToView())
+	return nil
+}
+
+func (s *storage) UpdateUser(ctx context.Context, user *model.User) error {
+	old, err := s.Storage.GetUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.UpdateUser(ctx, user); err != nil {
+		return err
+	}
+	if old.Hash != user.Hash {
+		s.publish(ctx, UserPasswordChanged, user.ToView())
+	} else {
+		s.publish(ctx, UserUpdated, user.ToView())
+	}
+	return nil
+}
+
+func (s *storage) DeleteUser(ctx context.Context, id suid.SUID) error {
+	if err := s.Storage.DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	s.publish(ctx, UserDeleted, map[string]uint64{"id": uint64(id)})
+	return nil
+}
+
+func (s *storage) UpdateClient(ctx context.Context, id string, updater func(c *model.Client) (*model.Client, error)) error {
+	var banned *model.Client
+	err := s.Storage.UpdateClient(ctx, id, func(c *model.Client) (*model.Client, error) {
+		wasBanned := c.Status == model.ClientStatusBanned
+		nc, err := updater(c)
+		if err == nil && !wasBanned && nc.Status == model.ClientStatusBanned {
+			banned = nc
+		}
+		return nc, err
+	})
+	if err != nil {
+		return err
+	}
+	if banned != nil {
+		s.publish(ctx, ClientBanned, clientView{
+			ID:     banned.ID,
+			Name:   banned.Name,
+			Type:   banned.Type,
+			Status: banned.Status,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package webhook delivers identity lifecycle events to subscribed HTTP endpoints.
+//
+// Every delivery is a JSON POST signed with the subscription secret. The signature
+// is sent in the Entry-Signature header as "t=<unix time>,v1=<hex hmac>", where the
+// HMAC-SHA256 covers "<unix time>.<body>". Failed deliveries are retried with
+// exponential backoff and end up as model.DeadLetter rows once retries are exhausted.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sutext.github.io/entry/model"
+	"sutext.github.io/entry/xlog"
+	"sutext.github.io/suid/guid"
+)
+
+// Event types
+const (
+	UserRegistered      = "user.registered"
+	UserUpdated         = "user.updated"
+	UserPasswordChanged = "user.password_changed"
+	UserDeleted         = "user.deleted"
+	ClientBanned        = "client.banned"
+)
+
+const SignatureHeader = "Entry-Signature"
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+type Options struct {
+	// MaxAttempts is the number of deliveries tried before dead-lettering.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles on every attempt.
+	Backoff time.Duration
+	// Workers is the number of concurrent deliveries.
+	Workers int
+	// QueueSize bounds the number of pending deliveries.
+	QueueSize int
+	Client    *http.Client
+	Logger    *xlog.Logger
+}
+
+type Dispatcher struct {
+	db     model.Storage
+	queue  *Queue
+	logger *xlog.Logger
+}
+
+func NewDispatcher(db model.Storage, opts Options) *Dispatcher {
+	if opts.Logger == nil {
+		opts.Logger = xlog.Default
+	}
+	return &Dispatcher{
+		db:     db,
+		queue:  NewQueue("webhook", opts),
+		logger: opts.Logger,
+	}
+}
+
+// Close stops accepting deliveries and drains the queue. Every queued delivery
+// is attempted once more; the ones failing are dead-lettered without waiting
+// for their retries.
+func (d *Dispatcher) Close() {
+	d.queue.Close()
+}
+
+// Publish enqueues the event for every subscribed webhook.
+func (d *Dispatcher) Publish(ctx context.Context, typ string, data any) error {
+	hooks, err := d.db.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Event{
+		ID:   guid.New().String(),
+		Type: typ,
+		Time: time.Now(),
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if h.Subscribed(typ) {
+			d.enqueue(h, typ, payload)
+		}
+	}
+	return nil
+}
+
+// Replay redelivers a dead letter. The dead letter is removed once it is queued
+// and recreated if the redelivery fails again.
+func (d *Dispatcher) Replay(ctx context.Context, id string) error {
+	dl, err := d.db.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+	hook, err := d.db.GetWebhook(ctx, dl.WebhookID)
+	if err != nil {
+		return err
+	}
+	if err := d.db.DeleteDeadLetter(ctx, id); err != nil {
+		return err
+	}
+	d.enqueue(hook, dl.Event, []byte(dl.Payload))
+	return nil
+}
+
+// enqueue queues the signed delivery of the payload to the hook, it is
+// dead-lettered when given up.
+func (d *Dispatcher) enqueue(hook *model.Webhook, event string, payload []byte) {
+	d.queue.Enqueue(Delivery{
+		Target: hook.ID,
+		Request: func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(SignatureHeader, Sign([]byte(hook.Secret), time.Now(), payload))
+			return req, nil
+		},
+		GiveUp: func(attempts int, cause error) {
+			d.deadLetter(hook, event, payload, attempts, cause)
+		},
+	})
+}
+
+func (d *Dispatcher) deadLetter(hook *model.Webhook, event string, payload []byte, attempts int, cause error) {
+	dl := &model.DeadLetter{
+		ID:        guid.New().String(),
+		WebhookID: hook.ID,
+		Event:     event,
+		Payload:   string(payload),
+		Attempts:  attempts,
+		LastError: cause.Error(),
+		CreatedAt: time.Now(),
+	}
+	if err := d.db.CreateDeadLetter(context.Background(), dl); err != nil {
+		d.logger.Error("failed to store webhook dead letter", xlog.Str("webhook", hook.ID), xlog.Err(err))
+	}
+}
+
+// Sign returns the signature header value for the body sent at t.
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+// Verify checks a signature header produced by Sign and rejects signatures older than tolerance.
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration) error {
+	var ts, sig string
+	for part := range strings.SplitSeq(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+	if tolerance > 0 && time.Since(time.Unix(unix, 0)) > tolerance {
+		return fmt.Errorf("signature expired")
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, ts, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func mac(secret []byte, ts string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
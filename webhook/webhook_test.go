@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sutext.github.io/entry/model"
+)
+
+type hookStorage struct {
+	model.Storage
+	hook *model.Webhook
+	mu   sync.Mutex
+	dead []*model.DeadLetter
+}
+
+func (s *hookStorage) ListWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	return []*model.Webhook{s.hook}, nil
+}
+
+func (s *hookStorage) CreateDeadLetter(ctx context.Context, d *model.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dead = append(s.dead, d)
+	return nil
+}
+
+// receiver fails the first failures deliveries and verifies the signature of the others.
+func receiver(t *testing.T, secret string, failures int32) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := Verify([]byte(secret), r.Header.Get(SignatureHeader), body, time.Minute); err != nil {
+			t.Errorf("delivery signature: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"type":"user.registered"}`)
+	header := Sign(secret, time.Now(), body)
+	if err := Verify(secret, header, body, time.Minute); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if err := Verify([]byte("other"), header, body, time.Minute); err == nil {
+		t.Errorf("verify should fail with another secret")
+	}
+	if err := Verify(secret, header, []byte(`{}`), time.Minute); err == nil {
+		t.Errorf("verify should fail with another body")
+	}
+	old := Sign(secret, time.Now().Add(-time.Hour), body)
+	if err := Verify(secret, old, body, time.Minute); err == nil {
+		t.Errorf("verify should reject expired signatures")
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	w := &model.Webhook{Active: true, Events: model.Strings{"user.*", ClientBanned}}
+	for event, want := range map[string]bool{
+		UserRegistered: true,
+		UserDeleted:    true,
+		ClientBanned:   true,
+		"client.other": false,
+	} {
+		if got := w.Subscribed(event); got != want {
+			t.Errorf("Subscribed(%q) = %v, want %v", event, got, want)
+		}
+	}
+	w.Active = false
+	if w.Subscribed(UserRegistered) {
+		t.Errorf("inactive webhook should not be subscribed")
+	}
+}
+
+func TestDeliveryRetry(t *testing.T) {
+	srv, calls := receiver(t, "secret", 2)
+	db := &hookStorage{hook: &model.Webhook{ID: "hook", URL: srv.URL, Secret: "secret", Active: true}}
+	d := NewDispatcher(db, Options{MaxAttempts: 3, Backoff: time.Millisecond, Workers: 1})
+	if err := d.Publish(context.Background(), UserRegistered, map[string]string{"id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	for calls.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	d.Close()
+	if n := calls.Load(); n != 3 || len(db.dead) != 0 {
+		t.Errorf("%d attempts, %d dead letters", n, len(db.dead))
+	}
+}
+
+func TestDeliveryDeadLetter(t *testing.T) {
+	srv, calls := receiver(t, "secret", 100)
+	db := &hookStorage{hook: &model.Webhook{ID: "hook", URL: srv.URL, Secret: "secret", Active: true}}
+	d := NewDispatcher(db, Options{MaxAttempts: 2, Backoff: time.Millisecond, Workers: 1})
+	d.Publish(context.Background(), UserDeleted, nil)
+	for calls.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	d.Close()
+	if len(db.dead) != 1 || db.dead[0].Attempts != 2 || db.dead[0].Event != UserDeleted {
+		t.Fatalf("dead letters = %+v", db.dead)
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	srv, calls := receiver(t, "secret", 0)
+	db := &hookStorage{hook: &model.Webhook{ID: "hook", URL: srv.URL, Secret: "secret", Active: true}}
+	d := NewDispatcher(db, Options{Workers: 1})
+	for range 10 {
+		d.Publish(context.Background(), UserUpdated, nil)
+	}
+	d.Close()
+	if n := calls.Load(); n != 10 || len(db.dead) != 0 {
+		t.Errorf("%d of 10 queued deliveries sent before close, %d dead letters", n, len(db.dead))
+	}
+	d.Publish(context.Background(), UserUpdated, nil)
+	if len(db.dead) != 1 {
+		t.Errorf("event published after close was not dead-lettered")
+	}
+}
@@ -63,38 +63,92 @@ var (
 	ErrCodeChallengeRquired           = errors.New("invalid_request")
 	ErrUnsupportedCodeChallengeMethod = errors.New("invalid_request")
 	ErrInvalidCodeChallengeLen        = errors.New("invalid_request")
+	ErrTooManyRequests                = errors.New("too_many_requests")
+	ErrInvalidTarget                  = errors.New("invalid_target")
+	ErrInvalidAuthorizationDetails    = errors.New("invalid_authorization_details")
 )
 
+// https://www.rfc-editor.org/rfc/rfc7009#section-2.2.1
+var ErrUnsupportedTokenType = errors.New("unsupported_token_type")
+
+// https://www.rfc-editor.org/rfc/rfc6750#section-3.1
+// https://www.rfc-editor.org/rfc/rfc9449#section-12.2
+var (
+	ErrInvalidToken      = errors.New("invalid_token")
+	ErrInsufficientScope = errors.New("insufficient_scope")
+	ErrInvalidDPoPProof  = errors.New("invalid_dpop_proof")
+)
+
+// https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+var (
+	ErrInteractionRequired      = errors.New("interaction_required")
+	ErrLoginRequired            = errors.New("login_required")
+	ErrAccountSelectionRequired = errors.New("account_selection_required")
+	ErrConsentRequired          = errors.New("consent_required")
+)
+
+// https://openid.net/specs/openid-connect-unmet-authentication-requirements-1_0.html
+var ErrUnmetAuthenticationRequirements = errors.New("unmet_authentication_requirements")
+
+// ErrMFARequired asks for a second factor of the end-user.
+var ErrMFARequired = errors.New("mfa_required")
+
 // Descriptions error description
 var Descriptions = map[error]string{
-	ErrInvalidRequest:                 "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed",
-	ErrUnauthorizedClient:             "The client is not authorized to request an authorization code using this method",
-	ErrAccessDenied:                   "The resource owner or authorization server denied the request",
-	ErrUnsupportedResponseType:        "The authorization server does not support obtaining an authorization code using this method",
-	ErrInvalidScope:                   "The requested scope is invalid, unknown, or malformed",
-	ErrServerError:                    "The authorization server encountered an unexpected condition that prevented it from fulfilling the request",
-	ErrTemporarilyUnavailable:         "The authorization server is currently unable to handle the request due to a temporary overloading or maintenance of the server",
-	ErrInvalidClient:                  "Client authentication failed",
-	ErrInvalidGrant:                   "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client",
-	ErrUnsupportedGrantType:           "The authorization grant type is not supported by the authorization server",
-	ErrCodeChallengeRquired:           "PKCE is required. code_challenge is missing",
-	ErrUnsupportedCodeChallengeMethod: "Selected code_challenge_method not supported",
-	ErrInvalidCodeChallengeLen:        "Code challenge length must be between 43 and 128 charachters long",
+	ErrInvalidRequest:                  "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed",
+	ErrUnauthorizedClient:              "The client is not authorized to request an authorization code using this method",
+	ErrAccessDenied:                    "The resource owner or authorization server denied the request",
+	ErrUnsupportedResponseType:         "The authorization server does not support obtaining an authorization code using this method",
+	ErrInvalidScope:                    "The requested scope is invalid, unknown, or malformed",
+	ErrServerError:                     "The authorization server encountered an unexpected condition that prevented it from fulfilling the request",
+	ErrTemporarilyUnavailable:          "The authorization server is currently unable to handle the request due to a temporary overloading or maintenance of the server",
+	ErrInvalidClient:                   "Client authentication failed",
+	ErrInvalidGrant:                    "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client",
+	ErrUnsupportedGrantType:            "The authorization grant type is not supported by the authorization server",
+	ErrCodeChallengeRquired:            "PKCE is required. code_challenge is missing",
+	ErrUnsupportedCodeChallengeMethod:  "Selected code_challenge_method not supported",
+	ErrInvalidCodeChallengeLen:         "Code challenge length must be between 43 and 128 charachters long",
+	ErrTooManyRequests:                 "Too many requests, retry after the time given in the Retry-After header",
+	ErrInvalidTarget:                   "The requested resource or audience is invalid, unknown, or not allowed",
+	ErrInvalidAuthorizationDetails:     "The authorization details are malformed, of an unknown type, or not allowed for the client",
+	ErrUnsupportedTokenType:            "The authorization server does not support the revocation of the presented token type",
+	ErrInvalidToken:                    "The access token provided is expired, revoked, malformed, or invalid for other reasons",
+	ErrInsufficientScope:               "The request requires higher privileges than provided by the access token",
+	ErrInvalidDPoPProof:                "The DPoP proof is missing, malformed, or does not match the request",
+	ErrInteractionRequired:             "The authorization server requires end-user interaction of some form to proceed",
+	ErrLoginRequired:                   "The authorization server requires end-user authentication",
+	ErrAccountSelectionRequired:        "The end-user is required to select a session at the authorization server",
+	ErrConsentRequired:                 "The authorization server requires end-user consent",
+	ErrUnmetAuthenticationRequirements: "The end-user cannot authenticate as strongly as requested",
+	ErrMFARequired:                     "The end-user has to authenticate with a second factor",
 }
 
 // StatusCodes response error HTTP status code
 var StatusCodes = map[error]int{
-	ErrInvalidRequest:                 400,
-	ErrUnauthorizedClient:             401,
-	ErrAccessDenied:                   403,
-	ErrUnsupportedResponseType:        401,
-	ErrInvalidScope:                   400,
-	ErrServerError:                    500,
-	ErrTemporarilyUnavailable:         503,
-	ErrInvalidClient:                  401,
-	ErrInvalidGrant:                   401,
-	ErrUnsupportedGrantType:           401,
-	ErrCodeChallengeRquired:           400,
-	ErrUnsupportedCodeChallengeMethod: 400,
-	ErrInvalidCodeChallengeLen:        400,
+	ErrInvalidRequest:                  400,
+	ErrUnauthorizedClient:              401,
+	ErrAccessDenied:                    403,
+	ErrUnsupportedResponseType:         401,
+	ErrInvalidScope:                    400,
+	ErrServerError:                     500,
+	ErrTemporarilyUnavailable:          503,
+	ErrInvalidClient:                   401,
+	ErrInvalidGrant:                    401,
+	ErrUnsupportedGrantType:            401,
+	ErrCodeChallengeRquired:            400,
+	ErrUnsupportedCodeChallengeMethod:  400,
+	ErrInvalidCodeChallengeLen:         400,
+	ErrTooManyRequests:                 429,
+	ErrInvalidTarget:                   400,
+	ErrInvalidAuthorizationDetails:     400,
+	ErrUnsupportedTokenType:            400,
+	ErrInvalidToken:                    401,
+	ErrInsufficientScope:               403,
+	ErrInvalidDPoPProof:                400,
+	ErrInteractionRequired:             400,
+	ErrLoginRequired:                   400,
+	ErrAccountSelectionRequired:        400,
+	ErrConsentRequired:                 400,
+	ErrUnmetAuthenticationRequirements: 400,
+	ErrMFARequired:                     403,
 }